@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that only valid user-service tokens get through and that the caller reaches the handler
+func TestAuthMiddleware(t *testing.T) {
+	ks := newTestKeyServer(t)
+	rsaKey := ks.publishRSA(t, "rsa")
+	edKey := ks.publishEd25519(t, "ed")
+	_, unpublished, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	inAnHour := time.Now().Add(time.Hour)
+
+	// Partial login token handed out by user-service before the second factor is checked
+	partial := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"username": "alice", "token_use": "mfa", "exp": inAnHour.Unix()})
+	partial.Header["kid"] = "ed"
+	partialToken, err := partial.SignedString(edKey)
+	assert.NoError(t, err)
+
+	var caller AuthUser
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ = authUserFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"unpublished key", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, unpublished, "ed", inAnHour), http.StatusUnauthorized},
+		{"unknown kid", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, unpublished, "other", inAnHour), http.StatusUnauthorized},
+		{"no kid", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, edKey, "", inAnHour), http.StatusUnauthorized},
+		{"shared secret", "Bearer " + signTestToken(t, jwt.SigningMethodHS256, rsaKey.N.Bytes(), "rsa", inAnHour), http.StatusUnauthorized},
+		{"algorithm of another key", "Bearer " + signTestToken(t, jwt.SigningMethodRS256, rsaKey, "ed", inAnHour), http.StatusUnauthorized},
+		{"expired", "Bearer " + signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", time.Now().Add(-time.Hour)), http.StatusUnauthorized},
+		{"partial login token", "Bearer " + partialToken, http.StatusUnauthorized},
+		{"valid RS256", "Bearer " + signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", inAnHour), http.StatusOK},
+		{"valid EdDSA", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, edKey, "ed", inAnHour), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/customer", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+	assert.Equal(t, AuthUser{Username: "alice", Role: "Sales Representative"}, caller)
+}
+
+// Test that customer management routes require a token
+func TestRoutesRequireAuth(t *testing.T) {
+	router := (&Config{}).routes()
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/get_all_customer"},
+		{http.MethodGet, "/customer"},
+		{http.MethodPut, "/update-customer"},
+		{http.MethodDelete, "/delete-customer"},
+		{http.MethodPost, "/update-password"},
+		{http.MethodGet, "/customers/trash"},
+		{http.MethodPost, "/customers/1/restore"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "%s %s should require a token", route.method, route.path)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sha1Hex returns the upper-case hex SHA-1 of a password as listed in breached password files
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Test loading a breached password file and looking passwords up
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "breached.txt")
+	content := "# Known passwords\n" + sha1Hex("Password123") + ":3861493\n\n" + strings.ToLower(sha1Hex("Qwerty123")) + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	breached, err := LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, breached.Len())
+	assert.True(t, breached.Contains("Password123"))
+	assert.True(t, breached.Contains("Qwerty123"), "Lower-case hashes are accepted")
+	assert.False(t, breached.Contains("Password124"))
+
+	var none *BreachedPasswords
+	assert.False(t, none.Contains("Password123"), "Without a file nothing is breached")
+	none, err = LoadBreachedPasswords("")
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+
+	invalid := filepath.Join(dir, "invalid.txt")
+	require.NoError(t, os.WriteFile(invalid, []byte("Password123\n"), 0o600))
+	_, err = LoadBreachedPasswords(invalid)
+	assert.ErrorContains(t, err, "invalid.txt:1")
+	_, err = LoadBreachedPasswords(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+}
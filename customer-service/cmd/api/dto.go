@@ -0,0 +1,88 @@
+package main
+
+import "time"
+
+// Request and response types of the API. Handlers decode requests into and encode responses from
+// these types only, never from the GORM models, so a column added to a model cannot be set by a
+// client or leak into a response by accident. The field names match the JSON keys clients already use.
+
+// createCustomerRequest is the body of POST /customers
+type createCustomerRequest struct {
+	Customername string
+	MailAddress  string
+	Password     string
+	Note         string
+}
+
+// loginCustomerRequest is the body of POST /customers/login
+type loginCustomerRequest struct {
+	Customername string
+	Password     string
+}
+
+// customerResponse is the JSON representation of a customer returned by the read endpoints. It leaves
+// out the password hash and the bookkeeping of verification emails and failed logins.
+type customerResponse struct {
+	ID                  uint
+	Customername        string
+	MailAddress         string
+	Activated           bool
+	LoginStatus         bool
+	Note                string
+	VerificationPending bool
+	LockedUntil         *time.Time `json:",omitempty"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           *time.Time `json:",omitempty"` // Only set for customers in the trash
+}
+
+// newCustomerResponse copies the public fields of a customer into its response form
+func newCustomerResponse(customer Customer) customerResponse {
+	response := customerResponse{
+		ID:                  customer.ID,
+		Customername:        customer.Customername,
+		MailAddress:         customer.MailAddress,
+		Activated:           customer.Activated,
+		LoginStatus:         customer.LoginStatus,
+		Note:                customer.Note,
+		VerificationPending: customer.VerificationPending,
+		LockedUntil:         customer.LockedUntil,
+		CreatedAt:           customer.CreatedAt,
+		UpdatedAt:           customer.UpdatedAt,
+	}
+	if customer.DeletedAt.Valid {
+		response.DeletedAt = &customer.DeletedAt.Time
+	}
+	return response
+}
+
+// newCustomerResponses converts a list of customers, an empty list becomes an empty JSON array
+func newCustomerResponses(customers []Customer) []customerResponse {
+	response := make([]customerResponse, 0, len(customers))
+	for _, customer := range customers {
+		response = append(response, newCustomerResponse(customer))
+	}
+	return response
+}
+
+// securityEventResponse is the JSON representation of a security event
+type securityEventResponse struct {
+	ID           uint
+	Type         string
+	Customername string
+	IPAddress    string
+	Detail       string
+	CreatedAt    time.Time
+}
+
+// newSecurityEventResponse copies a security event into its response form
+func newSecurityEventResponse(event SecurityEvent) securityEventResponse {
+	return securityEventResponse{
+		ID:           event.ID,
+		Type:         event.Type,
+		Customername: event.Customername,
+		IPAddress:    event.IPAddress,
+		Detail:       event.Detail,
+		CreatedAt:    event.CreatedAt,
+	}
+}
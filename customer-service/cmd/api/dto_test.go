@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// customerColumns are the columns of the mocked customers table
+var customerColumns = []string{"id", "customername", "mail_address", "password", "activated", "login_status", "note", "created_at", "updated_at"}
+
+// storedPasswordHash is a bcrypt hash as it is stored in the customers table
+const storedPasswordHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+
+// assertNoPassword fails if the JSON body has a password key at any depth or contains the stored hash
+func assertNoPassword(t *testing.T, body []byte) {
+	t.Helper()
+	assert.NotContains(t, string(body), storedPasswordHash)
+
+	var value interface{}
+	require.NoError(t, json.Unmarshal(body, &value), string(body))
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				assert.NotEqual(t, "password", strings.ToLower(key), "Password field in %s", body)
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(value)
+}
+
+// Test that none of the endpoints reading customers returns the password hash
+func TestCustomerResponsesContainNoPassword(t *testing.T) {
+	handlers := map[string]func(app *Config) http.HandlerFunc{
+		"/customers":           func(app *Config) http.HandlerFunc { return app.GetAllCustomerHandler },
+		"/customers/order":     func(app *Config) http.HandlerFunc { return app.OrderCustomersHandler },
+		"/customers/logged-in": func(app *Config) http.HandlerFunc { return app.GetLoggedInCustomersHandler },
+		"/customers?id=1":      func(app *Config) http.HandlerFunc { return app.GetCustomerHandler },
+	}
+
+	for path, handler := range handlers {
+		t.Run(path, func(t *testing.T) {
+			sqlDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer sqlDB.Close()
+			db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			require.NoError(t, err)
+
+			now := time.Now()
+			mock.ExpectQuery(`SELECT \* FROM "customers"`).WillReturnRows(sqlmock.NewRows(customerColumns).
+				AddRow(1, "acme", "acme@example.com", storedPasswordHash, true, true, "", now, now))
+
+			app := &Config{DB: db}
+			rec := httptest.NewRecorder()
+			handler(app)(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), `"Customername":"acme"`)
+			assertNoPassword(t, rec.Body.Bytes())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// Test that a customer ID that is not a number never reaches the database
+func TestGetCustomerRejectsInvalidID(t *testing.T) {
+	app, mock := newMockApp(t)
+
+	for _, id := range []string{"1%20OR%201=1", "1;%20DROP%20TABLE%20customers", "-1", ""} {
+		rec := httptest.NewRecorder()
+		app.GetCustomerHandler(rec, httptest.NewRequest(http.MethodGet, "/customers?id="+id, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "id %q", id)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for email verification
+const (
+	EventVerificationSent = "verification_sent" // Verification link emailed
+	EventEmailVerified    = "email_verified"    // Mail address confirmed, the account is activated
+)
+
+const (
+	verificationResendInterval = time.Minute // Minimum time between two verification emails to the same customer
+	verificationKeySize        = 32          // Bytes of the key generated when no secret is configured
+
+	VerificationSent            = "If the address belongs to an account waiting for verification, a new verification link has been sent to it"
+	ErrEmailNotVerified         = "Email address is not verified"
+	ErrInvalidVerificationToken = "Invalid verification link"
+	ErrExpiredVerificationToken = "Verification link has expired, request a new one with /verify-email/resend"
+)
+
+var (
+	errVerificationTokenInvalid = errors.New("invalid email verification token")
+	errVerificationTokenExpired = errors.New("expired email verification token")
+)
+
+// verificationClaims are signed into an email verification link. The link only works for the
+// mail address it was sent to, changing the address makes earlier links useless.
+type verificationClaims struct {
+	CustomerID  uint   `json:"sub"`
+	MailAddress string `json:"mail"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+// LoadVerificationKey returns the key verification links are signed with. Without a secret a random
+// key is generated, links sent before a restart then stop working and have to be requested again.
+func LoadVerificationKey(secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	key := make([]byte, verificationKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// signVerificationToken returns the claims followed by their HMAC-SHA256, both base64url encoded
+func signVerificationToken(key []byte, claims verificationClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseVerificationToken checks the signature and the expiry of a verification token
+func parseVerificationToken(key []byte, token string, now time.Time) (verificationClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	var claims verificationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return verificationClaims{}, errVerificationTokenExpired
+	}
+	return claims, nil
+}
+
+// sendVerificationEmail emails a verification link to a pending customer and remembers when it was sent.
+// A failed delivery is only logged, the customer can ask for another link.
+func (app *Config) sendVerificationEmail(customer Customer, now time.Time) error {
+	token, err := signVerificationToken(app.VerificationKey, verificationClaims{
+		CustomerID:  customer.ID,
+		MailAddress: customer.MailAddress,
+		ExpiresAt:   now.Add(EmailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.Model(&Customer{}).Where("id = ?", customer.ID).Update("verification_sent_at", now).Error; err != nil {
+		return err
+	}
+
+	msg := Message{
+		To:      customer.MailAddress,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account was registered with this email address. Open the link below within %s to verify the address and activate the account:\n\n%s\n\n"+
+			"If you did not register, ignore this email. The account stays inactive.\n", customer.Customername, EmailVerificationTTL, link),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to send verification email to %s: %v", customer.Customername, err)
+	}
+	return nil
+}
+
+// VerifyEmailHandler activates an account with the link emailed at registration
+// @Summary Verify email address
+// @Description Confirms the mail address of a pending account and activates it. Opening the link again answers that the address is already verified.
+// @Tags customers
+// @Produce json
+// @Param token query string true "Token of the emailed verification link"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid or expired verification link"
+// @Failure 500 {string} string "Database error"
+// @Router /verify-email [get]
+func (app *Config) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := parseVerificationToken(app.VerificationKey, r.URL.Query().Get("token"), time.Now())
+	if errors.Is(err, errVerificationTokenExpired) {
+		http.Error(w, ErrExpiredVerificationToken, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+		return
+	}
+
+	var customer Customer
+	if err := app.DB.First(&customer, claims.CustomerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if customer.MailAddress != claims.MailAddress {
+		http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+		return
+	}
+
+	// Only a pending account is activated, an old link cannot undo a later deactivation
+	result := app.DB.Model(&Customer{}).Where("id = ? AND verification_pending = ?", customer.ID, true).Updates(map[string]interface{}{
+		"verification_pending": false,
+		"activated":            true,
+	})
+	if result.Error != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Email address verified, the account is activated"
+	if result.RowsAffected == 0 {
+		message = "Email address already verified"
+	} else {
+		app.recordSecurityEvent(EventEmailVerified, customer.Customername, clientIP(r), "")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// ResendVerificationHandler emails a new verification link
+// @Summary Resend the verification link
+// @Description Emails a new verification link to the given address if it belongs to an account waiting for verification.
+// @Description The answer is the same whether or not the address is known, so it does not reveal which addresses have accounts.
+// @Tags customers
+// @Accept json
+// @Produce json
+// @Param request body struct{MailAddress string `json:"mailAddress"`} true "Mail address of the account"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body or empty mail address"
+// @Failure 500 {string} string "Database error"
+// @Router /verify-email/resend [post]
+func (app *Config) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MailAddress string `json:"mailAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestData.MailAddress == "" {
+		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	respond := func() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": VerificationSent})
+	}
+
+	var customer Customer
+	if err := app.DB.Where("mail_address = ?", requestData.MailAddress).First(&customer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respond()
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Do not flood the mailbox when the form is sent repeatedly
+	now := time.Now()
+	if !customer.VerificationPending || (customer.VerificationSentAt != nil && now.Sub(*customer.VerificationSentAt) < verificationResendInterval) {
+		respond()
+		return
+	}
+
+	if err := app.sendVerificationEmail(customer, now); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventVerificationSent, customer.Customername, clientIP(r), "resend")
+	respond()
+}
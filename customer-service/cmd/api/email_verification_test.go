@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that verification tokens keep their claims and refuse other keys, tampering and expiry
+func TestVerificationToken(t *testing.T) {
+	key, err := LoadVerificationKey("")
+	require.NoError(t, err)
+	now := time.Now()
+	claims := verificationClaims{CustomerID: 7, MailAddress: "acme@example.com", ExpiresAt: now.Add(time.Hour).Unix()}
+	token, err := signVerificationToken(key, claims)
+	require.NoError(t, err)
+
+	parsed, err := parseVerificationToken(key, token, now)
+	require.NoError(t, err)
+	assert.Equal(t, claims, parsed)
+
+	otherKey, err := LoadVerificationKey("")
+	require.NoError(t, err)
+	_, err = parseVerificationToken(otherKey, token, now)
+	assert.ErrorIs(t, err, errVerificationTokenInvalid)
+	_, err = parseVerificationToken(key, token+"x", now)
+	assert.ErrorIs(t, err, errVerificationTokenInvalid)
+	_, err = parseVerificationToken(key, "not-a-token", now)
+	assert.ErrorIs(t, err, errVerificationTokenInvalid)
+	_, err = parseVerificationToken(key, token, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, errVerificationTokenExpired)
+}
+
+// Test that the verification endpoint refuses a link signed with another key before touching the database
+func TestVerifyEmailInvalidLink(t *testing.T) {
+	key, err := LoadVerificationKey("")
+	require.NoError(t, err)
+	token, err := signVerificationToken([]byte("another key"), verificationClaims{CustomerID: 1, MailAddress: "acme@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	app := &Config{VerificationKey: key}
+	rec := httptest.NewRecorder()
+	app.VerifyEmailHandler(rec, httptest.NewRequest(http.MethodGet, "/verify-email?token="+token, nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrInvalidVerificationToken)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Load environment variables
@@ -14,8 +17,100 @@ var (
 	DBName      = os.Getenv("CUSTOMER_POSTGRES_DB_NAME")
 	ServicePort = os.Getenv("CUSTOMER_SERVICE_PORT")
 	ServiceName = os.Getenv("CUSTOMER_SERVICE_NAME")
+
+	// Public keys of user-service, used to verify its tokens
+	UserServiceJWKSURL = os.Getenv("USER_SERVICE_JWKS_URL")
+	JWKSCacheTTL       = durationEnv("USER_SERVICE_JWKS_CACHE_TTL", 5*time.Minute)
+
+	// Token check of user-service, tells about logouts and revocations before tokens expire
+	UserServiceTokenCheckURL = os.Getenv("USER_SERVICE_TOKEN_CHECK_URL")
+	TokenCheckCacheTTL       = durationEnv("USER_SERVICE_TOKEN_CHECK_CACHE_TTL", 30*time.Second)
+
+	// Login throttling, optional
+	MaxFailedLogins = intEnv("CUSTOMER_SERVICE_MAX_FAILED_LOGINS", 5)
+	LockoutDuration = durationEnv("CUSTOMER_SERVICE_LOCKOUT_DURATION", 15*time.Minute)
+
+	// Outgoing email, optional. The file mailer writes to CUSTOMER_SERVICE_MAIL_FILE, or stdout when it is unset.
+	MailerKind   = stringEnv("CUSTOMER_SERVICE_MAILER", MailerFile)
+	MailFile     = os.Getenv("CUSTOMER_SERVICE_MAIL_FILE")
+	MailFrom     = stringEnv("CUSTOMER_SERVICE_MAIL_FROM", "no-reply@localhost")
+	SMTPHost     = os.Getenv("CUSTOMER_SERVICE_SMTP_HOST")
+	SMTPPort     = stringEnv("CUSTOMER_SERVICE_SMTP_PORT", "587")
+	SMTPUsername = os.Getenv("CUSTOMER_SERVICE_SMTP_USERNAME")
+	SMTPPassword = os.Getenv("CUSTOMER_SERVICE_SMTP_PASSWORD")
+
+	// Password reset, optional. The emailed link is PasswordResetURL with the token in its "token" query parameter.
+	PasswordResetURL = stringEnv("CUSTOMER_SERVICE_PASSWORD_RESET_URL", "http://localhost:3000/customer/reset-password")
+	PasswordResetTTL = durationEnv("CUSTOMER_SERVICE_PASSWORD_RESET_TTL", time.Hour)
+
+	// Email verification, optional. The emailed link is EmailVerificationURL with the token in its "token" query parameter.
+	// Without a secret links are signed with a random key and stop working when the service restarts.
+	EmailVerificationURL    = stringEnv("CUSTOMER_SERVICE_EMAIL_VERIFICATION_URL", "http://localhost:8081/verify-email")
+	EmailVerificationTTL    = durationEnv("CUSTOMER_SERVICE_EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	EmailVerificationSecret = os.Getenv("CUSTOMER_SERVICE_EMAIL_VERIFICATION_SECRET")
+
+	// Trash, optional. Deleted customers can be restored until they are purged after this time.
+	TrashRetention = durationEnv("CUSTOMER_SERVICE_TRASH_RETENTION", 30*24*time.Hour)
+
+	// Password hashing, optional. Existing hashes of the other algorithm or with other costs are
+	// replaced at the next successful login. Argon2 memory is in KiB.
+	PasswordHasherKind = stringEnv("CUSTOMER_SERVICE_PASSWORD_HASHER", HasherArgon2id)
+	BcryptCost         = intEnv("CUSTOMER_SERVICE_BCRYPT_COST", 10)
+	Argon2Memory       = intEnv("CUSTOMER_SERVICE_ARGON2_MEMORY", 64*1024)
+	Argon2Iterations   = intEnv("CUSTOMER_SERVICE_ARGON2_ITERATIONS", 3)
+	Argon2Parallelism  = intEnv("CUSTOMER_SERVICE_ARGON2_PARALLELISM", 2)
+
+	// Password policy, optional. Classes are letter, upper, lower, digit and symbol. With a history of N
+	// passwords, new passwords must differ from the last N passwords of the customer; 0 turns this off.
+	// The breached password file lists SHA-1 hashes of passwords that are refused, see breached.go.
+	PasswordMinLength       = intEnv("CUSTOMER_SERVICE_PASSWORD_MIN_LENGTH", 8)
+	PasswordRequiredClasses = listEnv("CUSTOMER_SERVICE_PASSWORD_REQUIRED_CLASSES", []string{ClassLetter, ClassDigit})
+	PasswordHistoryLength   = intEnv("CUSTOMER_SERVICE_PASSWORD_HISTORY", 0)
+	BreachedPasswordsFile   = os.Getenv("CUSTOMER_SERVICE_BREACHED_PASSWORDS_FILE")
 )
 
+// stringEnv reads a string from the environment, falling back to def when unset
+func stringEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// listEnv reads a comma separated list from the environment, falling back to def when unset.
+// Unlike the other helpers an empty value is kept, it means an empty list.
+func listEnv(name string, def []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// intEnv reads a positive number from the environment, falling back to def when unset or invalid
+func intEnv(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// durationEnv reads a duration such as "5m" from the environment, falling back to def when unset or invalid
+func durationEnv(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
 // Set DBPort explicitly to 5432 inside the container
 const DBPort = "5432"
 
@@ -29,6 +124,32 @@ func PrintEnvVariables() {
 	fmt.Printf("DBPort: %s\n", DBPort)
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
+	fmt.Printf("UserServiceJWKSURL: %s\n", UserServiceJWKSURL)
+	fmt.Printf("JWKSCacheTTL: %s\n", JWKSCacheTTL)
+	fmt.Printf("UserServiceTokenCheckURL: %s\n", UserServiceTokenCheckURL)
+	fmt.Printf("TokenCheckCacheTTL: %s\n", TokenCheckCacheTTL)
+	fmt.Printf("MaxFailedLogins: %d\n", MaxFailedLogins)
+	fmt.Printf("LockoutDuration: %s\n", LockoutDuration)
+	fmt.Printf("MailerKind: %s\n", MailerKind)
+	fmt.Printf("MailFile: %s\n", MailFile)
+	fmt.Printf("MailFrom: %s\n", MailFrom)
+	fmt.Printf("SMTPHost: %s\n", SMTPHost)
+	fmt.Printf("SMTPPort: %s\n", SMTPPort)
+	fmt.Printf("SMTPUsername: %s\n", SMTPUsername)
+	fmt.Printf("PasswordResetURL: %s\n", PasswordResetURL)
+	fmt.Printf("PasswordResetTTL: %s\n", PasswordResetTTL)
+	fmt.Printf("EmailVerificationURL: %s\n", EmailVerificationURL)
+	fmt.Printf("EmailVerificationTTL: %s\n", EmailVerificationTTL)
+	fmt.Printf("TrashRetention: %s\n", TrashRetention)
+	fmt.Printf("PasswordHasherKind: %s\n", PasswordHasherKind)
+	fmt.Printf("BcryptCost: %d\n", BcryptCost)
+	fmt.Printf("Argon2Memory: %d\n", Argon2Memory)
+	fmt.Printf("Argon2Iterations: %d\n", Argon2Iterations)
+	fmt.Printf("Argon2Parallelism: %d\n", Argon2Parallelism)
+	fmt.Printf("PasswordMinLength: %d\n", PasswordMinLength)
+	fmt.Printf("PasswordRequiredClasses: %s\n", strings.Join(PasswordRequiredClasses, ", "))
+	fmt.Printf("PasswordHistoryLength: %d\n", PasswordHistoryLength)
+	fmt.Printf("BreachedPasswordsFile: %s\n", BreachedPasswordsFile)
 
 	// Ensure all required environment variables are set
 	missingEnvVars := false
@@ -36,7 +157,7 @@ func PrintEnvVariables() {
 		fmt.Println("❌ Error: Missing required database environment variables")
 		missingEnvVars = true
 	}
-	if ServicePort == "" || ServiceName == "" {
+	if ServicePort == "" || ServiceName == "" || UserServiceJWKSURL == "" || UserServiceTokenCheckURL == "" {
 		fmt.Println("❌ Error: Missing required service environment variables")
 		missingEnvVars = true
 	}
@@ -6,8 +6,9 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -30,7 +31,7 @@ const (
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} Customer "List of active customers"
+// @Success 200 {array} customerResponse "List of active customers"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 404 {object} ErrorResponse "No customers found with active login status"
 // @Failure 500 {object} ErrorResponse "Internal server error"
@@ -53,7 +54,7 @@ func (app *Config) GetLoggedInCustomersHandler(w http.ResponseWriter, r *http.Re
 
 	// Return the list of customers
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	json.NewEncoder(w).Encode(newCustomerResponses(customers))
 }
 
 // GetActivatedCustomerNamesHandler returns the names of customers who are activated
@@ -98,7 +99,7 @@ func (app *Config) GetActivatedCustomerNamesHandler(w http.ResponseWriter, r *ht
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} Customer "List of all customers"
+// @Success 200 {array} customerResponse "List of all customers"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /customers [get]
 func (app *Config) GetAllCustomerHandler(w http.ResponseWriter, r *http.Request) {
@@ -113,7 +114,7 @@ func (app *Config) GetAllCustomerHandler(w http.ResponseWriter, r *http.Request)
 
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	json.NewEncoder(w).Encode(newCustomerResponses(customers))
 }
 
 // OrderCustomersHandler retrieves all customers ordered by a specified field
@@ -126,7 +127,7 @@ func (app *Config) GetAllCustomerHandler(w http.ResponseWriter, r *http.Request)
 // @Accept  json
 // @Produce  json
 // @Param order_by query string false "Field to order by (customername, created_at, updated_at)"
-// @Success 200 {array} Customer "List of ordered customers"
+// @Success 200 {array} customerResponse "List of ordered customers"
 // @Failure 400 {object} ErrorResponse "Invalid order_by field"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /customers/order [get]
@@ -156,7 +157,7 @@ func (app *Config) OrderCustomersHandler(w http.ResponseWriter, r *http.Request)
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	json.NewEncoder(w).Encode(newCustomerResponses(customers))
 }
 
 // HealthCheckHandler checks the database connection using GORM
@@ -190,19 +191,14 @@ func (app *Config) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-// HashPassword hashes a password using bcrypt
+// HashPassword hashes a password with the configured hasher
 func (app *Config) HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+	return app.Hasher.Hash(password)
 }
 
-// CheckPassword compares a hashed password with a plain one
+// CheckPassword compares a hashed password with a plain one. Both bcrypt and Argon2id hashes are recognized.
 func (app *Config) CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	return comparePassword(hashedPassword, password)
 }
 
 // CreateCustomerHandler inserts a new customer with a hashed password using GORM
@@ -210,35 +206,52 @@ func (app *Config) CheckPassword(hashedPassword, password string) bool {
 // @Description This endpoint allows you to create a new customer with a hashed password. The customer must provide a unique customer name and mail address.
 //
 //	If the customer already exists (by customer name or mail address), the request will be rejected with a conflict error.
+//	The customer stays inactive and cannot log in until the verification link emailed to the mail address is opened.
 //
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Param customer body Customer true "Customer data"
-// @Success 201 {object} map[string]string {"message": "Customer created successfully", "mailAddress": "string"}
+// @Param customer body createCustomerRequest true "Customer data"
+// @Success 201 {object} map[string]interface{} {"message": "Customer created successfully", "mailAddress": "string", "verification_required": true}
 // @Failure 400 {string} string "Invalid request body"
 // @Failure 400 {string} string "Mail address cannot be empty"
+// @Failure 400 {string} string "JSON with the violated rules when the password is rejected by the password policy"
 // @Failure 409 {string} string "Customer already exists"
 // @Failure 500 {string} string "Error hashing password"
 // @Failure 500 {string} string "Error inserting customer"
+// @Failure 500 {string} string "Failed to send verification email"
 // @Router /customers [post]
 func (app *Config) CreateCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	var customer Customer
-	err := json.NewDecoder(r.Body).Decode(&customer)
+	var request createCustomerRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	// Only the fields of the request are taken over, clients cannot set the account state
+	customer := Customer{
+		Customername: request.Customername,
+		MailAddress:  request.MailAddress,
+		Password:     request.Password,
+		Note:         request.Note,
+	}
+
 	// Ensure MailAddress is provided
 	if customer.MailAddress == "" {
 		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
 		return
 	}
 
-	// Check if customer already exists (by customername OR mail address)
+	// Enforce the password policy
+	if err := app.validateNewPassword(customer.Password, 0, "", customer.Customername, customer.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	// Check if customer already exists (by customername OR mail address), customers in the trash keep theirs until purged
 	var existingCustomer Customer
-	if err := app.DB.Where("customername = ? OR mail_address = ?", customer.Customername, customer.MailAddress).First(&existingCustomer).Error; err == nil {
+	if err := app.DB.Unscoped().Where("customername = ? OR mail_address = ?", customer.Customername, customer.MailAddress).First(&existingCustomer).Error; err == nil {
 		http.Error(w, "Customer already exists", http.StatusConflict)
 		return
 	}
@@ -251,8 +264,9 @@ func (app *Config) CreateCustomerHandler(w http.ResponseWriter, r *http.Request)
 	}
 	customer.Password = hashedPassword
 
-	// Set Activated = true for every new customer
-	customer.Activated = true
+	// New customers stay inactive until the emailed verification link is opened
+	customer.Activated = false
+	customer.VerificationPending = true
 
 	// Insert customer into database using GORM
 	result := app.DB.Create(&customer)
@@ -261,11 +275,18 @@ func (app *Config) CreateCustomerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := app.sendVerificationEmail(customer, time.Now()); err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventVerificationSent, customer.Customername, clientIP(r), "registration")
+
 	// Send response (without JWT token)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":     "Customer created successfully",
-		"mailAddress": customer.MailAddress, // Include mail address in the response
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":               "Customer created successfully",
+		"mailAddress":           customer.MailAddress, // Include mail address in the response
+		"verification_required": true,
 	})
 }
 
@@ -273,20 +294,22 @@ func (app *Config) CreateCustomerHandler(w http.ResponseWriter, r *http.Request)
 // @Summary Log in a customer
 // @Description This endpoint allows customers to log in by verifying their credentials (customername and password). If credentials are valid, the login status will be updated to true.
 //
-//	If the customer does not exist or the password is incorrect, the request will be rejected with an unauthorized error.
+//	If the customer does not exist or the password is incorrect, the request will be rejected with the same unauthorized error.
+//	Repeated failures make the customer and the client IP address wait longer before the next attempt and lock the customer after MaxFailedLogins.
 //
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Param customer body Customer true "Customer login credentials"
+// @Param customer body loginCustomerRequest true "Customer login credentials"
 // @Success 200 {object} map[string]string {"message": "Login successful", "loginStatus": "true"}
 // @Failure 400 {string} string "Invalid request body"
-// @Failure 401 {string} string "Customer not found"
 // @Failure 401 {string} string "Invalid credentials"
+// @Failure 403 {string} string "Email address is not verified"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
 // @Failure 500 {string} string "Database error"
 // @Router /customers/login [post]
 func (app *Config) LoginCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	var customer Customer
+	var customer loginCustomerRequest
 	var storedCustomer Customer
 
 	err := json.NewDecoder(r.Body).Decode(&customer)
@@ -295,22 +318,76 @@ func (app *Config) LoginCustomerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Find customer in DB
+	// IP addresses that keep failing are slowed down, whichever customers they try
+	ip := clientIP(r)
+	now := time.Now()
+	if wait := app.LoginThrottle.RetryAfter(ip, now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, customer.Customername, ip, "IP address backoff")
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	// Find customer in DB. Unknown names get the same answer, in the same time, as wrong passwords.
 	result := app.DB.Where("customername = ?", customer.Customername).First(&storedCustomer)
 	if result.Error != nil {
-		http.Error(w, "Customer not found", http.StatusUnauthorized)
+		if result.Error != gorm.ErrRecordNotFound {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if wait := app.LoginThrottle.UnknownRetryAfter(customer.Customername, now); wait > 0 {
+			app.recordSecurityEvent(EventLoginBlocked, customer.Customername, ip, "account backoff or lockout")
+			writeTooManyAttempts(w, wait)
+			return
+		}
+		app.CheckPassword(app.dummyPasswordHash(), customer.Password)
+		app.LoginThrottle.Fail(ip, now)
+		app.LoginThrottle.FailUnknown(customer.Customername, now)
+		app.recordSecurityEvent(EventLoginFailed, customer.Customername, ip, "unknown customer name")
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	// Customers wait longer after every failure and are locked after MaxFailedLogins
+	if wait := storedCustomer.loginRetryAfter(now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, storedCustomer.Customername, ip, "account backoff or lockout")
+		writeTooManyAttempts(w, wait)
 		return
 	}
 
 	// Compare passwords
 	if !app.CheckPassword(storedCustomer.Password, customer.Password) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		locked, err := app.recordLoginFailure(storedCustomer, now)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		app.LoginThrottle.Fail(ip, now)
+		app.recordSecurityEvent(EventLoginFailed, storedCustomer.Customername, ip, "wrong password")
+		if locked {
+			app.recordSecurityEvent(EventAccountLocked, storedCustomer.Customername, ip, fmt.Sprintf("locked for %s", LockoutDuration))
+		}
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
+	app.upgradePasswordHash(&storedCustomer, customer.Password)
 
-	// Update login_status to true
-	storedCustomer.LoginStatus = true
-	app.DB.Save(&storedCustomer)
+	// New customers cannot log in before their mail address is verified
+	if storedCustomer.VerificationPending {
+		http.Error(w, ErrEmailNotVerified, http.StatusForbidden)
+		return
+	}
+
+	// Update login_status to true and forget earlier failures
+	err = app.DB.Model(&Customer{}).Where("id = ?", storedCustomer.ID).Updates(map[string]interface{}{
+		"login_status":          true,
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	// Send response (without JWT token)
 	w.Header().Set("Content-Type", "application/json")
@@ -320,23 +397,28 @@ func (app *Config) LoginCustomerHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// UpdatePasswordHandler updates a customer's password if they exist
+// UpdatePasswordHandler changes a customer's password
 // @Summary Update customer password
-// @Description This endpoint allows a customer to update their password. The customer must be identified by their customername. The new password is hashed before saving.
+// @Description This endpoint changes a customer's password after checking the current one. Admins can reset it without the current password; such resets are audited.
+// @Description The new password must satisfy the password policy and the customer is logged out.
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Param requestData body struct { Customername string `json:"customername"`; NewPassword string `json:"new_password"` } true "Customer password update data"
-// @Success 200 {string} string "Password updated successfully"
-// @Failure 400 {string} string "Invalid request body"
+// @Param Authorization header string true "Bearer JWT token issued by user-service"
+// @Param requestData body struct { Customername string `json:"customername"`; CurrentPassword string `json:"current_password"`; NewPassword string `json:"new_password"` } true "Customer password update data"
+// @Success 200 {object} map[string]string "Password updated successfully"
+// @Failure 400 {string} string "Invalid request body or password rejected by the password policy"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Current password is incorrect"
 // @Failure 404 {string} string "Customer not found"
 // @Failure 500 {string} string "Database error"
 // @Failure 500 {string} string "Error hashing password"
 // @Router /customers/update-password [post]
 func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var requestData struct {
-		Customername string `json:"customername"`
-		NewPassword  string `json:"new_password"`
+		Customername    string `json:"customername"`
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
 	}
 
 	// Decode request body
@@ -346,26 +428,41 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Log the incoming request for debugging
-	fmt.Println("Received request to update password for customername:", requestData.Customername)
-
 	// Find the customer by customername
 	var customer Customer
 	result := app.DB.Where("customername = ?", requestData.Customername).First(&customer)
-
-	// Log the result of the query to help debug
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, ErrCustomerNotFound, http.StatusNotFound)
-			fmt.Println("Customer not found:", requestData.Customername) // Add log for failed query
 			return
 		}
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Log the found customer for debugging
-	fmt.Println("Found customer:", customer)
+	// Only admins may reset a password without knowing the current one
+	caller, _ := authUserFromContext(r.Context())
+	adminReset := caller.Role == RoleAdmin && requestData.CurrentPassword == ""
+	if !adminReset {
+		if requestData.CurrentPassword == "" {
+			http.Error(w, "Current password is required", http.StatusBadRequest)
+			return
+		}
+		if !app.CheckPassword(customer.Password, requestData.CurrentPassword) {
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+			return
+		}
+		if requestData.CurrentPassword == requestData.NewPassword {
+			http.Error(w, "New password must differ from the current password", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Enforce the password policy, including the password history
+	if err := app.validateNewPassword(requestData.NewPassword, customer.ID, customer.Password, customer.Customername, customer.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
 
 	// Hash new password
 	hashedPassword, err := app.HashPassword(requestData.NewPassword)
@@ -374,15 +471,32 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Update the password
+	// Update the password and end the customer's current login
+	replacedHash := customer.Password
 	customer.Password = hashedPassword
-	app.DB.Save(&customer)
+	customer.LoginStatus = false
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&customer).Error; err != nil {
+			return err
+		}
+		return rememberPassword(tx, customer.ID, replacedHash)
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
-	// Log successful password update
-	fmt.Println("Password updated for customer:", requestData.Customername)
+	if adminReset {
+		log.Printf("AUDIT: password of customer %s reset by admin %s", customer.Customername, caller.Username)
+	} else {
+		log.Printf("Password of customer %s changed by %s", customer.Customername, caller.Username)
+	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Password updated successfully")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":      "Password updated successfully",
+		"customername": customer.Customername,
+	})
 }
 
 // GetCustomerHandler retrieves a customer by ID
@@ -391,18 +505,22 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 // @Tags customers
 // @Accept  json
 // @Produce  json
-// @Param id query string true "Customer ID"
-// @Success 200 {object} Customer "Customer retrieved successfully"
-// @Failure 400 {string} string "Invalid request"
+// @Param id query int true "Customer ID"
+// @Success 200 {object} customerResponse "Customer retrieved successfully"
+// @Failure 400 {string} string "Invalid customer ID"
 // @Failure 404 {string} string "Customer not found"
 // @Failure 500 {string} string "Database error"
 // @Router /customers [get]
 func (app *Config) GetCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	var customer Customer
-	id := r.URL.Query().Get("id")
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
 
 	// Fetch the customer by ID from the database
-	result := app.DB.First(&customer, id)
+	var customer Customer
+	result := app.DB.First(&customer, uint(id))
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, ErrCustomerNotFound, http.StatusNotFound)
@@ -418,28 +536,27 @@ func (app *Config) GetCustomerHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Warning: Customer has no MailAddress.")
 	}
 
-	// Respond with customer data in JSON format
+	// Respond with customer data in JSON format, without the password hash
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(customer)
+	json.NewEncoder(w).Encode(newCustomerResponse(customer))
 }
 
 // UpdateCustomerHandler updates customer details based on customername in the request body
 // @Summary Update customer details
-// @Description This endpoint updates customer information based on the customername provided in the request body.
+// @Description This endpoint updates customer information based on the customername provided in the request body. Passwords are changed with /update-password.
 // @Tags Customer
 // @Accept  json
 // @Produce  json
 // @Param customer body struct {
 //
 //	    Customername string `json:"customername"`
-//	    Password     string `json:"password,omitempty"`
 //	    Note         string `json:"note,omitempty"`
 //	    MailAddress  string `json:"mail_address,omitempty"`
 //	} true "Customer details to update"
 //
 // @Success 200 {string} string "Customer updated successfully"
-// @Failure 400 {string} string "Invalid request body"
+// @Failure 400 {string} string "Invalid request body or password given"
 // @Failure 404 {string} string "Customer not found"
 // @Failure 500 {string} string "Database error or internal error"
 // @Router /customers/update [put]
@@ -459,6 +576,12 @@ func (app *Config) UpdateCustomerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Passwords only change through /update-password, which checks the password policy
+	if request.Password != "" {
+		http.Error(w, "Use /update-password to change the password", http.StatusBadRequest)
+		return
+	}
+
 	// Find the customer by customername
 	var customer Customer
 	result := app.DB.Where("customername = ?", request.Customername).First(&customer)
@@ -471,18 +594,7 @@ func (app *Config) UpdateCustomerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Update the customer's fields if provided in the request body
-	if request.Password != "" {
-		// Hash the new password
-		hashedPassword, err := app.HashPassword(request.Password)
-		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
-			return
-		}
-		customer.Password = hashedPassword
-	}
-
-	// Update the other fields (note, mail address) if provided
+	// Update the fields (note, mail address) if provided
 	if request.Note != "" {
 		customer.Note = request.Note
 	}
@@ -805,9 +917,9 @@ func (app *Config) InsertNoteHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// DeleteCustomerHandler deletes a customer by their customername
+// DeleteCustomerHandler moves a customer to the trash by their customername
 // @Summary Delete a customer by their customername
-// @Description This endpoint allows a user to delete a customer from the database by their customername.
+// @Description This endpoint moves a customer to the trash by their customername. The customer can be restored with POST /customers/{id}/restore until the trash retention has passed, then the customer is purged for good.
 // @Tags customers
 // @Accept  json
 // @Produce  json
@@ -845,13 +957,18 @@ func (app *Config) DeleteCustomerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Delete the customer
+	// Move the customer to the trash, hiding them from every other endpoint
 	result = app.DB.Delete(&customer)
 	if result.Error != nil {
 		http.Error(w, "Failed to delete customer", http.StatusInternalServerError)
 		return
 	}
 
+	// Keep a trace of who removed the customer
+	if user, ok := authUserFromContext(r.Context()); ok {
+		log.Printf("Customer %s deleted by %s", customer.Customername, user.Username)
+	}
+
 	// Respond with success message
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Customer deleted successfully")
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported values of CUSTOMER_SERVICE_PASSWORD_HASHER
+const (
+	HasherBcrypt   = "bcrypt"
+	HasherArgon2id = "argon2id"
+)
+
+// Fixed sizes of Argon2id hashes, the cost parameters are configurable
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	argon2Prefix     = "$argon2id$"
+)
+
+// PasswordHasher hashes new passwords. Stored hashes of every supported format are verified
+// by comparePassword, whichever hasher is configured.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether the hash was made by another algorithm or with other
+	// parameters than the hasher uses now, so it should be replaced at the next login.
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher returns the PasswordHasher configured in the environment
+func NewPasswordHasher(kind string) (PasswordHasher, error) {
+	switch kind {
+	case HasherBcrypt:
+		if BcryptCost < bcrypt.MinCost || BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("CUSTOMER_SERVICE_BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return BcryptHasher{Cost: BcryptCost}, nil
+	case HasherArgon2id:
+		if Argon2Parallelism > 255 {
+			return nil, errors.New("CUSTOMER_SERVICE_ARGON2_PARALLELISM must be at most 255")
+		}
+		return Argon2idHasher{Memory: uint32(Argon2Memory), Iterations: uint32(Argon2Iterations), Parallelism: uint8(Argon2Parallelism)}, nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q, use %s or %s", kind, HasherBcrypt, HasherArgon2id)
+}
+
+// BcryptHasher hashes passwords with bcrypt at the given cost
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of the password
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NeedsRehash reports whether the hash is not a bcrypt hash of the configured cost
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with Argon2id. Hashes are stored in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>, so they can be verified
+// after the parameters have changed.
+type Argon2idHasher struct {
+	Memory      uint32 // In KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// argon2Params are the parameters decoded from a stored Argon2id hash
+type argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	Salt        []byte
+	Key         []byte
+}
+
+// Hash returns the encoded Argon2id hash of the password with a random salt
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash reports whether the hash is not an Argon2id hash made with the configured parameters
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := decodeArgon2Hash(hash)
+	return err != nil || params.Memory != h.Memory || params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism || len(params.Key) != argon2KeyLength
+}
+
+// decodeArgon2Hash parses an encoded Argon2id hash
+func decodeArgon2Hash(hash string) (argon2Params, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, errors.New("invalid argon2 parameters")
+	}
+	var err error
+	if params.Salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, errors.New("invalid argon2 salt")
+	}
+	if params.Key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.Key) == 0 {
+		return params, errors.New("invalid argon2 key")
+	}
+	return params, nil
+}
+
+// comparePassword reports whether the password matches the stored hash. It recognizes Argon2id
+// and bcrypt hashes by their prefix, so hashes made before a change of hasher keep working.
+func comparePassword(hash, password string) bool {
+	if !strings.HasPrefix(hash, argon2Prefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	params, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), params.Salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(params.Key)))
+	return subtle.ConstantTimeCompare(key, params.Key) == 1
+}
+
+// dummyPasswordHash is compared against for unknown customer names, so they take as long as wrong passwords.
+// It is made by the configured hasher, so it costs the same as the hashes of new passwords.
+func (app *Config) dummyPasswordHash() string {
+	app.dummyHashOnce.Do(func() {
+		app.dummyHash, _ = app.HashPassword("dummy password")
+	})
+	return app.dummyHash
+}
+
+// upgradePasswordHash replaces the stored hash of a customer who just logged in with a hash of the
+// configured algorithm and costs, when it was made with others. Failures are only logged, the
+// old hash keeps working and is replaced at a later login.
+func (app *Config) upgradePasswordHash(customer *Customer, password string) {
+	if !app.Hasher.NeedsRehash(customer.Password) {
+		return
+	}
+	hashedPassword, err := app.HashPassword(password)
+	if err != nil {
+		log.Printf("⚠️ Failed to rehash the password of %s: %v", customer.Customername, err)
+		return
+	}
+	// Only the hash that was just verified is replaced, a password changed in the meantime is kept
+	result := app.DB.Model(&Customer{}).Where("id = ? AND password = ?", customer.ID, customer.Password).Update("password", hashedPassword)
+	if result.Error != nil {
+		log.Printf("⚠️ Failed to store the rehashed password of %s: %v", customer.Customername, result.Error)
+		return
+	}
+	if result.RowsAffected == 1 {
+		customer.Password = hashedPassword
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small Argon2id costs keep the tests fast
+var testArgon2 = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+// Test that both hashers verify their own and each other's hashes and detect outdated parameters
+func TestPasswordHashers(t *testing.T) {
+	bcryptHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Password123")
+	require.NoError(t, err)
+	argonHash, err := testArgon2.Hash("Password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$"), argonHash)
+
+	for _, hash := range []string{bcryptHash, argonHash} {
+		assert.True(t, comparePassword(hash, "Password123"))
+		assert.False(t, comparePassword(hash, "Password124"))
+	}
+	assert.False(t, comparePassword("$argon2id$v=19$m=1024,t=1,p=1$broken", "Password123"))
+
+	assert.False(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(bcryptHash))
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(bcryptHash), "Other cost")
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(argonHash), "Other algorithm")
+	assert.False(t, testArgon2.NeedsRehash(argonHash))
+	assert.True(t, Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1}.NeedsRehash(argonHash), "Other memory")
+	assert.True(t, testArgon2.NeedsRehash(bcryptHash), "Other algorithm")
+
+	_, err = NewPasswordHasher("md5")
+	assert.Error(t, err)
+}
+
+// Test that an outdated hash is replaced by one of the configured hasher and a current one is kept
+func TestUpgradePasswordHash(t *testing.T) {
+	app, mock := newMockApp(t)
+	app.Hasher = testArgon2
+	oldHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Password123")
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers" SET "password"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND password = \$4\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, oldHash).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	customer := Customer{Customername: "acme", Password: oldHash}
+	customer.ID = 7
+	app.upgradePasswordHash(&customer, "Password123")
+	assert.True(t, strings.HasPrefix(customer.Password, "$argon2id$"), customer.Password)
+	assert.True(t, comparePassword(customer.Password, "Password123"))
+
+	// A current hash is left alone without touching the database
+	current := customer.Password
+	app.upgradePasswordHash(&customer, "Password123")
+	assert.Equal(t, current, customer.Password)
+
+	// A password changed since the login is not overwritten
+	stale := Customer{Customername: "acme", Password: oldHash}
+	stale.ID = 7
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	app.upgradePasswordHash(&stale, "Password123")
+	assert.Equal(t, oldHash, stale.Password)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
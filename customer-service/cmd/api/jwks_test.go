@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyServer publishes public keys the way user-service does at /.well-known/jwks.json
+// and answers its token check at /token/introspect
+type testKeyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jwk
+	down    bool
+	fetches int
+	revoked map[string]bool // Tokens the token check rejects
+	checks  int
+}
+
+// newTestKeyServer starts a JWKS server and points userServiceKeys and userServiceTokens at it
+// for the duration of the test
+func newTestKeyServer(t *testing.T) *testKeyServer {
+	ks := &testKeyServer{revoked: map[string]bool{}}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		if r.URL.Path == "/token/introspect" {
+			ks.checks++
+			switch {
+			case ks.down:
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			case ks.revoked[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]:
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			default:
+				json.NewEncoder(w).Encode(map[string]bool{"active": true})
+			}
+			return
+		}
+		ks.fetches++
+		if ks.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": ks.keys})
+	}))
+	t.Cleanup(ks.Close)
+
+	previous := userServiceKeys
+	userServiceKeys = NewJWKSCache(ks.URL, time.Minute)
+	userServiceKeys.RefetchInterval = 0
+	t.Cleanup(func() { userServiceKeys = previous })
+
+	previousTokens := userServiceTokens
+	userServiceTokens = NewTokenChecker(ks.URL+"/token/introspect", time.Minute)
+	t.Cleanup(func() { userServiceTokens = previousTokens })
+	return ks
+}
+
+// revoke makes the token check reject the token, as user-service does after a logout
+func (ks *testKeyServer) revoke(token string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.revoked[token] = true
+}
+
+// publishRSA generates an RS256 key, publishes it under kid and returns its private half
+func (ks *testKeyServer) publishRSA(t *testing.T, kid string) *rsa.PrivateKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, jwk{
+		Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+		N: base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+		E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+	})
+	return private
+}
+
+// publishEd25519 generates an EdDSA key, publishes it under kid and returns its private half
+func (ks *testKeyServer) publishEd25519(t *testing.T, kid string) ed25519.PrivateKey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, jwk{
+		Kty: "OKP", Kid: kid, Use: "sig", Alg: "EdDSA", Crv: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(public),
+	})
+	return private
+}
+
+// signTestToken builds a token shaped like the ones user-service issues
+func signTestToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, expiresAt time.Time) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"username":  "alice",
+		"role":      "Sales Representative",
+		"token_use": "access",
+		"exp":       expiresAt.Unix(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	require.NoError(t, err)
+	return tokenString
+}
+
+// Test that keys added by a rotation are fetched at once and that an outage keeps the cached keys
+func TestJWKSCacheRotation(t *testing.T) {
+	ks := newTestKeyServer(t)
+	first := ks.publishEd25519(t, "first")
+	inAnHour := time.Now().Add(time.Hour)
+
+	_, ok := parseUserToken(signTestToken(t, jwt.SigningMethodEdDSA, first, "first", inAnHour))
+	assert.True(t, ok)
+	_, ok = parseUserToken(signTestToken(t, jwt.SigningMethodEdDSA, first, "first", inAnHour))
+	assert.True(t, ok)
+	assert.Equal(t, 1, ks.fetches, "Known keys are served from the cache")
+
+	second := ks.publishRSA(t, "second")
+	_, ok = parseUserToken(signTestToken(t, jwt.SigningMethodRS256, second, "second", inAnHour))
+	assert.True(t, ok, "An unknown kid triggers a refetch")
+	assert.Equal(t, 2, ks.fetches)
+
+	// While user-service is down the cached keys keep working and failed fetches are not retried at once
+	ks.mu.Lock()
+	ks.down = true
+	ks.mu.Unlock()
+	userServiceKeys.TTL = 0
+	_, ok = parseUserToken(signTestToken(t, jwt.SigningMethodEdDSA, first, "first", inAnHour))
+	assert.True(t, ok)
+	_, ok = parseUserToken(signTestToken(t, jwt.SigningMethodEdDSA, first, "first", inAnHour))
+	assert.True(t, ok)
+	assert.Equal(t, 3, ks.fetches)
+}
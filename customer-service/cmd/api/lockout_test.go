@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Test the exponential backoff
+func TestLoginBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), loginBackoff(0, 0))
+	assert.Equal(t, time.Second, loginBackoff(1, 0))
+	assert.Equal(t, 8*time.Second, loginBackoff(4, 0))
+	assert.Equal(t, time.Duration(0), loginBackoff(10, 10), "Free failures are not slowed down")
+	assert.Equal(t, LockoutDuration, loginBackoff(100, 0), "The backoff never exceeds the lockout")
+}
+
+// Test how long a customer has to wait after failures and during a lockout
+func TestLoginRetryAfter(t *testing.T) {
+	now := time.Now()
+	lastFailure := now.Add(-time.Second)
+	lockedUntil := now.Add(time.Minute)
+	expired := now.Add(-time.Minute)
+
+	assert.Equal(t, time.Duration(0), Customer{}.loginRetryAfter(now))
+	assert.Equal(t, 3*time.Second, Customer{FailedLoginAttempts: 3, LastFailedLoginAt: &lastFailure}.loginRetryAfter(now))
+	assert.Equal(t, time.Minute, Customer{FailedLoginAttempts: 5, LastFailedLoginAt: &lastFailure, LockedUntil: &lockedUntil}.loginRetryAfter(now))
+	assert.Equal(t, time.Duration(0), Customer{FailedLoginAttempts: 5, LastFailedLoginAt: &lastFailure, LockedUntil: &expired}.loginRetryAfter(now), "An expired lockout starts over")
+}
+
+// Test that an IP address failing on many customers is slowed down
+func TestLoginThrottle(t *testing.T) {
+	throttle := NewLoginThrottle()
+	now := time.Now()
+
+	for i := 0; i < ipFreeFailures; i++ {
+		throttle.Fail("192.0.2.1", now)
+	}
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.1", now))
+
+	throttle.Fail("192.0.2.1", now)
+	assert.Equal(t, time.Second, throttle.RetryAfter("192.0.2.1", now))
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.2", now), "Other addresses are not affected")
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.1", now.Add(LockoutDuration+time.Second)), "Failures are forgotten")
+	// Unknown customer names back off and lock like customers, so they cannot be told apart
+	throttle.FailUnknown("nobody", now)
+	assert.Equal(t, time.Second, throttle.UnknownRetryAfter("nobody", now))
+	assert.Equal(t, time.Duration(0), throttle.UnknownRetryAfter("acme", now))
+	for i := 1; i < MaxFailedLogins; i++ {
+		throttle.FailUnknown("nobody", now)
+	}
+	assert.Equal(t, LockoutDuration, throttle.UnknownRetryAfter("nobody", now))
+}
+
+// Test that a throttled IP address gets 429 with Retry-After before the database is touched
+func TestLoginHandlerThrottled(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	// Only the blocked attempt is recorded, the customer is never looked up
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "security_events"`).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	app := &Config{DB: db, LoginThrottle: NewLoginThrottle()}
+	for i := 0; i <= ipFreeFailures; i++ {
+		app.LoginThrottle.Fail("192.0.2.1", time.Now())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"customername":"acme","password":"Wrong123"}`))
+	rec := httptest.NewRecorder()
+	app.LoginCustomerHandler(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that a successful login only writes the login columns and keeps the rehashed password
+func TestLoginHandlerUpdatesLoginColumns(t *testing.T) {
+	app, mock := newMockApp(t)
+	app.LoginThrottle = NewLoginThrottle()
+	app.Hasher = testArgon2
+	oldHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Password123")
+	require.NoError(t, err)
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT \* FROM "customers"`).WillReturnRows(sqlmock.NewRows(customerColumns).
+		AddRow(7, "acme", "acme@example.com", oldHash, true, false, "", now, now))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers" SET "password"=`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers" SET "failed_login_attempts"=\$1,"last_failed_login_at"=\$2,"locked_until"=\$3,"login_status"=\$4,"updated_at"=\$5 WHERE id = \$6`).
+		WithArgs(0, nil, nil, true, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"customername":"acme","password":"Password123"}`))
+	rec := httptest.NewRecorder()
+	app.LoginCustomerHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that only Admins can unlock customers and review security events
+func TestLockoutRoutesRequireAdmin(t *testing.T) {
+	ks := newTestKeyServer(t)
+	key := ks.publishEd25519(t, "ed")
+	router := (&Config{}).routes()
+	rep := "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, key, "ed", time.Now().Add(time.Hour))
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPut, "/unlock-customer"},
+		{http.MethodGet, "/security-events"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{"customername":"acme"}`))
+		req.Header.Set("Authorization", rep)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s should be limited to Admins", route.method, route.path)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -11,7 +12,16 @@ import (
 )
 
 type Config struct {
-	DB *gorm.DB
+	DB              *gorm.DB
+	LoginThrottle   *LoginThrottle // Failed logins per IP address
+	Mailer          Mailer         // Sends password reset and verification links
+	VerificationKey []byte         // Signs email verification links
+	Hasher          PasswordHasher // Hashes new passwords, see hasher.go
+
+	BreachedPasswords *BreachedPasswords // Refused as new passwords, nil without CUSTOMER_SERVICE_BREACHED_PASSWORDS_FILE
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 // connectToDB retries connecting to PostgreSQL until it succeeds or fails after retries
@@ -38,7 +48,7 @@ func connectToDB() (*gorm.DB, error) {
 	}
 
 	// AutoMigrate to create tables
-	err = db.AutoMigrate(&Customer{})
+	err = db.AutoMigrate(&Customer{}, &PasswordResetToken{}, &SecurityEvent{}, &PasswordHistory{})
 	if err != nil {
 		log.Fatalf("❌ Failed to migrate database: %v", err)
 	}
@@ -55,10 +65,42 @@ func main() {
 		log.Fatal("❌ Database connection failed:", err)
 	}
 
+	mailer, err := NewMailer(MailerKind)
+	if err != nil {
+		log.Fatal("❌ Failed to set up the mailer:", err)
+	}
+
+	verificationKey, err := LoadVerificationKey(EmailVerificationSecret)
+	if err != nil {
+		log.Fatal("❌ Failed to load the email verification key:", err)
+	}
+	if EmailVerificationSecret == "" {
+		fmt.Println("⚠️ CUSTOMER_SERVICE_EMAIL_VERIFICATION_SECRET is not set, verification links stop working on restart")
+	}
+
+	hasher, err := NewPasswordHasher(PasswordHasherKind)
+	if err != nil {
+		log.Fatal("❌ Failed to set up the password hasher:", err)
+	}
+
+	if err := checkPasswordPolicy(); err != nil {
+		log.Fatal("❌ Invalid password policy:", err)
+	}
+	breached, err := LoadBreachedPasswords(BreachedPasswordsFile)
+	if err != nil {
+		log.Fatal("❌ Failed to load the breached password list:", err)
+	}
+	if breached != nil {
+		fmt.Printf("🔒 Loaded %d breached password hashes\n", breached.Len())
+	}
+
+	app := &Config{DB: db, LoginThrottle: NewLoginThrottle(), Mailer: mailer, VerificationKey: verificationKey, Hasher: hasher, BreachedPasswords: breached}
+	app.StartTrashPurging(TrashRetention)
+
 	fmt.Printf("🚀 %s is running on port: %s\n", ServiceName, ServicePort)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", ServicePort),
-		Handler: (&Config{DB: db}).routes(),
+		Handler: app.routes(),
 	}
 
 	err = srv.ListenAndServe()
@@ -1,16 +1,62 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Customer model for GORM
 type Customer struct {
+	ID           uint   `gorm:"primaryKey"`
+	Customername string `gorm:"unique;not null"`
+	MailAddress  string `gorm:"unique;not null"`
+	Password     string `gorm:"not null"`
+	Activated    bool   `gorm:"default:false"`
+	LoginStatus  bool   `gorm:"default:false"`
+	Note         string `gorm:"type:text"` // Field to store text information
+
+	// Email verification, see email_verification.go
+	VerificationPending bool       `gorm:"not null;default:false"` // Set at registration, cleared by the emailed link which also activates the customer
+	VerificationSentAt  *time.Time // Last verification email, limits resending
+
+	// Login throttling, see lockout.go
+	FailedLoginAttempts int        `gorm:"not null;default:0"` // Consecutive failed logins
+	LastFailedLoginAt   *time.Time // Start of the backoff after the last failure
+	LockedUntil         *time.Time // Set once MaxFailedLogins is reached, cleared by /unlock-customer
+
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"` // Set by /delete-customer, deleted customers are hidden until restored or purged, see trash.go
+}
+
+// PasswordResetToken is a single-use token emailed by /password/forgot. Requesting a new
+// token or resetting the password uses up the customer's other tokens.
+type PasswordResetToken struct {
+	ID         uint       `gorm:"primaryKey"`
+	CustomerID uint       `gorm:"index;not null"`
+	TokenHash  string     `gorm:"type:char(64);uniqueIndex;not null"` // SHA-256 of the token, the token itself is never stored
+	ExpiresAt  time.Time  `gorm:"not null"`
+	UsedAt     *time.Time // Set when the token is used or replaced
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+}
+
+// PasswordHistory keeps a replaced password hash of a customer, so the last PasswordHistoryLength
+// passwords cannot be used again, see passwords.go. Older hashes are deleted.
+type PasswordHistory struct {
+	ID         uint      `gorm:"primaryKey"`
+	CustomerID uint      `gorm:"index;not null"`
+	Password   string    `gorm:"not null"` // Hash of the replaced password
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// SecurityEvent records a failed, blocked or locked out login, an unlock, a password reset or an email verification for later review.
+// Customername is the name that was tried, it does not have to belong to an existing customer.
+type SecurityEvent struct {
 	ID           uint      `gorm:"primaryKey"`
-	Customername string    `gorm:"unique;not null"`
-	MailAddress  string    `gorm:"unique;not null"`
-	Password     string    `gorm:"not null"`
-	Activated    bool      `gorm:"default:false"`
-	LoginStatus  bool      `gorm:"default:false"`
-	Note         string    `gorm:"type:text"` // Field to store text information
-	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+	Type         string    `gorm:"type:varchar(32);index;not null"` // One of the Event* constants
+	Customername string    `gorm:"index"`
+	IPAddress    string    `gorm:"type:varchar(64)"`
+	Detail       string    `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
 }
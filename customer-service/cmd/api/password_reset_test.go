@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Test that unknown addresses get the generic answer and no email
+func TestForgotPasswordUnknownAddress(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT \* FROM "customers" WHERE mail_address = \$1`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	mailer := &FileMailer{Path: filepath.Join(t.TempDir(), "mail.txt")}
+	app := &Config{DB: db, Mailer: mailer}
+	req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"mailAddress":"nobody@example.com"}`))
+	rec := httptest.NewRecorder()
+	app.ForgotPasswordHandler(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), PasswordResetRequested)
+	_, err = os.Stat(mailer.Path)
+	assert.True(t, os.IsNotExist(err), "No email is sent")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that the file mailer appends messages and that header values cannot inject further headers
+func TestFileMailer(t *testing.T) {
+	mailer := &FileMailer{Path: filepath.Join(t.TempDir(), "mail.txt"), From: "no-reply@example.com"}
+	require.NoError(t, mailer.Send(Message{To: "acme@example.com\r\nBcc: attacker@example.com", Subject: "First", Body: "Hello"}))
+	require.NoError(t, mailer.Send(Message{To: "acme@example.com", Subject: "Second", Body: "Hello"}))
+
+	b, err := os.ReadFile(mailer.Path)
+	require.NoError(t, err)
+	mail := string(b)
+	assert.Contains(t, mail, "Subject: First\r\n")
+	assert.Contains(t, mail, "Subject: Second\r\n")
+	assert.NotContains(t, mail, "\r\nBcc:")
+}
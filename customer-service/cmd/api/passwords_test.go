@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test the password policy
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, validatePassword("Password123", "acme"))
+	assert.Error(t, validatePassword("Pass1", "acme"), "Too short")
+	assert.Error(t, validatePassword(strings.Repeat("a1", 40), "acme"), "Too long")
+	assert.Error(t, validatePassword("onlyletters", "acme"), "No digit")
+	assert.Error(t, validatePassword("1234567890", "acme"), "No letter")
+	assert.Error(t, validatePassword("Acme123456", "acme"), "Contains the customer name")
+	assert.Error(t, validatePassword("Billing123", "acme", "billing@acme.example"), "Contains the mail address")
+}
+
+// Test that every broken rule is reported and that the required classes are configurable
+func TestPasswordPolicyViolations(t *testing.T) {
+	classes := PasswordRequiredClasses
+	t.Cleanup(func() { PasswordRequiredClasses = classes })
+	PasswordRequiredClasses = []string{ClassUpper, ClassLower, ClassDigit, ClassSymbol}
+
+	err := validatePassword("acme", "acme")
+	var policyErr *PasswordPolicyError
+	require.ErrorAs(t, err, &policyErr)
+	var rules []string
+	for _, violation := range policyErr.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	assert.Equal(t, []string{RuleMinLength, RuleCharacterClass, RuleCharacterClass, RuleCharacterClass, RuleIdentity}, rules)
+	assert.NoError(t, validatePassword("Pass word1!", "acme"))
+
+	PasswordRequiredClasses = []string{"emoji"}
+	assert.Error(t, checkPasswordPolicy())
+}
+
+// Test that a breached password is rejected at registration with the violated rules as JSON
+func TestRegisterBreachedPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(sha1Hex("Password123")+":42\n"), 0o600))
+	breached, err := LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	app := &Config{BreachedPasswords: breached}
+
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"customername":"acme","mailaddress":"info@acme.example","password":"Password123"}`))
+	rec := httptest.NewRecorder()
+	app.CreateCustomerHandler(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var response struct {
+		Error      string
+		Violations []PasswordViolation
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, ErrPasswordRejected, response.Error)
+	require.Len(t, response.Violations, 1)
+	assert.Equal(t, RuleBreached, response.Violations[0].Rule)
+}
+
+// Test that /update-customer does not change passwords past the password policy
+func TestUpdateCustomerRejectsPassword(t *testing.T) {
+	app, mock := newMockApp(t)
+
+	req := httptest.NewRequest(http.MethodPut, "/update-customer", strings.NewReader(`{"customername":"acme","password":"a"}`))
+	rec := httptest.NewRecorder()
+	app.UpdateCustomerHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Use /update-password")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that the current password and the kept previous ones count as reused
+func TestPasswordReused(t *testing.T) {
+	history := PasswordHistoryLength
+	t.Cleanup(func() { PasswordHistoryLength = history })
+	PasswordHistoryLength = 3
+
+	app, mock := newMockApp(t)
+	current, err := bcrypt.GenerateFromPassword([]byte("Password3"), bcrypt.MinCost)
+	require.NoError(t, err)
+	previous, err := bcrypt.GenerateFromPassword([]byte("Password2"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	reused, err := app.passwordReused(7, string(current), "Password3")
+	require.NoError(t, err)
+	assert.True(t, reused, "The current password needs no query")
+
+	for _, password := range []string{"Password2", "Password1"} {
+		mock.ExpectQuery(`SELECT \* FROM "password_histories" WHERE customer_id = \$1 ORDER BY id DESC LIMIT \$2`).WithArgs(7, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "password"}).AddRow(1, 7, string(previous)))
+		reused, err = app.passwordReused(7, string(current), password)
+		require.NoError(t, err)
+		assert.Equal(t, password == "Password2", reused, password)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -29,29 +29,46 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Recoverer)          // Automatically recover from panics and return a 500 status code
 	mux.Use(middleware.Logger)             // Log all HTTP requests
 
-	// POST routes
-	mux.Post("/register", app.CreateCustomerHandler)        // Route to handle customer registration
-	mux.Post("/login", app.LoginCustomerHandler)            // Route to handle customer login
-	mux.Post("/update-password", app.UpdatePasswordHandler) // Route to update customer password (authentication required)
-
-	// GET routes
-	mux.Get("/health", app.HealthCheckHandler)                            // Custom health check endpoint to check if the service is up
-	mux.Get("/get_all_customer", app.GetAllCustomerHandler)               // Route to get all customers
-	mux.Get("/order-customers", app.OrderCustomersHandler)                // Route to order customers based on some criteria
-	mux.Get("/activated-customers", app.GetActivatedCustomerNamesHandler) // Route to get activated customers
-	mux.Get("/logged-in-customers", app.GetLoggedInCustomersHandler)      // Route to get logged-in customers
-	mux.Get("/customer", app.GetCustomerHandler)                          // Route to get a specific customer by some criteria (e.g., ID)
-
-	// PUT routes (used for updating data)
-	mux.Put("/update-customer", app.UpdateCustomerHandler)         // Route to update customer information
-	mux.Put("/deactivate-customer", app.DeactivateCustomerHandler) // Route to deactivate a customer account
-	mux.Put("/activate-customer", app.ActivateCustomerHandler)     // Route to activate a customer account
-	mux.Put("/update-email", app.UpdateEmailHandler)               // Route to update customer email
-	mux.Put("/update-note", app.UpdateNoteHandler)                 // Route to update an existing customer note
-	mux.Put("/insert-note", app.InsertNoteHandler)                 // Route to insert a new note into an existing customer note
-
-	// DELETE routes
-	mux.Delete("/delete-customer", app.DeleteCustomerHandler) // Route to delete a customer
+	// Custom health check endpoint
+	mux.Get("/health", app.HealthCheckHandler) // Custom health check endpoint to check if the service is up
+
+	// Public Routes (No authentication required)
+	mux.Post("/register", app.CreateCustomerHandler)                // Route to handle customer registration
+	mux.Post("/login", app.LoginCustomerHandler)                    // Route to handle customer login
+	mux.Post("/password/forgot", app.ForgotPasswordHandler)         // Route to email a password reset link
+	mux.Post("/password/reset", app.ResetPasswordHandler)           // Route to set a new password with an emailed reset token
+	mux.Get("/verify-email", app.VerifyEmailHandler)                // Route to activate a new customer with the emailed verification link
+	mux.Post("/verify-email/resend", app.ResendVerificationHandler) // Route to email a new verification link
+
+	// Protected Routes (Require a JWT issued by user-service)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(AuthMiddleware) // Apply JWT authentication middleware to all routes within this group
+
+		// POST routes
+		mux.Post("/update-password", app.UpdatePasswordHandler)         // Route to update customer password
+		mux.Post("/customers/{id}/restore", app.RestoreCustomerHandler) // Route to take a customer back out of the trash
+
+		// GET routes
+		mux.Get("/get_all_customer", app.GetAllCustomerHandler)               // Route to get all customers
+		mux.Get("/order-customers", app.OrderCustomersHandler)                // Route to order customers based on some criteria
+		mux.Get("/activated-customers", app.GetActivatedCustomerNamesHandler) // Route to get activated customers
+		mux.Get("/logged-in-customers", app.GetLoggedInCustomersHandler)      // Route to get logged-in customers
+		mux.Get("/customer", app.GetCustomerHandler)                          // Route to get a specific customer by some criteria (e.g., ID)
+		mux.Get("/security-events", app.SecurityEventsHandler)                // Route to review failed logins and lockouts (Admin only)
+		mux.Get("/customers/trash", app.DeletedCustomersHandler)              // Route to list deleted customers
+
+		// PUT routes (used for updating data)
+		mux.Put("/update-customer", app.UpdateCustomerHandler)         // Route to update customer information
+		mux.Put("/deactivate-customer", app.DeactivateCustomerHandler) // Route to deactivate a customer account
+		mux.Put("/activate-customer", app.ActivateCustomerHandler)     // Route to activate a customer account
+		mux.Put("/unlock-customer", app.UnlockCustomerHandler)         // Route to lift the login lockout of a customer (Admin only)
+		mux.Put("/update-email", app.UpdateEmailHandler)               // Route to update customer email
+		mux.Put("/update-note", app.UpdateNoteHandler)                 // Route to update an existing customer note
+		mux.Put("/insert-note", app.InsertNoteHandler)                 // Route to insert a new note into an existing customer note
+
+		// DELETE routes
+		mux.Delete("/delete-customer", app.DeleteCustomerHandler) // Route to move a customer to the trash
+	})
 
 	// Return the configured router to be used by the server
 	return mux
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that tokens revoked in user-service are rejected once the cached answer expires
+func TestAuthMiddlewareRejectsRevokedTokens(t *testing.T) {
+	ks := newTestKeyServer(t)
+	key := ks.publishEd25519(t, "ed")
+	token := signTestToken(t, jwt.SigningMethodEdDSA, key, "ed", time.Now().Add(time.Hour))
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	status := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/customers", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, status())
+	assert.Equal(t, http.StatusOK, status())
+	assert.Equal(t, 1, ks.checks, "The answer is cached")
+
+	// The logout is noticed when the cached answer expires
+	ks.revoke(token)
+	assert.Equal(t, http.StatusOK, status())
+	userServiceTokens.TTL = 0
+	assert.Equal(t, http.StatusUnauthorized, status())
+
+	// Tokens are not accepted while user-service cannot be asked
+	ks.mu.Lock()
+	ks.down = true
+	ks.mu.Unlock()
+	assert.Equal(t, http.StatusBadGateway, status())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	trashPurgeInterval = time.Hour // How often customers deleted longer than TrashRetention ago are purged
+
+	ErrCustomerNotInTrash = "Customer not found in trash"
+)
+
+// purgeDeletedCustomers hard-deletes the customers deleted before the retention window together with
+// their password reset tokens and password history, and returns how many customers were purged.
+// Security events are kept.
+func purgeDeletedCustomers(db *gorm.DB, now time.Time, retention time.Duration) (int64, error) {
+	var ids []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&Customer{}).Where("deleted_at < ?", now.Add(-retention)).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, model := range []interface{}{&PasswordResetToken{}, &PasswordHistory{}} {
+			if err := tx.Where("customer_id IN ?", ids).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&Customer{}, ids).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// StartTrashPurging purges customers deleted longer than retention ago at startup and then once an hour
+func (app *Config) StartTrashPurging(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purged, err := purgeDeletedCustomers(app.DB, time.Now(), retention)
+			if err != nil {
+				log.Printf("⚠️ Failed to purge deleted customers: %v", err)
+			} else if purged > 0 {
+				fmt.Printf("🧹 Purged %d customers deleted more than %s ago\n", purged, retention)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// DeletedCustomersHandler lists the customers in the trash, most recently deleted first
+// @Summary List deleted customers
+// @Description Returns the customers moved to the trash by /delete-customer, most recently deleted first, with the time they were deleted.
+// @Tags customers
+// @Produce  json
+// @Param Authorization header string true "Bearer JWT token issued by user-service"
+// @Param limit query int false "Maximum number of customers, 100 by default and at most 1000"
+// @Success 200 {array} customerResponse
+// @Failure 400 {string} string "Invalid limit"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 500 {string} string "Database error"
+// @Router /customers/trash [get]
+func (app *Config) DeletedCustomersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	var customers []Customer
+	err := app.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC, id DESC").Limit(limit).Find(&customers).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCustomerResponses(customers))
+}
+
+// RestoreCustomerHandler takes a customer back out of the trash
+// @Summary Restore a deleted customer
+// @Description Takes a customer deleted by /delete-customer back out of the trash with their notes and activation.
+// @Tags customers
+// @Produce  json
+// @Param Authorization header string true "Bearer JWT token issued by user-service"
+// @Param id path int true "Customer ID"
+// @Success 200 {object} customerResponse
+// @Failure 400 {string} string "Invalid customer ID"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 404 {string} string "Customer not found in trash"
+// @Failure 500 {string} string "Database error"
+// @Router /customers/{id}/restore [post]
+func (app *Config) RestoreCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	var customer Customer
+	if err := app.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", customerID).First(&customer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrCustomerNotInTrash, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.DB.Unscoped().Model(&customer).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	customer.DeletedAt = gorm.DeletedAt{}
+
+	// Keep a trace of who restored the customer
+	if user, ok := authUserFromContext(r.Context()); ok {
+		log.Printf("Customer %s restored by %s", customer.Customername, user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCustomerResponse(customer))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockApp returns a Config backed by a sqlmock database
+func newMockApp(t *testing.T) (*Config, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	return &Config{DB: db}, mock
+}
+
+// restoreRequest builds POST /customers/{id}/restore as routed by chi
+func restoreRequest(id string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/customers/"+id+"/restore", nil)
+	routeCtx := chi.NewRouteContext()
+	routeCtx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+}
+
+// Test that deleting only sets deleted_at and that the customer can be restored
+func TestDeleteAndRestoreCustomer(t *testing.T) {
+	app, mock := newMockApp(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT \* FROM "customers" WHERE customername = \$1 AND "customers"."deleted_at" IS NULL`).
+		WillReturnRows(sqlmock.NewRows(customerColumns).AddRow(1, "acme", "acme@example.com", storedPasswordHash, true, false, "", now, now))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers" SET "deleted_at"=\$1 WHERE "customers"."id" = \$2 AND "customers"."deleted_at" IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := httptest.NewRecorder()
+	app.DeleteCustomerHandler(rec, httptest.NewRequest(http.MethodDelete, "/delete-customer", strings.NewReader(`{"customername":"acme"}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	columns := append(append([]string{}, customerColumns...), "deleted_at")
+	mock.ExpectQuery(`SELECT \* FROM "customers" WHERE id = \$1 AND deleted_at IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "acme", "acme@example.com", storedPasswordHash, true, false, "", now, now, now))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "customers" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec = httptest.NewRecorder()
+	app.RestoreCustomerHandler(rec, restoreRequest("1"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"Customername":"acme"`)
+	assert.NotContains(t, rec.Body.String(), "DeletedAt")
+	assertNoPassword(t, rec.Body.Bytes())
+
+	// Customers that are not in the trash cannot be restored
+	mock.ExpectQuery(`SELECT \* FROM "customers" WHERE id = \$1 AND deleted_at IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows(columns))
+	rec = httptest.NewRecorder()
+	app.RestoreCustomerHandler(rec, restoreRequest("1"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.RestoreCustomerHandler(rec, restoreRequest("abc"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that customers deleted before the retention window are purged with their reset tokens and password history
+func TestPurgeDeletedCustomers(t *testing.T) {
+	app, mock := newMockApp(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "customers" WHERE deleted_at < \$1`).
+		WithArgs(now.Add(-30 * 24 * time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(7))
+	mock.ExpectExec(`DELETE FROM "password_reset_tokens" WHERE customer_id IN \(\$1,\$2\)`).WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "password_histories" WHERE customer_id IN \(\$1,\$2\)`).WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "customers" WHERE "customers"."id" IN \(\$1,\$2\)`).WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	purged, err := purgeDeletedCustomers(app.DB, now, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
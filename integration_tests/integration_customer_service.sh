@@ -36,6 +36,7 @@ BASE_URL="http://localhost:$CUSTOMER_SERVICE_PORT"
 HEALTH_CHECK_URL="$BASE_URL/health"
 REGISTER_URL="$BASE_URL/register"
 LOGIN_URL="$BASE_URL/login"
+VERIFY_EMAIL_URL="$BASE_URL/verify-email"
 CUSTOMER_URL="$BASE_URL/customer"
 
 
@@ -53,6 +54,14 @@ GET_ACTIVATED_CUSTOMERS_URL="$BASE_URL/activated-customers"
 GET_LOGGED_IN_CUSTOMERS_URL="$BASE_URL/logged-in-customers"
 DELETE_CUSTOMER_URL="$BASE_URL/delete-customer"
 
+# user-service issues the JWTs the protected routes require
+USER_BASE_URL="http://localhost:$USER_SERVICE_PORT"
+USER_REGISTER_URL="$USER_BASE_URL/register"
+USER_LOGIN_URL="$USER_BASE_URL/login"
+USER_VERIFY_EMAIL_URL="$USER_BASE_URL/verify-email"
+TOKEN_USERNAME="integrationtokenuser"
+TOKEN_PASSWORD="TokenPassword123"
+
 
 health_check() {
   echo "===>TEST END POINT--->HEALTH CHECK"
@@ -356,8 +365,9 @@ update_password() {
   # Construct JSON payload dynamically
   JSON_BODY=$(jq -n \
     --arg customername "$CUSTOMERNAME" \
+    --arg current_password "$PASSWORD" \
     --arg new_password "$NEW_PASSWORD" \
-    '{customername: $customername, new_password: $new_password}')
+    '{customername: $customername, current_password: $current_password, new_password: $new_password}')
 
   # Print the full curl command and JSON_BODY
   echo "REQUEST TYPE: $REQUEST_TYPE"
@@ -408,7 +418,7 @@ update_email() {
   
 
   # Send the request and capture the response
-  UPDATE_EMAIL_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$UPDATE_EMAIL_URL" \
+  UPDATE_EMAIL_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$UPDATE_EMAIL_URL" -H "Authorization: Bearer $JWT_TOKEN" \
     -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
@@ -451,7 +461,7 @@ update_note() {
   
 
   # Send the request and capture the response
-  UPDATE_NOTE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$UPDATE_NOTE_URL" \
+  UPDATE_NOTE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$UPDATE_NOTE_URL" -H "Authorization: Bearer $JWT_TOKEN" \
     -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
@@ -496,7 +506,7 @@ insert_note() {
  
 
   # Send the request and capture the response
-  INSERT_NOTE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$INSERT_NOTE_URL" \
+  INSERT_NOTE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$INSERT_NOTE_URL" -H "Authorization: Bearer $JWT_TOKEN" \
     -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
@@ -532,7 +542,7 @@ get_all_customers() {
   echo "COMMAND: curl -X $REQUEST_TYPE \"$GET_ALL_CUSTOMERS_URL\" -H \"Content-Type: application/json\""
   
   # Send the request and capture the response
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_ALL_CUSTOMERS_URL" -H "Content-Type: application/json")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_ALL_CUSTOMERS_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
@@ -548,6 +558,12 @@ get_all_customers() {
     echo "❌ Failed to fetch customers."
   fi
 
+  # Password hashes must never be returned
+  if [ "$(echo "$HTTP_BODY" | jq '[.[] | has("Password")] | any')" != "false" ]; then
+    echo "❌ Error: The customer list contains password hashes."
+    exit 1
+  fi
+
   echo
 }
 
@@ -565,7 +581,7 @@ order_customers() {
   # Test ordering by created_at (default)
   echo "Testing ordering by created_at (default):"
   echo "COMMAND: curl -X $REQUEST_TYPE \"$ORDER_CUSTOMERS_URL\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL" -H "Authorization: Bearer $JWT_TOKEN")
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
   HTTP_STATUS=$(echo "$RESPONSE" | tail -n1)
   echo "Response Body: $HTTP_BODY"
@@ -580,7 +596,7 @@ order_customers() {
   # Test ordering by customername
   echo "Testing ordering by customername:"
   echo "COMMAND: curl -X $REQUEST_TYPE \"$ORDER_CUSTOMERS_URL?order_by=customername\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=customername")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=customername" -H "Authorization: Bearer $JWT_TOKEN")
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
   HTTP_STATUS=$(echo "$RESPONSE" | tail -n1)
   echo "Response Body: $HTTP_BODY"
@@ -595,7 +611,7 @@ order_customers() {
   # Test ordering by updated_at
   echo "Testing ordering by updated_at:"
   echo "COMMAND: curl -X $REQUEST_TYPE \"$ORDER_CUSTOMERS_URL?order_by=updated_at\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=updated_at")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=updated_at" -H "Authorization: Bearer $JWT_TOKEN")
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
   HTTP_STATUS=$(echo "$RESPONSE" | tail -n1)
   echo "Response Body: $HTTP_BODY"
@@ -610,7 +626,7 @@ order_customers() {
   # Test with an invalid 'order_by' parameter (should default)
   echo "Testing ordering with invalid 'order_by' field (should default):"
   echo "COMMAND: curl -X $REQUEST_TYPE \"$ORDER_CUSTOMERS_URL?order_by=invalid_field\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=invalid_field")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$ORDER_CUSTOMERS_URL?order_by=invalid_field" -H "Authorization: Bearer $JWT_TOKEN")
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
   HTTP_STATUS=$(echo "$RESPONSE" | tail -n1)
   echo "Response Body: $HTTP_BODY"
@@ -638,7 +654,7 @@ get_activated_customers() {
 
   # Send GET request to the endpoint
   echo "COMMAND: curl -X $REQUEST_TYPE \"$GET_ACTIVATED_CUSTOMERS_URL\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_ACTIVATED_CUSTOMERS_URL")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_ACTIVATED_CUSTOMERS_URL" -H "Authorization: Bearer $JWT_TOKEN")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
@@ -672,7 +688,7 @@ get_logged_in_customers() {
 
   # Send GET request to the endpoint
   echo "COMMAND: curl -X $REQUEST_TYPE \"$GET_LOGGED_IN_CUSTOMERS_URL\" -H \"Content-Type: application/json\""
-  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_LOGGED_IN_CUSTOMERS_URL" -H "Content-Type: application/json")
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$GET_LOGGED_IN_CUSTOMERS_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$RESPONSE" | sed '$ d')
@@ -711,7 +727,7 @@ delete_customer() {
   echo "COMMAND: curl -X $REQUEST_TYPE \"$DELETE_CUSTOMER_URL\" -H \"Content-Type: application/json\" -d \"$JSON_BODY\""
   
   # Send the DELETE request and capture the response
-  DELETE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$DELETE_CUSTOMER_URL" -H "Content-Type: application/json" -d "$JSON_BODY")
+  DELETE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$DELETE_CUSTOMER_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$DELETE_RESPONSE" | sed '$ d')
@@ -750,12 +766,89 @@ show_database_table(){
 
 }
 
+# Function to open the verification link emailed to an address, the file mailer writes emails to the service log
+# Arguments: container name of the service, its /verify-email URL and the mail address
+verify_email() {
+  local CONTAINER="$1"
+  local VERIFY_URL="$2"
+  local ADDRESS="$3"
+  echo "===>TEST END POINT--->VERIFY EMAIL OF $ADDRESS"
+  echo
+  echo "REQUEST URL: $VERIFY_URL"
+
+  # Take the token of the newest verification email sent to the address
+  VERIFICATION_TOKEN=$(docker logs "$CONTAINER" 2>&1 | tr -d '\r' | awk -v to="To: $ADDRESS" '
+    /^To: / { current = $0 }
+    /^https?:\/\/[^ ]*verify-email\?token=[^ ]*$/ && current == to { sub(/.*token=/, ""); token = $0 }
+    END { print token }')
+
+  if [ -z "$VERIFICATION_TOKEN" ]; then
+    echo "❌ Error: No verification email found for $ADDRESS."
+    exit 1
+  fi
+
+  VERIFY_RESPONSE=$(curl -s -w "\n%{http_code}" -G "$VERIFY_URL" --data-urlencode "token=$VERIFICATION_TOKEN")
+  HTTP_BODY=$(echo "$VERIFY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$VERIFY_RESPONSE" | tail -n1)
+
+  echo "Verify email response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Email verification failed."
+    exit 1
+  fi
+
+  echo "✅ Email address verified."
+  echo
+}
+
+# Function to get a JWT from user-service, the protected routes of this service only accept its tokens
+get_user_token() {
+  echo "===>TEST END POINT--->GET USER-SERVICE TOKEN"
+  echo
+  echo "REQUEST URL: $USER_LOGIN_URL"
+
+  # Register the test user unless it already exists, new users verify their mail address before logging in
+  REQUEST_BODY='{
+    "username": "'$TOKEN_USERNAME'",
+    "mailAddress": "'$TOKEN_USERNAME'@example.com",
+    "password": "'$TOKEN_PASSWORD'",
+    "role": "Sales Representative"
+  }'
+  HTTP_STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$USER_REGISTER_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+
+  if [ "$HTTP_STATUS" -eq 200 ]; then
+    verify_email "$USER_SERVICE_CONTAINER_NAME" "$USER_VERIFY_EMAIL_URL" "$TOKEN_USERNAME@example.com"
+  fi
+
+  REQUEST_BODY='{
+    "username": "'$TOKEN_USERNAME'",
+    "password": "'$TOKEN_PASSWORD'"
+  }'
+  TOKEN_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$USER_LOGIN_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+
+  HTTP_BODY=$(echo "$TOKEN_RESPONSE" | sed '$ d')
+  JWT_TOKEN=$(echo "$HTTP_BODY" | jq -r '.token')
+
+  if [[ "$JWT_TOKEN" == "null" || -z "$JWT_TOKEN" ]]; then
+    echo "❌ Error: JWT token not received from user-service. Response: $HTTP_BODY"
+    exit 1
+  fi
+
+  echo "✅ JWT token received from user-service."
+  echo
+}
+
 ### **🚀 TEST EXECUTION FLOW 🚀**
 
 
 health_check
 
+get_user_token
+
 register_customer
+verify_email "$CUSTOMER_SERVICE_CONTAINER_NAME" "$VERIFY_EMAIL_URL" "$MAILADDRESS"
 
 login_customer
 show_database_table
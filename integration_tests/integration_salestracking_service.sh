@@ -28,14 +28,26 @@ DELETE_SALE_URL="$BASE_URL/delete-sale"
 UPDATE_INCOMMUNICATION_URL="$BASE_URL/update-incommunication"
 UPDATE_DEAL_URL="$BASE_URL/update-deal"
 UPDATE_CLOSED_URL="$BASE_URL/update-closed"
+SALES_URL="$BASE_URL/sales"
+
+# user-service issues the JWTs the protected routes require
+USER_BASE_URL="http://localhost:$USER_SERVICE_PORT"
+USER_REGISTER_URL="$USER_BASE_URL/register"
+USER_LOGIN_URL="$USER_BASE_URL/login"
+USER_VERIFY_EMAIL_URL="$USER_BASE_URL/verify-email"
+TOKEN_USERNAME="integrationtokenuser"
+TOKEN_PASSWORD="TokenPassword123"
 
 # Set test data
 SALENAME="TestSale123"
 NOTE="This is a test note for the sale record."
+AMOUNT="12500.50"
+CURRENCY="EUR"
 
 UPDATED_NOTE="This is the completely new note."
 IN_COMMUNICATION="true"
 DEAL="true"
+OUTCOME="won"
 
 health_check() {
   echo "===>TEST END POINT--->HEALTH CHECK"
@@ -82,7 +94,11 @@ insert_sale() {
   # Define request payload
   JSON_BODY='{
     "salename": "'"$SALENAME"'",
-    "note": "'"$NOTE"'"
+    "note": "'"$NOTE"'",
+    "amount": "'"$AMOUNT"'",
+    "currency": "'"$CURRENCY"'",
+    "probability": 40,
+    "expected_close_date": "'"$(date +%Y-%m-%d)"'"
   }'
 
   # Print request details
@@ -93,7 +109,7 @@ insert_sale() {
   echo "Curl Command: curl -X $REQUEST_TYPE \"$INSERT_SALE_URL\" -H \"Content-Type: application/json\" -d '$JSON_BODY'"
 
   # Send POST request to insert a new sale record
-  INSERT_SALE_RESPONSE=$(curl -s -w "\n%{http_code}" -X "$REQUEST_TYPE" "$INSERT_SALE_URL" -H "Content-Type: application/json" -d "$JSON_BODY")
+  INSERT_SALE_RESPONSE=$(curl -s -w "\n%{http_code}" -X "$REQUEST_TYPE" "$INSERT_SALE_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$INSERT_SALE_RESPONSE" | sed '$ d')
@@ -104,7 +120,8 @@ insert_sale() {
 
   # Check response status
   if [ "$HTTP_STATUS" -eq 200 ]; then
-    echo "Sale record inserted successfully!"
+    SALE_ID=$(echo "$HTTP_BODY" | jq -r '.id')
+    echo "Sale record inserted successfully! ID: $SALE_ID"
   elif [ "$HTTP_STATUS" -eq 409 ]; then
     echo "⚠️ Sale with this name already exists."
   else
@@ -145,7 +162,7 @@ update_incommunication() {
   echo "Curl Command: curl -X $REQUEST_TYPE \"$UPDATE_INCOMMUNICATION_URL\" -H \"Content-Type: application/json\" -d \"$JSON_BODY\""
 
   # Send PUT request with the proper fields, including the note
-  UPDATE_RESPONSE=$(curl -s -w "\n%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_INCOMMUNICATION_URL" -H "Content-Type: application/json" -d "$JSON_BODY")
+  UPDATE_RESPONSE=$(curl -s -w "\n%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_INCOMMUNICATION_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d "$JSON_BODY")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$UPDATE_RESPONSE" | sed '$ d')
@@ -190,7 +207,7 @@ update_deal() {
   }'"
 
   # Perform the PUT request to update the sale record and capture both status code and response body
-  UPDATE_DEAL_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_DEAL_URL" -H "Content-Type: application/json" -d '{
+  UPDATE_DEAL_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_DEAL_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d '{
     "salename": "'"$SALENAME"'",
     "deal": '"$DEAL"',
     "note": "'"$UPDATED_NOTE"'"
@@ -234,12 +251,14 @@ update_closed() {
   # Echo the curl command for clarity
   echo "curl -s -w \"%{http_code}\" -X $REQUEST_TYPE \"$UPDATE_CLOSED_URL\" -H \"Content-Type: application/json\" -d '{
     \"salename\": \"$SALENAME\",
+    \"outcome\": \"$OUTCOME\",
     \"note\": \"$UPDATED_NOTE\"
   }'"
 
   # Perform the PUT request to update the sale record and capture both status code and response body
-  UPDATE_CLOSED_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_CLOSED_URL" -H "Content-Type: application/json" -d '{
+  UPDATE_CLOSED_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$UPDATE_CLOSED_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d '{
     "salename": "'"$SALENAME"'",
+    "outcome": "'"$OUTCOME"'",
     "note": "'"$UPDATED_NOTE"'"
   }')
 
@@ -264,6 +283,138 @@ update_closed() {
 }
 
 
+# Function to move a sale to another stage through the transition endpoint
+# Usage: transition_sale <stage> <expected_http_status>
+transition_sale() {
+  TARGET_STAGE="$1"
+  EXPECTED_STATUS="$2"
+  TRANSITION_URL="$SALES_URL/$SALE_ID/transition"
+
+  echo "===>TEST END POINT--->SALE STAGE TRANSITION"
+  echo
+  echo "Sale ID: $SALE_ID"
+  echo "Target Stage: $TARGET_STAGE"
+  echo "Expected Status: $EXPECTED_STATUS"
+  echo "URL: $TRANSITION_URL"
+
+  # Define the HTTP request type
+  REQUEST_TYPE="POST"
+
+  # Define the JSON_BODY
+  JSON_BODY=$(jq -n \
+    --arg stage "$TARGET_STAGE" \
+    --arg note "Moved to $TARGET_STAGE by integration test" \
+    '{stage: $stage, note: $note}')
+
+  echo "REQUEST_TYPE: $REQUEST_TYPE"
+  echo "JSON Payload: $JSON_BODY"
+
+  TRANSITION_RESPONSE=$(curl -s -w "\n%{http_code}" -X "$REQUEST_TYPE" "$TRANSITION_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d "$JSON_BODY")
+
+  # Extract response body and HTTP status code
+  HTTP_BODY=$(echo "$TRANSITION_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$TRANSITION_RESPONSE" | tail -n1)
+
+  echo "Transition response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -eq "$EXPECTED_STATUS" ]; then
+    echo "Transition returned the expected status."
+  else
+    echo "❌ Error: Expected status $EXPECTED_STATUS, got $HTTP_STATUS"
+    exit 1
+  fi
+
+  echo "✅ SALE STAGE TRANSITION successfully"
+  echo
+}
+
+
+# Function to read the sale back by ID and through the list endpoint
+get_sale() {
+  SALE_URL="$SALES_URL/$SALE_ID"
+  LIST_URL="$SALES_URL?q=$SALENAME&limit=5"
+
+  echo "===>TEST END POINT--->GET SALE"
+  echo
+  echo "Sale ID: $SALE_ID"
+  echo "URL: $SALE_URL"
+
+  GET_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$SALE_URL" -H "Authorization: Bearer $JWT_TOKEN")
+
+  # Extract response body and HTTP status code
+  HTTP_BODY=$(echo "$GET_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$GET_RESPONSE" | tail -n1)
+
+  echo "Get sale response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Failed to get sale. Status code: $HTTP_STATUS"
+    exit 1
+  fi
+
+  echo "URL: $LIST_URL"
+
+  # Include response headers so the Link header is visible
+  LIST_RESPONSE=$(curl -s -i -w "\n%{http_code}" -X GET "$LIST_URL" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_STATUS=$(echo "$LIST_RESPONSE" | tail -n1)
+
+  echo "List sales response: $(echo "$LIST_RESPONSE" | sed '$ d')"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Failed to list sales. Status code: $HTTP_STATUS"
+    exit 1
+  fi
+
+  FORECAST_URL="$SALES_URL/forecast"
+  echo "URL: $FORECAST_URL"
+
+  FORECAST_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$FORECAST_URL" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_STATUS=$(echo "$FORECAST_RESPONSE" | tail -n1)
+
+  echo "Forecast response: $(echo "$FORECAST_RESPONSE" | sed '$ d')"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Failed to get forecast. Status code: $HTTP_STATUS"
+    exit 1
+  fi
+
+  echo "✅ GET SALE successfully"
+  echo
+}
+
+
+# Function to get the stage history of a sale
+get_sale_history() {
+  HISTORY_URL="$SALES_URL/$SALE_ID/history"
+
+  echo "===>TEST END POINT--->GET SALE HISTORY"
+  echo
+  echo "Sale ID: $SALE_ID"
+  echo "URL: $HISTORY_URL"
+
+  HISTORY_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$HISTORY_URL" -H "Authorization: Bearer $JWT_TOKEN")
+
+  # Extract response body and HTTP status code
+  HTTP_BODY=$(echo "$HISTORY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$HISTORY_RESPONSE" | tail -n1)
+
+  echo "History response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Failed to get sale history. Status code: $HTTP_STATUS"
+    exit 1
+  fi
+
+  echo "✅ GET SALE HISTORY successfully"
+  echo
+}
+
+
 # Function to delete a sale
 delete_sale() {
 
@@ -284,7 +435,7 @@ delete_sale() {
   }'"
 
   # Perform the DELETE request and capture both status code and response body
-  DELETE_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$DELETE_SALE_URL" -H "Content-Type: application/json" -d '{
+  DELETE_RESPONSE=$(curl -s -w "%{http_code}" -X "$REQUEST_TYPE" "$DELETE_SALE_URL" -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" -d '{
     "salename": "'"$SALENAME"'"
   }')
 
@@ -328,14 +479,92 @@ show_database_table(){
 
 }
 
+# Function to open the verification link emailed to an address, the file mailer writes emails to the service log
+# Arguments: container name of the service, its /verify-email URL and the mail address
+verify_email() {
+  local CONTAINER="$1"
+  local VERIFY_URL="$2"
+  local ADDRESS="$3"
+  echo "===>TEST END POINT--->VERIFY EMAIL OF $ADDRESS"
+  echo
+  echo "REQUEST URL: $VERIFY_URL"
+
+  # Take the token of the newest verification email sent to the address
+  VERIFICATION_TOKEN=$(docker logs "$CONTAINER" 2>&1 | tr -d '\r' | awk -v to="To: $ADDRESS" '
+    /^To: / { current = $0 }
+    /^https?:\/\/[^ ]*verify-email\?token=[^ ]*$/ && current == to { sub(/.*token=/, ""); token = $0 }
+    END { print token }')
+
+  if [ -z "$VERIFICATION_TOKEN" ]; then
+    echo "❌ Error: No verification email found for $ADDRESS."
+    exit 1
+  fi
+
+  VERIFY_RESPONSE=$(curl -s -w "\n%{http_code}" -G "$VERIFY_URL" --data-urlencode "token=$VERIFICATION_TOKEN")
+  HTTP_BODY=$(echo "$VERIFY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$VERIFY_RESPONSE" | tail -n1)
+
+  echo "Verify email response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Email verification failed."
+    exit 1
+  fi
+
+  echo "✅ Email address verified."
+  echo
+}
+
+# Function to get a JWT from user-service, the protected routes of this service only accept its tokens
+get_user_token() {
+  echo "===>TEST END POINT--->GET USER-SERVICE TOKEN"
+  echo
+  echo "REQUEST URL: $USER_LOGIN_URL"
+
+  # Register the test user unless it already exists, new users verify their mail address before logging in
+  REQUEST_BODY='{
+    "username": "'$TOKEN_USERNAME'",
+    "mailAddress": "'$TOKEN_USERNAME'@example.com",
+    "password": "'$TOKEN_PASSWORD'",
+    "role": "Sales Representative"
+  }'
+  HTTP_STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$USER_REGISTER_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+
+  if [ "$HTTP_STATUS" -eq 200 ]; then
+    verify_email "$USER_SERVICE_CONTAINER_NAME" "$USER_VERIFY_EMAIL_URL" "$TOKEN_USERNAME@example.com"
+  fi
+
+  REQUEST_BODY='{
+    "username": "'$TOKEN_USERNAME'",
+    "password": "'$TOKEN_PASSWORD'"
+  }'
+  TOKEN_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$USER_LOGIN_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+
+  HTTP_BODY=$(echo "$TOKEN_RESPONSE" | sed '$ d')
+  JWT_TOKEN=$(echo "$HTTP_BODY" | jq -r '.token')
+
+  if [[ "$JWT_TOKEN" == "null" || -z "$JWT_TOKEN" ]]; then
+    echo "❌ Error: JWT token not received from user-service. Response: $HTTP_BODY"
+    exit 1
+  fi
+
+  echo "✅ JWT token received from user-service."
+  echo
+}
+
 ### **🚀 TEST EXECUTION FLOW 🚀**
 
 
 health_check
 
+get_user_token
+
 insert_sale
 show_database_table
 
+get_sale
+
 update_incommunication
 show_database_table
 
@@ -343,12 +572,23 @@ IN_COMMUNICATION=false
 update_incommunication
 show_database_table
 
+# New -> Deal skips a stage and must be rejected
+transition_sale "Deal" 409
+
+transition_sale "InCommunication" 200
+show_database_table
+
 update_deal
 show_database_table
 
 update_closed
 show_database_table
 
+# Closed is terminal
+transition_sale "Deal" 409
+
+get_sale_history
+
 delete_sale
 show_database_table
 
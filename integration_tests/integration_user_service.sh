@@ -23,10 +23,18 @@ MAILADDRESS="testuser@example.com"
 PASSWORD="TestPassword123"
 ROLE="Admin"
 
+# Sales representative the admin deactivates and activates, deactivating the admin itself would end its sessions
+TARGET_USERNAME="testtargetuser"
+TARGET_PASSWORD="TargetPassword123"
+
+# Sales representative the admin invites
+INVITED_USERNAME="testinviteduser"
+INVITED_PASSWORD="InvitedPassword123"
+
 # Define new parameters
 NEW_PASSWORD="NewTestPassword123"
 NEW_EMAIL="newmail@example.com"
-NEW_ROLE="MANAGER"
+NEW_ROLE="Admin"
 
 
 # Define API URLs
@@ -35,7 +43,18 @@ BASE_URL="http://localhost:$USER_SERVICE_PORT"
 HEALTH_CHECK_URL="$BASE_URL/health"
 REGISTER_URL="$BASE_URL/register"
 LOGIN_URL="$BASE_URL/login"
+REFRESH_URL="$BASE_URL/token/refresh"
+LOGIN_2FA_URL="$BASE_URL/login/2fa"
+FORGOT_PASSWORD_URL="$BASE_URL/password/forgot"
+VERIFY_EMAIL_URL="$BASE_URL/verify-email"
+INVITATIONS_URL="$BASE_URL/invitations"
+ACCEPT_INVITATION_URL="$BASE_URL/invitations/accept"
+LOGIN_2FA_ENROLL_URL="$BASE_URL/login/2fa/enroll"
+LOGIN_2FA_CONFIRM_URL="$BASE_URL/login/2fa/confirm"
+JWKS_URL="$BASE_URL/.well-known/jwks.json"
 USER_URL="$BASE_URL/user"
+USERS_URL="$BASE_URL/users"
+MY_LOGINS_URL="$BASE_URL/me/logins"
 
 
 # (Require JWT authentication)
@@ -46,6 +65,8 @@ ACTIVATE_USER_URL="$BASE_URL/activate-user"
 UPDATE_EMAIL_URL="$BASE_URL/update-email"
 UPDATE_ROLE_URL="$BASE_URL/update-role"
 DELETE_USER_URL="$BASE_URL/delete-user"
+USER_TRASH_URL="$BASE_URL/users/trash"
+LOGOUT_URL="$BASE_URL/logout"
 
 
 health_check() {
@@ -81,19 +102,44 @@ health_check() {
   echo
 }
 
+# Function to check that the public signing keys are published
+jwks() {
+  echo "===>TEST END POINT--->JWKS"
+  echo
+  echo "REQUEST URL: $JWKS_URL"
+  echo "COMMAND: curl -X GET \"$JWKS_URL\""
+
+  JWKS_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$JWKS_URL")
+  HTTP_BODY=$(echo "$JWKS_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$JWKS_RESPONSE" | tail -n1)
+
+  echo "JWKS Response Body: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  KEY_COUNT=$(echo "$HTTP_BODY" | jq '.keys | length')
+  if [ "$HTTP_STATUS" -ne 200 ] || [ "$KEY_COUNT" -lt 1 ]; then
+    echo "❌ Error: No signing keys published."
+    exit 1
+  fi
+
+  echo "✅ Signing keys published."
+  echo
+}
+
 
 
 
-# Function to check if the user exists (using the registration endpoint)
+# Function to check that an Admin cannot be registered without an Admin token. The test Admin itself is the
+# bootstrap Admin the service creates at startup from USER_SERVICE_BOOTSTRAP_ADMIN_* in the .env file.
 register_user() {
-  echo "===>TEST END POINT-->REGISTER NEW USER"
+  echo "===>TEST END POINT-->REGISTER ADMIN WITHOUT TOKEN"
   echo
   echo "REQUEST URL: $REGISTER_URL"
   
   # Prepare the request body
   REQUEST_BODY='{
-    "username": "'$USERNAME'",
-    "mailAddress": "'$MAILADDRESS'",
+    "username": "testintruder",
+    "mailAddress": "testintruder@example.com",
     "password": "'$PASSWORD'",
     "role": "'$ROLE'"
   }'
@@ -115,17 +161,251 @@ register_user() {
   echo "Registration response: $HTTP_BODY"
   echo "HTTP Status Code: $HTTP_STATUS"
 
-  if [ "$HTTP_STATUS" -eq 200 ]; then
-    echo "User registered successfully!"
-  elif [ "$HTTP_STATUS" -eq 409 ]; then
-    echo "User already exists...Deleting this user to continue test..."
-    delete_user
-  else
-    echo "Registration failed with status code $HTTP_STATUS. Response: $HTTP_BODY"
+  if [ "$HTTP_STATUS" -ne 403 ]; then
+    echo "❌ Error: Expected 403 for an Admin registered without a token, got $HTTP_STATUS. Response: $HTTP_BODY"
     exit 1
   fi
   
-  echo "✅ Register New User User successfully"
+  echo "✅ Admin registration without a token refused"
+  echo
+}
+
+
+
+# Function to register the sales representative used as target of the admin actions
+register_target_user() {
+  echo "===>TEST END POINT-->REGISTER TARGET USER"
+  echo
+  echo "REQUEST URL: $REGISTER_URL"
+
+  REQUEST_BODY='{
+    "username": "'$TARGET_USERNAME'",
+    "mailAddress": "'$TARGET_USERNAME'@example.com",
+    "password": "'$TARGET_PASSWORD'"
+  }'
+
+  HTTP_STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$REGISTER_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ] && [ "$HTTP_STATUS" -ne 409 ]; then
+    echo "❌ Error: Target user registration failed."
+    exit 1
+  fi
+
+  echo "✅ Target user is registered"
+  echo
+}
+
+
+
+# Function to print the token of the newest link to a path emailed to an address, the file mailer writes emails to the user-service log
+mailed_token() {
+  local ADDRESS="$1"
+  local LINK_PATH="$2"
+  docker logs "$USER_SERVICE_CONTAINER_NAME" 2>&1 | tr -d '\r' | awk -v to="To: $ADDRESS" -v path="$LINK_PATH?token=" '
+    /^To: / { current = $0 }
+    /^https?:\/\/[^ ]*\?token=[^ ]*$/ && index($0, path) && current == to { sub(/.*token=/, ""); token = $0 }
+    END { print token }'
+}
+
+
+
+# Function to open the verification link emailed to an address
+verify_email() {
+  local ADDRESS="$1"
+  echo "===>TEST END POINT-->VERIFY EMAIL OF $ADDRESS"
+  echo
+  echo "REQUEST URL: $VERIFY_EMAIL_URL"
+
+  # Take the token of the newest verification email sent to the address
+  VERIFICATION_TOKEN=$(mailed_token "$ADDRESS" "/verify-email")
+
+  if [ -z "$VERIFICATION_TOKEN" ]; then
+    echo "❌ Error: No verification email found for $ADDRESS."
+    exit 1
+  fi
+
+  VERIFY_RESPONSE=$(curl -s -w "\n%{http_code}" -G "$VERIFY_EMAIL_URL" --data-urlencode "token=$VERIFICATION_TOKEN")
+  HTTP_BODY=$(echo "$VERIFY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$VERIFY_RESPONSE" | tail -n1)
+
+  echo "Verify email response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Email verification failed."
+    exit 1
+  fi
+
+  echo "✅ Email address verified."
+  echo
+}
+
+
+
+# Function to invite a sales representative as the admin and accept the invitation
+invite_user() {
+  echo "===>TEST END POINT-->INVITE USER"
+  echo
+  echo "REQUEST URL: $INVITATIONS_URL"
+
+  INVITE_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$INVITATIONS_URL" \
+    -H "Authorization: Bearer $JWT_TOKEN" -H "Content-Type: application/json" \
+    -d '{"mailAddress": "'$INVITED_USERNAME'@example.com", "role": "Sales Representative", "expires_in": "1h"}')
+  HTTP_BODY=$(echo "$INVITE_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$INVITE_RESPONSE" | tail -n1)
+
+  echo "Invite response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Invitation failed."
+    exit 1
+  fi
+
+  INVITATION_TOKEN=$(mailed_token "$INVITED_USERNAME@example.com" "/accept-invitation")
+  if [ -z "$INVITATION_TOKEN" ]; then
+    echo "❌ Error: No invitation email found for $INVITED_USERNAME@example.com."
+    exit 1
+  fi
+
+  echo "REQUEST URL: $ACCEPT_INVITATION_URL"
+  ACCEPT_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$ACCEPT_INVITATION_URL" -H "Content-Type: application/json" \
+    -d "$(jq -n --arg token "$INVITATION_TOKEN" --arg username "$INVITED_USERNAME" --arg password "$INVITED_PASSWORD" \
+      '{token: $token, username: $username, password: $password}')")
+  HTTP_BODY=$(echo "$ACCEPT_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$ACCEPT_RESPONSE" | tail -n1)
+
+  echo "Accept invitation response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Accepting the invitation failed."
+    exit 1
+  fi
+
+  echo "✅ Invited user created."
+  echo
+}
+
+
+
+# Function to request a password reset link, the email goes to the user-service log
+forgot_password() {
+  echo "===>TEST END POINT-->FORGOT PASSWORD"
+  echo
+  echo "REQUEST URL: $FORGOT_PASSWORD_URL"
+
+  FORGOT_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$FORGOT_PASSWORD_URL" -H "Content-Type: application/json" \
+    -d '{"mailAddress": "'$TARGET_USERNAME'@example.com"}')
+  HTTP_BODY=$(echo "$FORGOT_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$FORGOT_RESPONSE" | tail -n1)
+
+  echo "Forgot password response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Password reset request failed."
+    exit 1
+  fi
+
+  echo "✅ Password reset link requested."
+  echo
+}
+
+
+
+# Function to compute the current TOTP code of a base32 secret (RFC 6238: SHA-1, 6 digits, 30 second steps)
+totp_code() {
+  local secret=$1
+
+  # base32 -d expects padding to a multiple of 8 characters
+  while [ $(( ${#secret} % 8 )) -ne 0 ]; do
+    secret="$secret="
+  done
+
+  local key_hex=$(echo -n "$secret" | base32 -d | xxd -p | tr -d '\n')
+  local counter=$(printf '%016x' $(( $(date +%s) / 30 )))
+  local hmac=$(echo -n "$counter" | xxd -r -p | openssl dgst -sha1 -mac HMAC -macopt hexkey:$key_hex | awk '{print $NF}')
+
+  # Dynamic truncation
+  local offset=$(( 16#${hmac:39:1} ))
+  local value=$(( 16#${hmac:$(( offset * 2 )):8} & 0x7fffffff ))
+  printf '%06d' $(( value % 1000000 ))
+}
+
+
+
+# Function to set up the 2FA Admins must use, during their first login
+enroll_2fa() {
+  echo "===>TEST END POINT-->ENROLL 2FA"
+  echo
+  echo "REQUEST URL: $LOGIN_2FA_ENROLL_URL"
+
+  ENROLL_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$LOGIN_2FA_ENROLL_URL" -H "Content-Type: application/json" \
+    -d '{"mfa_token": "'$MFA_TOKEN'"}')
+  HTTP_BODY=$(echo "$ENROLL_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$ENROLL_RESPONSE" | tail -n1)
+
+  echo "Enroll response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  TOTP_SECRET=$(echo "$HTTP_BODY" | jq -r '.secret')
+  if [ "$HTTP_STATUS" -ne 200 ] || [[ "$TOTP_SECRET" == "null" ]]; then
+    echo "❌ Error: 2FA enrollment failed."
+    exit 1
+  fi
+
+  echo "REQUEST URL: $LOGIN_2FA_CONFIRM_URL"
+  CONFIRM_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$LOGIN_2FA_CONFIRM_URL" -H "Content-Type: application/json" \
+    -d '{"mfa_token": "'$MFA_TOKEN'", "code": "'$(totp_code "$TOTP_SECRET")'"}')
+  HTTP_BODY=$(echo "$CONFIRM_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$CONFIRM_RESPONSE" | tail -n1)
+
+  echo "Confirm response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: 2FA confirmation failed."
+    exit 1
+  fi
+
+  # Keep a recovery code, the TOTP code just used cannot be sent again within its time step
+  RECOVERY_CODE=$(echo "$HTTP_BODY" | jq -r '.recovery_codes[0]')
+
+  echo "✅ 2FA enrolled successfully."
+  echo
+}
+
+
+
+# Function to complete a login with the second factor
+verify_2fa() {
+  echo "===>TEST END POINT-->LOGIN 2FA"
+  echo
+  echo "REQUEST URL: $LOGIN_2FA_URL"
+
+  # A recovery code works once, use it first and TOTP codes afterwards
+  CODE="$RECOVERY_CODE"
+  if [ -z "$CODE" ]; then
+    CODE=$(totp_code "$TOTP_SECRET")
+  fi
+  RECOVERY_CODE=""
+
+  VERIFY_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$LOGIN_2FA_URL" -H "Content-Type: application/json" \
+    -d '{"mfa_token": "'$MFA_TOKEN'", "code": "'$CODE'"}')
+  HTTP_BODY=$(echo "$VERIFY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$VERIFY_RESPONSE" | tail -n1)
+
+  echo "Login 2FA response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Second factor rejected."
+    exit 1
+  fi
+
+  echo "✅ Second factor accepted."
   echo
 }
 
@@ -163,7 +443,16 @@ login_user() {
   echo "Login response: $HTTP_BODY"
   echo "HTTP Status Code: $HTTP_STATUS"
 
+  # Admins must use 2FA: the password only gives an mfa_token, exchanged for the tokens with a code
+  MFA_TOKEN=$(echo "$HTTP_BODY" | jq -r '.mfa_token')
+  if [ "$(echo "$HTTP_BODY" | jq -r '.mfa_enrollment_required')" == "true" ]; then
+    enroll_2fa
+  elif [ "$(echo "$HTTP_BODY" | jq -r '.mfa_required')" == "true" ]; then
+    verify_2fa
+  fi
+
   JWT_TOKEN=$(echo "$HTTP_BODY" | jq -r '.token')
+  REFRESH_TOKEN=$(echo "$HTTP_BODY" | jq -r '.refresh_token')
 
   if [[ "$JWT_TOKEN" == "null" || -z "$JWT_TOKEN" ]]; then
     echo "❌ Error: JWT token not received from login."
@@ -176,6 +465,138 @@ login_user() {
 
 
 
+# Function to exchange the refresh token for a new token pair
+refresh_token() {
+  echo "===>TEST END POINT-->REFRESH TOKEN"
+  echo
+  echo "REQUEST URL: $REFRESH_URL"
+
+  JSON_BODY='{
+    "refresh_token": "'$REFRESH_TOKEN'"
+  }'
+
+  REQUEST_TYPE="POST"
+  echo "REQUEST TYPE: $REQUEST_TYPE"
+  echo "COMMAND: curl -X $REQUEST_TYPE \"$REFRESH_URL\" -H \"Content-Type: application/json\" -d '$JSON_BODY'"
+
+  REFRESH_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$REFRESH_URL" -H "Content-Type: application/json" -d "$JSON_BODY")
+
+  HTTP_BODY=$(echo "$REFRESH_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$REFRESH_RESPONSE" | tail -n1)
+
+  echo "Refresh response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Token refresh failed."
+    exit 1
+  fi
+
+  # The old refresh token is revoked, continue with the new pair
+  JWT_TOKEN=$(echo "$HTTP_BODY" | jq -r '.token')
+  REFRESH_TOKEN=$(echo "$HTTP_BODY" | jq -r '.refresh_token')
+
+  echo "✅ Token refreshed successfully."
+  echo
+}
+
+
+
+# Function to log out and check that the token stops working
+logout_user() {
+  echo "===>TEST END POINT-->LOGOUT USER"
+  echo
+  echo "REQUEST URL: $LOGOUT_URL"
+
+  REQUEST_TYPE="POST"
+  echo "REQUEST TYPE: $REQUEST_TYPE"
+  echo "COMMAND: curl -X $REQUEST_TYPE \"$LOGOUT_URL\" -H \"Authorization: Bearer $JWT_TOKEN\""
+
+  LOGOUT_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$LOGOUT_URL" -H "Authorization: Bearer $JWT_TOKEN")
+
+  HTTP_BODY=$(echo "$LOGOUT_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$LOGOUT_RESPONSE" | tail -n1)
+
+  echo "Logout response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Logout failed."
+    exit 1
+  fi
+
+  # The token of the ended session must be rejected
+  HTTP_STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X $REQUEST_TYPE "$LOGOUT_URL" -H "Authorization: Bearer $JWT_TOKEN")
+  if [ "$HTTP_STATUS" -ne 401 ]; then
+    echo "❌ Error: Token still accepted after logout (HTTP $HTTP_STATUS)."
+    exit 1
+  fi
+
+  echo "✅ Logout successful. Token revoked."
+  echo
+}
+
+
+
+# Function to check that the login shows up in the caller's login history
+login_history() {
+  echo "===>TEST END POINT-->LOGIN HISTORY"
+  echo
+  echo "REQUEST URL: $MY_LOGINS_URL"
+
+  HISTORY_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$MY_LOGINS_URL?success=true" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_BODY=$(echo "$HISTORY_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$HISTORY_RESPONSE" | tail -n1)
+
+  echo "Login history response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Reading the login history failed."
+    exit 1
+  fi
+
+  if [ "$(echo "$HTTP_BODY" | jq 'length')" -lt 1 ]; then
+    echo "❌ Error: The login is missing from the login history."
+    exit 1
+  fi
+
+  echo "✅ Login found in the login history."
+  echo
+}
+
+# Function to search the user list for the invited user
+list_users() {
+  echo "===>TEST END POINT-->LIST USERS"
+  echo
+  echo "REQUEST URL: $USERS_URL?q=$INVITED_USERNAME&activated=true"
+
+  LIST_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$USERS_URL?q=$INVITED_USERNAME&activated=true" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_BODY=$(echo "$LIST_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$LIST_RESPONSE" | tail -n1)
+
+  echo "List users response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Listing users failed."
+    exit 1
+  fi
+
+  if [ "$(echo "$HTTP_BODY" | jq -r --arg name "$INVITED_USERNAME" '[.[] | select(.Username == $name)] | length')" != "1" ]; then
+    echo "❌ Error: The invited user is not listed."
+    exit 1
+  fi
+
+  if [ "$(echo "$HTTP_BODY" | jq '[.[] | has("Password")] | any')" != "false" ]; then
+    echo "❌ Error: The user list contains password hashes."
+    exit 1
+  fi
+
+  echo "✅ Invited user found in the user list."
+  echo
+}
+
 # Function to get user details
 get_user_details() {
   echo "===>TEST END POINT-->GET USER DETAILS"
@@ -215,7 +636,7 @@ deactivate_user() {
   echo "REQUEST URL: $DEACTIVATE_USER_URL"
   
   # Construct JSON payload
-  JSON_PAYLOAD=$(jq -n --arg username "$USERNAME" '{username: $username}')
+  JSON_PAYLOAD=$(jq -n --arg username "$TARGET_USERNAME" '{username: $username}')
 
    # Print the JSON payload
   echo "JSON BODY: $JSON_PAYLOAD"
@@ -258,7 +679,7 @@ activate_user() {
   echo "REQUEST URL: $ACTIVATE_USER_URL"
   
   # Construct JSON payload
-  JSON_PAYLOAD=$(jq -n --arg username "$USERNAME" '{username: $username}')
+  JSON_PAYLOAD=$(jq -n --arg username "$TARGET_USERNAME" '{username: $username}')
 
   # Print the JSON payload
   echo "JSON BODY: $JSON_PAYLOAD"
@@ -356,9 +777,11 @@ update_password() {
   # Construct JSON payload dynamically
   JSON_PAYLOAD=$(jq -n \
     --arg username "$USERNAME" \
+    --arg current_password "$PASSWORD" \
     --arg new_password "$NEW_PASSWORD" \
     '{
       username: $username,
+      current_password: $current_password,
       new_password: $new_password
     }')
 
@@ -391,6 +814,10 @@ update_password() {
     exit 1
   fi
 
+  # Tokens issued before the change are revoked, continue with the new one
+  PASSWORD="$NEW_PASSWORD"
+  JWT_TOKEN=$(echo "$HTTP_BODY" | jq -r '.token')
+
   echo "✅ Password updated successfully."
   echo
 }
@@ -406,9 +833,11 @@ update_email() {
   JSON_PAYLOAD=$(jq -n \
     --arg username "$USERNAME" \
     --arg new_email "$NEW_EMAIL" \
+    --arg current_password "$PASSWORD" \
     '{
       username: $username,
-      new_email: $new_email
+      new_email: $new_email,
+      current_password: $current_password
     }')
 
   # Print the JSON payload
@@ -440,6 +869,10 @@ update_email() {
     exit 1
   fi
 
+  # The address only changes once the link emailed to it is opened, which revokes the access token
+  verify_email "$NEW_EMAIL"
+  refresh_token
+
   echo "✅ Email updated successfully."
   echo
 }
@@ -453,7 +886,7 @@ update_role() {
 
   # Construct JSON payload dynamically
   JSON_PAYLOAD=$(jq -n \
-    --arg username "$USERNAME" \
+    --arg username "$TARGET_USERNAME" \
     --arg role "$NEW_ROLE" \
     '{
       username: $username,
@@ -502,7 +935,7 @@ delete_user() {
   echo "REQUEST URL: $DELETE_USER_URL"
 
   # Construct JSON payload dynamically
-  JSON_PAYLOAD=$(jq -n --arg username "$USERNAME" '{username: $username}')
+  JSON_PAYLOAD=$(jq -n --arg username "${1:-$USERNAME}" '{username: $username}')
 
   # Print the JSON payload
   echo "JSON BODY: $JSON_PAYLOAD"
@@ -539,6 +972,41 @@ delete_user() {
 }
 
 
+# Function to find a deleted user in the trash and restore it
+restore_user() {
+  echo "===>TEST END POINT-->RESTORE USER"
+  echo
+  echo "REQUEST URL: $USER_TRASH_URL"
+
+  TRASH_RESPONSE=$(curl -s -w "\n%{http_code}" -X GET "$USER_TRASH_URL" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_BODY=$(echo "$TRASH_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$TRASH_RESPONSE" | tail -n1)
+
+  echo "Trash response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  DELETED_ID=$(echo "$HTTP_BODY" | jq -r --arg name "$1" '.[] | select(.Username == $name) | .ID')
+  if [ "$HTTP_STATUS" -ne 200 ] || [ -z "$DELETED_ID" ]; then
+    echo "❌ Error: The deleted user is not in the trash."
+    exit 1
+  fi
+
+  RESTORE_RESPONSE=$(curl -s -w "\n%{http_code}" -X POST "$BASE_URL/users/$DELETED_ID/restore" -H "Authorization: Bearer $JWT_TOKEN")
+  HTTP_BODY=$(echo "$RESTORE_RESPONSE" | sed '$ d')
+  HTTP_STATUS=$(echo "$RESTORE_RESPONSE" | tail -n1)
+
+  echo "Restore response: $HTTP_BODY"
+  echo "HTTP Status Code: $HTTP_STATUS"
+
+  if [ "$HTTP_STATUS" -ne 200 ]; then
+    echo "❌ Error: Restoring the user failed."
+    exit 1
+  fi
+
+  echo "✅ User restored successfully."
+  echo
+}
+
 
 show_database_table(){
   
@@ -560,13 +1028,25 @@ show_database_table(){
 
 
 health_check
+jwks
 
 register_user
 show_database_table
 
+register_target_user
+verify_email "$TARGET_USERNAME@example.com"
+forgot_password
+
 login_user
 show_database_table
 
+invite_user
+show_database_table
+list_users
+login_history
+
+refresh_token
+
 deactivate_user
 show_database_table
 
@@ -589,6 +1069,13 @@ show_database_table
 update_user
 show_database_table
 
+logout_user
+login_user
+
+delete_user "$TARGET_USERNAME"
+delete_user "$INVITED_USERNAME"
+restore_user "$INVITED_USERNAME"
+delete_user "$INVITED_USERNAME"
 delete_user
 show_database_table
 
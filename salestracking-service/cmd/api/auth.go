@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// AnonymousActor is recorded when a request carries no authenticated user
+const AnonymousActor = "anonymous"
+
+// RoleAdmin is the user-service role allowed to change every sale
+const RoleAdmin = "Admin"
+
+// contextKey keeps values stored by this package from colliding with other context keys
+type contextKey string
+
+// authUserKey is the context key under which AuthMiddleware stores the caller
+const authUserKey contextKey = "authUser"
+
+// AuthUser is the caller identified by a verified user-service token
+type AuthUser struct {
+	ID       uint // User ID in user-service, 0 for tokens issued before it was added
+	Username string
+	Role     string
+}
+
+// userServiceKeys holds the public keys user-service signs its tokens with
+var userServiceKeys = NewJWKSCache(UserServiceJWKSURL, JWKSCacheTTL)
+
+// userServiceTokens asks user-service whether tokens have been revoked
+var userServiceTokens = NewTokenChecker(UserServiceTokenCheckURL, TokenCheckCacheTTL)
+
+// parseUserToken verifies a token issued by user-service (GenerateJWT) and returns its user
+func parseUserToken(tokenString string) (AuthUser, bool) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, userServiceKeys.Keyfunc)
+	// Partial login tokens of users still owing their second factor are signed by the same keys
+	if err != nil || !token.Valid || claims["token_use"] != "access" {
+		return AuthUser{}, false
+	}
+
+	userID, _ := claims["uid"].(float64)
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	if username == "" {
+		return AuthUser{}, false
+	}
+	return AuthUser{ID: uint(userID), Username: username, Role: role}, true
+}
+
+// AuthMiddleware rejects requests without a valid user-service JWT and stores the caller in the request context.
+// Tokens user-service no longer accepts, after a logout for example, are rejected as well.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "Missing token", http.StatusUnauthorized)
+			return
+		}
+
+		// Extract token from "Bearer <token>"
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if tokenString == authHeader { // No "Bearer " prefix found
+			http.Error(w, "Invalid token format", http.StatusUnauthorized)
+			return
+		}
+
+		user, ok := parseUserToken(tokenString)
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		active, err := userServiceTokens.Active(r.Context(), tokenString)
+		if err != nil {
+			log.Printf("⚠️ Failed to check the token of %s: %v", user.Username, err)
+			http.Error(w, "Failed to verify token", http.StatusBadGateway)
+			return
+		}
+		if !active {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authUserKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ownsSale reports whether the caller may change the sale: Admins change every sale,
+// everybody else only the sales they currently own
+func (u AuthUser) ownsSale(sale Sale) bool {
+	if u.Role == RoleAdmin {
+		return true
+	}
+	return u.ID != 0 && sale.OwnerUserID != nil && *sale.OwnerUserID == u.ID
+}
+
+// authUserFromContext returns the caller stored by AuthMiddleware
+func authUserFromContext(ctx context.Context) (AuthUser, bool) {
+	user, ok := ctx.Value(authUserKey).(AuthUser)
+	return user, ok
+}
+
+// requestActor returns the username of the authenticated caller, used to attribute changes
+func requestActor(r *http.Request) string {
+	user, ok := authUserFromContext(r.Context())
+	if !ok {
+		return AnonymousActor
+	}
+	return user.Username
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that only valid user-service tokens get through and that the caller reaches the handler
+func TestAuthMiddleware(t *testing.T) {
+	ks := newTestKeyServer(t)
+	rsaKey := ks.publishRSA(t, "rsa")
+	edKey := ks.publishEd25519(t, "ed")
+	_, unpublished, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	inAnHour := time.Now().Add(time.Hour)
+
+	// Partial login token handed out by user-service before the second factor is checked
+	partial := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"username": "alice", "role": "Admin", "token_use": "mfa", "exp": inAnHour.Unix()})
+	partial.Header["kid"] = "ed"
+	partialToken, err := partial.SignedString(edKey)
+	assert.NoError(t, err)
+
+	var actor string
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authUserFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, uint(1), user.ID)
+		assert.Equal(t, "Admin", user.Role)
+		actor = requestActor(r)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"no bearer prefix", signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", inAnHour), http.StatusUnauthorized},
+		{"unpublished key", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, unpublished, "ed", inAnHour), http.StatusUnauthorized},
+		{"partial login token", "Bearer " + partialToken, http.StatusUnauthorized},
+		{"shared secret", "Bearer " + signTestToken(t, jwt.SigningMethodHS256, rsaKey.N.Bytes(), "rsa", inAnHour), http.StatusUnauthorized},
+		{"expired", "Bearer " + signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", time.Now().Add(-time.Hour)), http.StatusUnauthorized},
+		{"unsigned", "Bearer " + signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rsa", inAnHour), http.StatusUnauthorized},
+		{"valid RS256", "Bearer " + signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", inAnHour), http.StatusOK},
+		{"valid EdDSA", "Bearer " + signTestToken(t, jwt.SigningMethodEdDSA, edKey, "ed", inAnHour), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/sales", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+	assert.Equal(t, "alice", actor, "The verified username should be the actor")
+}
+
+// Test that every route except the health check requires a token
+func TestRoutesRequireAuth(t *testing.T) {
+	router := (&Config{}).routes()
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/sales"},
+		{http.MethodGet, "/sales/1"},
+		{http.MethodPost, "/insert-sale"},
+		{http.MethodDelete, "/delete-sale"},
+		{http.MethodGet, "/sales/trash"},
+		{http.MethodPost, "/sales/1/restore"},
+		{http.MethodPost, "/sales/1/transition"},
+		{http.MethodGet, "/reports/pipeline"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "%s %s should require a token", route.method, route.path)
+	}
+}
+
+// Test that only Admins can change the loss reason catalog
+func TestLossReasonRoutesRequireAdmin(t *testing.T) {
+	ks := newTestKeyServer(t)
+	key := ks.publishEd25519(t, "ed")
+	router := (&Config{}).routes()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"uid": 5, "username": "bob", "role": "Sales Representative", "token_use": "access", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "ed"
+	rep, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/loss-reasons"},
+		{http.MethodPut, "/loss-reasons/1"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{"code":"price","label":"Too expensive"}`))
+		req.Header.Set("Authorization", "Bearer "+rep)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s should be limited to Admins", route.method, route.path)
+	}
+}
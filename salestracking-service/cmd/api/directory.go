@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors returned when validating the customer and owner of a sale
+var (
+	ErrCustomerNotFound     = errors.New("customer does not exist")
+	ErrOwnerNotFound        = errors.New("owner does not exist or is deactivated")
+	ErrDirectoryUnavailable = errors.New("directory service unavailable")
+	ErrDirectoryForbidden   = errors.New("not allowed to look up this customer or owner")
+	ErrOwnerForbidden       = errors.New("sales representatives can only assign sales to themselves")
+)
+
+// Directory looks up the customers and users a sale refers to.
+// Customers live in customer-service and users in user-service.
+type Directory interface {
+	// CheckCustomer returns ErrCustomerNotFound if the customer does not exist
+	CheckCustomer(ctx context.Context, customerID uint, authHeader string) error
+	// CheckOwner returns ErrOwnerNotFound if the user does not exist or is deactivated, and
+	// ErrOwnerForbidden if the caller may not look the user up
+	CheckOwner(ctx context.Context, userID uint, authHeader string) error
+}
+
+// httpDirectory implements Directory by calling the other services over HTTP
+type httpDirectory struct {
+	client             *http.Client
+	customerServiceURL string
+	userServiceURL     string
+}
+
+// NewHTTPDirectory returns a Directory calling customer-service and user-service at the given base URLs
+func NewHTTPDirectory(customerServiceURL, userServiceURL string) Directory {
+	return &httpDirectory{
+		client:             &http.Client{Timeout: 5 * time.Second},
+		customerServiceURL: customerServiceURL,
+		userServiceURL:     userServiceURL,
+	}
+}
+
+// CheckCustomer asks customer-service for the customer
+func (d *httpDirectory) CheckCustomer(ctx context.Context, customerID uint, authHeader string) error {
+	url := fmt.Sprintf("%s/customer?id=%d", d.customerServiceURL, customerID)
+	found, err := d.get(ctx, url, authHeader, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrCustomerNotFound
+	}
+	return nil
+}
+
+// CheckOwner asks user-service for the user and makes sure the account is activated.
+// The lookup runs with the caller's token and user-service only lets sales representatives
+// read their own user, so they can only assign sales to themselves while Admins can assign
+// them to anybody. This keeps reps from probing which user IDs exist through sales.
+func (d *httpDirectory) CheckOwner(ctx context.Context, userID uint, authHeader string) error {
+	var user struct {
+		Activated bool `json:"activated"`
+	}
+
+	url := fmt.Sprintf("%s/user?id=%d", d.userServiceURL, userID)
+	found, err := d.get(ctx, url, authHeader, &user)
+	if errors.Is(err, ErrDirectoryForbidden) {
+		return ErrOwnerForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if !found || !user.Activated {
+		return ErrOwnerNotFound
+	}
+	return nil
+}
+
+// get performs a GET request forwarding the caller's Authorization header.
+// It reports false on 404, ErrDirectoryForbidden on 403 (for example a sales representative
+// looking up another user) and decodes the body into out when out is not nil.
+func (d *httpDirectory) get(ctx context.Context, url, authHeader string, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusForbidden:
+		return false, ErrDirectoryForbidden
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("%w: %s returned %d", ErrDirectoryUnavailable, url, resp.StatusCode)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+		}
+	}
+	return true, nil
+}
+
+// checkSaleLinks validates the customer and owner a sale is about to reference.
+// Nil IDs are not checked.
+func (app *Config) checkSaleLinks(r *http.Request, customerID, ownerUserID *uint) error {
+	authHeader := r.Header.Get("Authorization")
+	if customerID != nil {
+		if err := app.Directory.CheckCustomer(r.Context(), *customerID, authHeader); err != nil {
+			return err
+		}
+	}
+	if ownerUserID != nil {
+		if err := app.Directory.CheckOwner(r.Context(), *ownerUserID, authHeader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSaleLinkError maps an error from checkSaleLinks to an HTTP response
+func writeSaleLinkError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrDirectoryUnavailable) {
+		http.Error(w, "Failed to validate customer or owner", http.StatusBadGateway)
+		return
+	}
+	if errors.Is(err, ErrDirectoryForbidden) || errors.Is(err, ErrOwnerForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
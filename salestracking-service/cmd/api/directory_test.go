@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that the HTTP directory maps the other services' responses to the sale link errors
+func TestHTTPDirectory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"), "The caller's token should be forwarded")
+
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		case "/customer?id=1":
+			w.Write([]byte(`{"ID":1}`))
+		case "/user?id=2":
+			w.Write([]byte(`{"ID":2,"Activated":true}`))
+		case "/user?id=3":
+			w.Write([]byte(`{"ID":3,"Activated":false}`))
+		case "/user?id=4":
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		case "/user?id=5":
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	directory := NewHTTPDirectory(server.URL, server.URL)
+	ctx := context.Background()
+
+	assert.NoError(t, directory.CheckCustomer(ctx, 1, "Bearer token"))
+	assert.True(t, errors.Is(directory.CheckCustomer(ctx, 9, "Bearer token"), ErrCustomerNotFound))
+
+	assert.NoError(t, directory.CheckOwner(ctx, 2, "Bearer token"))
+	assert.True(t, errors.Is(directory.CheckOwner(ctx, 3, "Bearer token"), ErrOwnerNotFound), "Deactivated users cannot own sales")
+	assert.True(t, errors.Is(directory.CheckOwner(ctx, 9, "Bearer token"), ErrOwnerNotFound))
+	assert.True(t, errors.Is(directory.CheckOwner(ctx, 4, "Bearer token"), ErrDirectoryUnavailable))
+	assert.True(t, errors.Is(directory.CheckOwner(ctx, 5, "Bearer token"), ErrOwnerForbidden), "Reps cannot assign sales to other users")
+}
+
+// Test that a rep assigning a sale to somebody else is told so with 403 rather than 400 or 502
+func TestWriteSaleLinkError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{ErrOwnerForbidden, http.StatusForbidden},
+		{ErrDirectoryForbidden, http.StatusForbidden},
+		{ErrOwnerNotFound, http.StatusBadRequest},
+		{ErrCustomerNotFound, http.StatusBadRequest},
+		{fmt.Errorf("%w: timeout", ErrDirectoryUnavailable), http.StatusBadGateway},
+	} {
+		rec := httptest.NewRecorder()
+		writeSaleLinkError(rec, tc.err)
+		assert.Equal(t, tc.code, rec.Code, tc.err.Error())
+	}
+
+	rec := httptest.NewRecorder()
+	writeSaleLinkError(rec, ErrOwnerForbidden)
+	assert.Contains(t, rec.Body.String(), "only assign sales to themselves")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 // Define service name
@@ -17,8 +18,32 @@ var (
 	DBName      = os.Getenv(ServiceNamePrefix + "_POSTGRES_DB_NAME")
 	ServicePort = os.Getenv(ServiceNamePrefix + "_SERVICE_PORT")
 	ServiceName = os.Getenv(ServiceNamePrefix + "_SERVICE_NAME")
+
+	// Base URLs of the services owning customers and users
+	CustomerServiceURL = os.Getenv(ServiceNamePrefix + "_CUSTOMER_SERVICE_URL")
+	UserServiceURL     = os.Getenv(ServiceNamePrefix + "_USER_SERVICE_URL")
+
+	// Public keys of user-service, used to verify its JWTs
+	UserServiceJWKSURL = os.Getenv("USER_SERVICE_JWKS_URL")
+	JWKSCacheTTL       = durationEnv("USER_SERVICE_JWKS_CACHE_TTL", 5*time.Minute)
+
+	// Token check of user-service, tells about logouts and revocations before tokens expire
+	UserServiceTokenCheckURL = os.Getenv("USER_SERVICE_TOKEN_CHECK_URL")
+	TokenCheckCacheTTL       = durationEnv("USER_SERVICE_TOKEN_CHECK_CACHE_TTL", 30*time.Second)
+
+	// Trash, optional. Deleted sales can be restored until they are purged after this time.
+	TrashRetention = durationEnv(ServiceNamePrefix+"_TRASH_RETENTION", 30*24*time.Hour)
 )
 
+// durationEnv reads a duration such as "5m" from the environment, falling back to def when unset or invalid
+func durationEnv(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
 // Set DBPort explicitly to 5432 inside the container
 const DBPort = "5432"
 
@@ -32,6 +57,13 @@ func PrintEnvVariables() {
 	fmt.Printf("DBPort: %s\n", DBPort)
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
+	fmt.Printf("CustomerServiceURL: %s\n", CustomerServiceURL)
+	fmt.Printf("UserServiceURL: %s\n", UserServiceURL)
+	fmt.Printf("UserServiceJWKSURL: %s\n", UserServiceJWKSURL)
+	fmt.Printf("JWKSCacheTTL: %s\n", JWKSCacheTTL)
+	fmt.Printf("UserServiceTokenCheckURL: %s\n", UserServiceTokenCheckURL)
+	fmt.Printf("TokenCheckCacheTTL: %s\n", TokenCheckCacheTTL)
+	fmt.Printf("TrashRetention: %s\n", TrashRetention)
 
 	// Ensure all required environment variables are set
 	missingEnvVars := false
@@ -39,7 +71,7 @@ func PrintEnvVariables() {
 		fmt.Println("❌ Error: Missing required database environment variables")
 		missingEnvVars = true
 	}
-	if ServicePort == "" || ServiceName == "" {
+	if ServicePort == "" || ServiceName == "" || CustomerServiceURL == "" || UserServiceURL == "" || UserServiceJWKSURL == "" || UserServiceTokenCheckURL == "" {
 		fmt.Println("❌ Error: Missing required service environment variables")
 		missingEnvVars = true
 	}
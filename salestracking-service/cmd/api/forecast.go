@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// forecastPeriod is the date range a forecast covers, end exclusive
+type forecastPeriod struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// parsePeriod parses a year ("2025"), a quarter ("2025-Q2") or a month ("2025-04")
+func parsePeriod(param string) (forecastPeriod, error) {
+	invalid := errors.New("period must look like 2025, 2025-Q2 or 2025-04")
+
+	switch len(param) {
+	case 4:
+		year, err := strconv.Atoi(param)
+		if err != nil {
+			return forecastPeriod{}, invalid
+		}
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return forecastPeriod{Name: param, Start: start, End: start.AddDate(1, 0, 0)}, nil
+	case 7:
+		if param[5] == 'Q' {
+			year, err := strconv.Atoi(param[:4])
+			quarter, qerr := strconv.Atoi(param[6:])
+			if err != nil || qerr != nil || param[4] != '-' || quarter < 1 || quarter > 4 {
+				return forecastPeriod{}, invalid
+			}
+			start := time.Date(year, time.Month(3*(quarter-1)+1), 1, 0, 0, 0, 0, time.UTC)
+			return forecastPeriod{Name: param, Start: start, End: start.AddDate(0, 3, 0)}, nil
+		}
+		start, err := time.Parse("2006-01", param)
+		if err != nil {
+			return forecastPeriod{}, invalid
+		}
+		return forecastPeriod{Name: param, Start: start, End: start.AddDate(0, 1, 0)}, nil
+	}
+	return forecastPeriod{}, invalid
+}
+
+// currentQuarter returns the calendar quarter containing now
+func currentQuarter(now time.Time) forecastPeriod {
+	quarter := (int(now.Month())-1)/3 + 1
+	period, _ := parsePeriod(fmt.Sprintf("%04d-Q%d", now.Year(), quarter))
+	return period
+}
+
+// forecastRow is the pipeline value of one stage in one currency
+type forecastRow struct {
+	Stage          string `json:"stage"`
+	Currency       string `json:"currency"`
+	Count          int    `json:"count"`
+	TotalAmount    Amount `json:"total_amount"`
+	WeightedAmount Amount `json:"weighted_amount"` // Sum of amount x probability
+}
+
+// stageOrder gives the position of each stage in the pipeline, used to sort reports
+var stageOrder = map[string]int{
+	StageNew:             0,
+	StageInCommunication: 1,
+	StageDeal:            2,
+	StageClosed:          3,
+}
+
+// buildForecast sums the amount and probability-weighted amount of the sales per stage and currency.
+// Amounts in different currencies are never added together.
+func buildForecast(sales []Sale) []forecastRow {
+	type key struct{ stage, currency string }
+	rows := map[key]*forecastRow{}
+
+	for _, sale := range sales {
+		k := key{sale.Stage, sale.Currency}
+		row, ok := rows[k]
+		if !ok {
+			row = &forecastRow{Stage: sale.Stage, Currency: sale.Currency}
+			rows[k] = row
+		}
+		row.Count++
+		row.TotalAmount += sale.Amount
+		row.WeightedAmount += sale.Amount.Weighted(sale.Probability)
+	}
+
+	result := make([]forecastRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Stage != result[j].Stage {
+			return stageOrder[result[i].Stage] < stageOrder[result[j].Stage]
+		}
+		return result[i].Currency < result[j].Currency
+	})
+	return result
+}
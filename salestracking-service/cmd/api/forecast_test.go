@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the supported period formats
+func TestParsePeriod(t *testing.T) {
+	period, err := parsePeriod("2025-Q2")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), period.Start)
+	assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), period.End)
+
+	period, err = parsePeriod("2025-02")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), period.End)
+
+	period, err = parsePeriod("2025")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), period.End)
+
+	for _, input := range []string{"", "2025-Q5", "2025-13", "25", "2025/Q1"} {
+		_, err := parsePeriod(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// Test that the forecast groups by stage and currency and weights by probability
+func TestBuildForecast(t *testing.T) {
+	sales := []Sale{
+		{Stage: StageDeal, Currency: "EUR", Amount: 100000, Probability: 80},
+		{Stage: StageNew, Currency: "EUR", Amount: 50000, Probability: 10},
+		{Stage: StageDeal, Currency: "EUR", Amount: 20000, Probability: 50},
+		{Stage: StageDeal, Currency: "USD", Amount: 30000, Probability: 50},
+	}
+
+	rows := buildForecast(sales)
+
+	assert.Equal(t, []forecastRow{
+		{Stage: StageNew, Currency: "EUR", Count: 1, TotalAmount: 50000, WeightedAmount: 5000},
+		{Stage: StageDeal, Currency: "EUR", Count: 2, TotalAmount: 120000, WeightedAmount: 90000},
+		{Stage: StageDeal, Currency: "USD", Count: 1, TotalAmount: 30000, WeightedAmount: 15000},
+	}, rows)
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"gorm.io/gorm"
 )
 
@@ -20,6 +25,7 @@ const (
 	UserUpdatedSuccess    = "User updated successfully"
 	UserDeletedSuccess    = "User deleted successfully"
 	LoginSuccess          = "Login successful"
+	ErrSaleNotOwned       = "Sales representatives can only change their own sales"
 )
 
 // HealthCheckHandler checks the database connection using GORM
@@ -57,16 +63,27 @@ func (app *Config) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 // @Produce  json
 // @Param salename body string true "Sale Name"
 // @Param note body string false "Sale Note"
+// @Param customer_id body int false "Customer ID in customer-service"
+// @Param owner_user_id body int false "Owning sales representative's user ID in user-service, sales representatives can only give their own"
+// @Param amount body string false "Deal value as a decimal string, e.g. \"1250.50\""
+// @Param currency body string false "ISO 4217 currency code, required with amount"
+// @Param probability body int false "Win probability in percent (0-100)"
+// @Param expected_close_date body string false "Expected close date (YYYY-MM-DD)"
 // @Success 200 {object} map[string]string {"message": "Sale record created successfully"}
-// @Failure 400 {string} string "Invalid request body"
+// @Failure 400 {string} string "Invalid request body or unknown customer/owner"
+// @Failure 403 {string} string "Sales representatives can only assign sales to themselves"
 // @Failure 409 {string} string "Sale with this name already exists"
 // @Failure 500 {string} string "Failed to create sale record"
+// @Failure 502 {string} string "Failed to validate customer or owner"
 // @Router /sale [post]
 func (app *Config) InsertSaleHandler(w http.ResponseWriter, r *http.Request) {
 
 	var request struct {
-		Salename string `json:"salename"`
-		Note     string `json:"note"`
+		Salename    string `json:"salename"`
+		Note        string `json:"note"`
+		CustomerID  *uint  `json:"customer_id"`
+		OwnerUserID *uint  `json:"owner_user_id"`
+		saleValues
 	}
 
 	// Decode request body
@@ -76,9 +93,9 @@ func (app *Config) InsertSaleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if Salename already exists
+	// Check if Salename already exists, sales in the trash keep theirs until purged
 	var existingSale Sale
-	if err := app.DB.Where("salename = ?", request.Salename).First(&existingSale).Error; err == nil {
+	if err := app.DB.Unscoped().Where("salename = ?", request.Salename).First(&existingSale).Error; err == nil {
 		http.Error(w, "Sale with this name already exists", http.StatusConflict)
 		return
 	} else if err != gorm.ErrRecordNotFound {
@@ -86,17 +103,31 @@ func (app *Config) InsertSaleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make sure the linked customer and owner exist
+	if err := app.checkSaleLinks(r, request.CustomerID, request.OwnerUserID); err != nil {
+		writeSaleLinkError(w, err)
+		return
+	}
+
 	// Create a new sale record
 	newSale := Sale{
-		Salename:  request.Salename,
-		New:       true,
-		Note:      request.Note,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Salename:    request.Salename,
+		Stage:       StageNew,
+		CustomerID:  request.CustomerID,
+		OwnerUserID: request.OwnerUserID,
+		Note:        request.Note,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	// Validate and set the deal value fields
+	if err := request.saleValues.apply(&newSale); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Save to DB
-	if err := app.DB.Create(&newSale).Error; err != nil {
+	// Save to DB together with the first history entry
+	if err := app.saveSaleTransition(&newSale, "", requestActor(r), request.Note); err != nil {
 		http.Error(w, "Failed to create sale record", http.StatusInternalServerError)
 		return
 	}
@@ -105,12 +136,13 @@ func (app *Config) InsertSaleHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Sale record created successfully",
+		"id":      strconv.FormatUint(uint64(newSale.ID), 10),
 	})
 }
 
-// DeleteSaleHandler deletes a sale record from the database
+// DeleteSaleHandler moves a sale record to the trash
 // @Summary Delete a sale record
-// @Description Deletes a sale record identified by salename
+// @Description Moves the sale record identified by salename to the trash together with its stage history. The sale can be restored with POST /sales/{id}/restore until the trash retention has passed, then it is purged for good.
 // @Tags Sales
 // @Accept  json
 // @Produce  json
@@ -143,11 +175,12 @@ func (app *Config) DeleteSaleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete the sale record
+	// Move the sale record to the trash, its stage history is kept for a restore
 	if err := app.DB.Delete(&sale).Error; err != nil {
 		http.Error(w, "Failed to delete sale", http.StatusInternalServerError)
 		return
 	}
+	log.Printf("Sale %s deleted by %s", sale.Salename, requestActor(r))
 
 	// Success response
 	w.Header().Set("Content-Type", "application/json")
@@ -157,9 +190,9 @@ func (app *Config) DeleteSaleHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// UpdateInCommunicationHandler updates the InCommunication field in the sale record
-// @Summary Update the InCommunication field of a sale record
-// @Description Updates the InCommunication status and appends a note to the sale record
+// UpdateInCommunicationHandler moves a sale into or out of the InCommunication stage
+// @Summary Move a sale into or out of the InCommunication stage
+// @Description Moves the sale to InCommunication (or back to New when in_communication is false) and appends a note to the sale record
 // @Tags Sales
 // @Accept  json
 // @Produce  json
@@ -169,6 +202,7 @@ func (app *Config) DeleteSaleHandler(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} map[string]string {"message": "Sale record updated successfully"}
 // @Failure 400 {string} string "Invalid request body"
 // @Failure 404 {string} string "Sale not found"
+// @Failure 409 {string} string "Illegal stage transition"
 // @Failure 500 {string} string "Failed to update sale record"
 // @Router /sale/communication [put]
 func (app *Config) UpdateInCommunicationHandler(w http.ResponseWriter, r *http.Request) {
@@ -202,17 +236,19 @@ func (app *Config) UpdateInCommunicationHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Update the fields based on request
-	sale.InCommunication = request.InCommunication
-	sale.New = !request.InCommunication // Set New to false if InCommunication is true
-
-	// Append the new note to the existing note (on a new line)
-	if request.Note != "" {
-		sale.Note += "\n" + request.Note // Append the new note on a new line
+	// Move the sale into communication, or back to New when in_communication is false
+	target := StageInCommunication
+	if !request.InCommunication {
+		target = StageNew
+	}
+	from := sale.Stage
+	if err := transitionSale(&sale, target, request.Note); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// Update the sale record and log it
-	if err := app.DB.Save(&sale).Error; err != nil {
+	// Update the sale record, record the stage change and log it
+	if err := app.saveSaleTransition(&sale, from, requestActor(r), request.Note); err != nil {
 		http.Error(w, "Failed to update sale record", http.StatusInternalServerError)
 		return
 	}
@@ -220,7 +256,7 @@ func (app *Config) UpdateInCommunicationHandler(w http.ResponseWriter, r *http.R
 	// Print the updated sale data for debugging
 	fmt.Println("Updated sale record:")
 	fmt.Printf("Salename: %s\n", sale.Salename)
-	fmt.Printf("InCommunication: %t\n", sale.InCommunication)
+	fmt.Printf("Stage: %s\n", sale.Stage)
 	fmt.Printf("Note: %s\n", sale.Note)
 
 	// Success response
@@ -230,9 +266,9 @@ func (app *Config) UpdateInCommunicationHandler(w http.ResponseWriter, r *http.R
 	})
 }
 
-// UpdateDealHandler updates the Deal field in the sale record and modifies other fields as required
-// @Summary Update a sale record's deal status and associated information
-// @Description Updates the Deal field of an existing sale record by salename and optionally appends a note
+// UpdateDealHandler moves a sale into or out of the Deal stage
+// @Summary Move a sale into or out of the Deal stage
+// @Description Moves an existing sale record to Deal (or back to InCommunication when deal is false) and optionally appends a note
 // @Tags Sale
 // @Accept json
 // @Produce json
@@ -242,6 +278,7 @@ func (app *Config) UpdateInCommunicationHandler(w http.ResponseWriter, r *http.R
 // @Success 200 {object} map[string]string {"message": "Sale record updated successfully"}
 // @Failure 400 {object} map[string]string {"error": "Invalid request body"}
 // @Failure 404 {object} map[string]string {"error": "Sale not found"}
+// @Failure 409 {object} map[string]string {"error": "Illegal stage transition"}
 // @Failure 500 {object} map[string]string {"error": "Database error"}
 // @Router /sales/update-deal [put]
 func (app *Config) UpdateDealHandler(w http.ResponseWriter, r *http.Request) {
@@ -275,23 +312,19 @@ func (app *Config) UpdateDealHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the fields based on request
-	sale.Deal = request.Deal
-	if request.Deal {
-		sale.New = false
-		sale.InCommunication = false
+	// Move the sale to Deal, or back into communication when deal is false
+	target := StageDeal
+	if !request.Deal {
+		target = StageInCommunication
 	}
-
-	// Append the new note to the existing note (on a new line)
-	if request.Note != "" {
-		sale.Note += "\n" + request.Note // Append the new note on a new line
+	from := sale.Stage
+	if err := transitionSale(&sale, target, request.Note); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// Update the UpdatedAt field to the current time
-	sale.UpdatedAt = time.Now()
-
-	// Update the sale record and log it
-	if err := app.DB.Save(&sale).Error; err != nil {
+	// Update the sale record, record the stage change and log it
+	if err := app.saveSaleTransition(&sale, from, requestActor(r), request.Note); err != nil {
 		http.Error(w, "Failed to update sale record", http.StatusInternalServerError)
 		return
 	}
@@ -299,9 +332,7 @@ func (app *Config) UpdateDealHandler(w http.ResponseWriter, r *http.Request) {
 	// Print the updated sale data for debugging
 	fmt.Println("Updated sale record:")
 	fmt.Printf("Salename: %s\n", sale.Salename)
-	fmt.Printf("Deal: %t\n", sale.Deal)
-	fmt.Printf("New: %t\n", sale.New)
-	fmt.Printf("InCommunication: %t\n", sale.InCommunication)
+	fmt.Printf("Stage: %s\n", sale.Stage)
 	fmt.Printf("Note: %s\n", sale.Note)
 	fmt.Printf("UpdatedAt: %s\n", sale.UpdatedAt)
 
@@ -312,23 +343,27 @@ func (app *Config) UpdateDealHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// UpdateClosedHandler updates the "Closed" field in the sale record and modifies other fields as required
+// UpdateClosedHandler moves a sale to the Closed stage as won or lost
 // @Summary Close a sale record and update associated information
-// @Description Updates the "Closed" field of an existing sale record by salename and optionally appends a note
+// @Description Moves an existing sale record to the Closed stage by salename, records whether it was won or lost and optionally appends a note
 // @Tags Sale
 // @Accept json
 // @Produce json
 // @Param salename body string true "Salename"
+// @Param outcome body string true "won or lost"
+// @Param loss_reason body string false "Loss reason code, required when the outcome is lost"
 // @Param note body string false "Note to append to the sale record"
 // @Success 200 {object} map[string]string {"message": "Sale record closed successfully"}
-// @Failure 400 {object} map[string]string {"error": "Invalid request body"}
+// @Failure 400 {object} map[string]string {"error": "Invalid request body, outcome or loss reason"}
 // @Failure 404 {object} map[string]string {"error": "Sale not found"}
+// @Failure 409 {object} map[string]string {"error": "Illegal stage transition"}
 // @Failure 500 {object} map[string]string {"error": "Database error"}
 // @Router /sales/update-closed [put]
 func (app *Config) UpdateClosedHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Salename string `json:"salename"`
 		Note     string `json:"note"`
+		saleOutcome
 	}
 
 	// Print incoming request data to logs for debugging
@@ -354,22 +389,21 @@ func (app *Config) UpdateClosedHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the fields based on request
-	sale.Closed = true
-	sale.New = false
-	sale.InCommunication = false
-	sale.Deal = false
-
-	// Append the note text, if provided
-	if request.Note != "" {
-		sale.Note += "\n" + request.Note
+	// Close the sale
+	from := sale.Stage
+	if err := transitionSale(&sale, StageClosed, request.Note); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// Update the UpdatedAt field
-	sale.UpdatedAt = time.Now()
+	// Record whether the sale was won or lost
+	if err := app.applyOutcome(&sale, request.saleOutcome); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Update the sale record in the database
-	if err := app.DB.Save(&sale).Error; err != nil {
+	// Update the sale record in the database and record the stage change
+	if err := app.saveSaleTransition(&sale, from, requestActor(r), request.Note); err != nil {
 		http.Error(w, "Failed to update sale record", http.StatusInternalServerError)
 		return
 	}
@@ -377,7 +411,8 @@ func (app *Config) UpdateClosedHandler(w http.ResponseWriter, r *http.Request) {
 	// Print the updated sale data for debugging
 	fmt.Println("Updated sale record:")
 	fmt.Printf("Salename: %s\n", sale.Salename)
-	fmt.Printf("Closed: %t\n", sale.Closed)
+	fmt.Printf("Stage: %s\n", sale.Stage)
+	fmt.Printf("Outcome: %s\n", sale.Outcome)
 	fmt.Printf("Note: %s\n", sale.Note)
 
 	// Success response
@@ -386,3 +421,735 @@ func (app *Config) UpdateClosedHandler(w http.ResponseWriter, r *http.Request) {
 		"message": "Sale record closed successfully",
 	})
 }
+
+// TransitionSaleHandler moves a sale to another pipeline stage
+// @Summary Move a sale to another stage
+// @Description Moves the sale identified by id to the requested stage if the transition is allowed and optionally appends a note
+// @Tags Sale
+// @Accept json
+// @Produce json
+// @Param id path int true "Sale ID"
+// @Param stage body string true "Target stage (New, InCommunication, Deal, Closed)"
+// @Param outcome body string false "won or lost, required when the target stage is Closed"
+// @Param loss_reason body string false "Loss reason code, required when the outcome is lost"
+// @Param note body string false "Note to append to the sale record"
+// @Success 200 {object} map[string]string {"message": "Sale stage updated successfully"}
+// @Failure 400 {string} string "Invalid sale ID, invalid request body, unknown stage, or invalid outcome"
+// @Failure 404 {string} string "Sale not found"
+// @Failure 409 {string} string "Illegal stage transition"
+// @Failure 500 {string} string "Failed to update sale record"
+// @Router /sales/{id}/transition [post]
+func (app *Config) TransitionSaleHandler(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sale ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Stage string `json:"stage"`
+		Note  string `json:"note"`
+		saleOutcome
+	}
+
+	// Decode request body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the target stage exists before looking up the sale
+	if !isValidStage(request.Stage) {
+		http.Error(w, "Unknown stage", http.StatusBadRequest)
+		return
+	}
+
+	// Find sale by ID
+	var sale Sale
+	if err := app.DB.First(&sale, saleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Sale not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Apply the transition, rejecting moves the pipeline does not allow
+	from := sale.Stage
+	if err := transitionSale(&sale, request.Stage, request.Note); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// Closing a sale requires its outcome
+	if sale.Stage == StageClosed {
+		if err := app.applyOutcome(&sale, request.saleOutcome); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if request.Outcome != "" {
+		http.Error(w, "outcome is only allowed when closing a sale", http.StatusBadRequest)
+		return
+	}
+
+	// Update the sale record in the database and record the stage change
+	if err := app.saveSaleTransition(&sale, from, requestActor(r), request.Note); err != nil {
+		http.Error(w, "Failed to update sale record", http.StatusInternalServerError)
+		return
+	}
+
+	// Success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Sale stage updated successfully",
+		"salename": sale.Salename,
+		"from":     from,
+		"stage":    sale.Stage,
+	})
+}
+
+// SaleHistoryHandler returns the stage history of a sale
+// @Summary Get the stage history of a sale
+// @Description Lists every stage change of the sale identified by id, oldest first, with the time the sale spent in each stage
+// @Tags Sale
+// @Produce json
+// @Param id path int true "Sale ID"
+// @Success 200 {array} stageHistoryEntry "Stage history"
+// @Failure 400 {string} string "Invalid sale ID"
+// @Failure 404 {string} string "Sale not found"
+// @Failure 500 {string} string "Database error"
+// @Router /sales/{id}/history [get]
+func (app *Config) SaleHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sale ID", http.StatusBadRequest)
+		return
+	}
+
+	// Find sale by ID
+	var sale Sale
+	if err := app.DB.First(&sale, saleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Sale not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch the history entries, oldest first
+	var history []SaleStageHistory
+	if err := app.DB.Where("sale_id = ?", sale.ID).Order("created_at ASC, id ASC").Find(&history).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildStageHistory(history, time.Now()))
+}
+
+// GetSaleHandler retrieves a sale by ID
+// @Summary Get a sale
+// @Description Fetch a sale record by ID
+// @Tags Sale
+// @Produce json
+// @Param id path int true "Sale ID"
+// @Success 200 {object} saleResponse
+// @Failure 400 {string} string "Invalid sale ID"
+// @Failure 404 {string} string "Sale not found"
+// @Failure 500 {string} string "Database error"
+// @Router /sales/{id} [get]
+func (app *Config) GetSaleHandler(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sale ID", http.StatusBadRequest)
+		return
+	}
+
+	// Find sale by ID
+	var sale Sale
+	if err := app.DB.First(&sale, saleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Sale not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with sale data in JSON format
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSaleResponse(sale))
+}
+
+// UpdateSaleHandler updates the customer, owner and deal value of a sale
+// @Summary Update a sale
+// @Description Updates the fields given in the request body of the sale identified by id. Omitted fields are left unchanged.
+// @Description Sales representatives can only update the sales they own, Admins every sale.
+// @Tags Sale
+// @Accept json
+// @Produce json
+// @Param id path int true "Sale ID"
+// @Param customer_id body int false "Customer ID in customer-service"
+// @Param owner_user_id body int false "Owning sales representative's user ID in user-service, sales representatives can only give their own"
+// @Param amount body string false "Deal value as a decimal string, e.g. \"1250.50\""
+// @Param currency body string false "ISO 4217 currency code"
+// @Param probability body int false "Win probability in percent (0-100)"
+// @Param expected_close_date body string false "Expected close date (YYYY-MM-DD), empty to clear"
+// @Success 200 {object} saleResponse
+// @Failure 400 {string} string "Invalid sale ID, invalid request body, invalid value or unknown customer/owner"
+// @Failure 403 {string} string "Sales representatives can only change their own sales and assign them to themselves"
+// @Failure 404 {string} string "Sale not found"
+// @Failure 500 {string} string "Failed to update sale record"
+// @Failure 502 {string} string "Failed to validate customer or owner"
+// @Router /sales/{id} [put]
+func (app *Config) UpdateSaleHandler(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sale ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		CustomerID  *uint `json:"customer_id"`
+		OwnerUserID *uint `json:"owner_user_id"`
+		saleValues
+	}
+
+	// Decode request body
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Find sale by ID
+	var sale Sale
+	if err := app.DB.First(&sale, saleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Sale not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Sales representatives can only change the sales they own
+	if caller, _ := authUserFromContext(r.Context()); !caller.ownsSale(sale) {
+		http.Error(w, ErrSaleNotOwned, http.StatusForbidden)
+		return
+	}
+
+	// Make sure the linked customer and owner exist
+	if err := app.checkSaleLinks(r, request.CustomerID, request.OwnerUserID); err != nil {
+		writeSaleLinkError(w, err)
+		return
+	}
+
+	// Update the fields given in the request
+	if request.CustomerID != nil {
+		sale.CustomerID = request.CustomerID
+	}
+	if request.OwnerUserID != nil {
+		sale.OwnerUserID = request.OwnerUserID
+	}
+	if err := request.saleValues.apply(&sale); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.DB.Save(&sale).Error; err != nil {
+		http.Error(w, "Failed to update sale record", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with the updated sale
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSaleResponse(sale))
+}
+
+// ListSalesHandler lists sales with filtering, sorting and cursor pagination
+// @Summary List sales
+// @Description Lists sales matching the filters. Results are paginated with an opaque cursor; the next page is advertised in the Link header.
+// @Tags Sale
+// @Produce json
+// @Param stage query string false "Only sales in this stage"
+// @Param customer query int false "Only sales linked to this customer ID"
+// @Param owner query int false "Only sales owned by this user ID"
+// @Param created_after query string false "Only sales created after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param q query string false "Search term matched against salename and note"
+// @Param sort query string false "Sort field (id, salename, stage, amount, created_at, updated_at), prefix with - for descending. Default -created_at"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page's Link header"
+// @Success 200 {array} saleResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Database error"
+// @Router /sales [get]
+func (app *Config) ListSalesHandler(w http.ResponseWriter, r *http.Request) {
+	app.listSales(w, r, app.DB.Model(&Sale{}))
+}
+
+// ListCustomerSalesHandler lists the sales linked to a customer
+// @Summary List sales of a customer
+// @Description Lists the sales linked to the customer identified by id. Accepts the same query parameters as GET /sales.
+// @Tags Sale
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Success 200 {array} saleResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Database error"
+// @Router /customers/{id}/sales [get]
+func (app *Config) ListCustomerSalesHandler(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	app.listSales(w, r, app.DB.Model(&Sale{}).Where("customer_id = ?", customerID))
+}
+
+// ListOwnerSalesHandler lists the sales owned by a sales representative
+// @Summary List sales of a sales representative
+// @Description Lists the sales owned by the user identified by id. Accepts the same query parameters as GET /sales.
+// @Tags Sale
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} saleResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Database error"
+// @Router /users/{id}/sales [get]
+func (app *Config) ListOwnerSalesHandler(w http.ResponseWriter, r *http.Request) {
+	ownerUserID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	app.listSales(w, r, app.DB.Model(&Sale{}).Where("owner_user_id = ?", ownerUserID))
+}
+
+// listSales applies the list query parameters to query and writes one page of sales
+func (app *Config) listSales(w http.ResponseWriter, r *http.Request, query *gorm.DB) {
+	params := r.URL.Query()
+
+	// Parse sorting and page size
+	sort, err := parseSort(params.Get("sort"), "-created_at", saleSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parsePageSize(params.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Apply filters
+	if stage := params.Get("stage"); stage != "" {
+		if !isValidStage(stage) {
+			http.Error(w, "Unknown stage", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("stage = ?", stage)
+	}
+	if createdAfter := params.Get("created_after"); createdAfter != "" {
+		t, err := parseDateParam(createdAfter)
+		if err != nil {
+			http.Error(w, "Invalid created_after", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at > ?", t)
+	}
+	if customer := params.Get("customer"); customer != "" {
+		customerID, err := strconv.ParseUint(customer, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid customer", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("customer_id = ?", customerID)
+	}
+	if owner := params.Get("owner"); owner != "" {
+		ownerUserID, err := strconv.ParseUint(owner, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid owner", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("owner_user_id = ?", ownerUserID)
+	}
+	if q := params.Get("q"); q != "" {
+		pattern := likePattern(q)
+		query = query.Where("(salename ILIKE ? OR note ILIKE ?)", pattern, pattern)
+	}
+
+	// Continue after the cursor, if one was given
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw, sort)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clause, args, err := sort.AfterCursor(cursor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(clause, args...)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var sales []Sale
+	if err := query.Order(sort.OrderClause()).Limit(limit + 1).Find(&sales).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(sales) > limit {
+		sales = sales[:limit]
+		next = encodeCursor(saleCursor(sales[len(sales)-1], sort))
+	}
+
+	response := make([]saleResponse, 0, len(sales))
+	for _, sale := range sales {
+		response = append(response, newSaleResponse(sale))
+	}
+
+	// Send JSON response with pagination links
+	setPaginationLinks(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// saleCursor builds the cursor pointing after the given sale for the given sort
+func saleCursor(sale Sale, sort listSort) pageCursor {
+	cursor := pageCursor{Sort: sort.Key, ID: sale.ID}
+	switch sort.Field.Column {
+	case "salename":
+		cursor.Value = sale.Salename
+	case "stage":
+		cursor.Value = sale.Stage
+	case "amount":
+		cursor.Value = sale.Amount.String()
+	case "created_at":
+		cursor.Value = sale.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = sale.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// ForecastHandler returns the weighted pipeline value per stage for a period
+// @Summary Sales forecast
+// @Description Sums the amount and the probability-weighted amount of the open sales expected to close in the period, per stage and currency
+// @Tags Sale
+// @Produce json
+// @Param period query string false "Year (2025), quarter (2025-Q2) or month (2025-04). Defaults to the current quarter"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid period"
+// @Failure 500 {string} string "Database error"
+// @Router /sales/forecast [get]
+func (app *Config) ForecastHandler(w http.ResponseWriter, r *http.Request) {
+	// Work out the period to forecast
+	period := currentQuarter(time.Now())
+	if param := r.URL.Query().Get("period"); param != "" {
+		var err error
+		period, err = parsePeriod(param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fetch the open sales expected to close in the period
+	var sales []Sale
+	err := app.DB.Where("stage <> ?", StageClosed).
+		Where("expected_close_date >= ? AND expected_close_date < ?", period.Start, period.End).
+		Find(&sales).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period": period.Name,
+		"from":   period.Start.Format(DateLayout),
+		"to":     period.End.AddDate(0, 0, -1).Format(DateLayout),
+		"stages": buildForecast(sales),
+	})
+}
+
+// ListLossReasonsHandler lists the loss reason catalog
+// @Summary List loss reasons
+// @Description Lists the loss reasons sales can be closed as lost with. Inactive reasons are included only with active=false.
+// @Tags LossReason
+// @Produce json
+// @Param active query bool false "Filter by active flag (default true)"
+// @Success 200 {array} lossReasonResponse
+// @Failure 500 {string} string "Database error"
+// @Router /loss-reasons [get]
+func (app *Config) ListLossReasonsHandler(w http.ResponseWriter, r *http.Request) {
+	active := r.URL.Query().Get("active") != "false"
+
+	var reasons []LossReason
+	if err := app.DB.Where("active = ?", active).Order("code").Find(&reasons).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]lossReasonResponse, 0, len(reasons))
+	for _, reason := range reasons {
+		response = append(response, newLossReasonResponse(reason))
+	}
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateLossReasonHandler adds a loss reason to the catalog
+// @Summary Create a loss reason
+// @Description Adds a new loss reason to the catalog. Admins only.
+// @Tags LossReason
+// @Accept json
+// @Produce json
+// @Param code body string true "Unique code, e.g. competitor"
+// @Param label body string true "Human readable label"
+// @Success 201 {object} lossReasonResponse
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "Loss reason already exists"
+// @Failure 500 {string} string "Failed to create loss reason"
+// @Router /loss-reasons [post]
+func (app *Config) CreateLossReasonHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+	if caller.Role != RoleAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Code  string `json:"code"`
+		Label string `json:"label"`
+	}
+
+	// Decode request body
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Code == "" || request.Label == "" {
+		http.Error(w, "Code and label are required", http.StatusBadRequest)
+		return
+	}
+
+	// Check if the code is already taken
+	var existing LossReason
+	if err := app.DB.Where("code = ?", request.Code).First(&existing).Error; err == nil {
+		http.Error(w, "Loss reason already exists", http.StatusConflict)
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	reason := LossReason{Code: request.Code, Label: request.Label, Active: true}
+	if err := app.DB.Create(&reason).Error; err != nil {
+		http.Error(w, "Failed to create loss reason", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with the new loss reason
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newLossReasonResponse(reason))
+}
+
+// UpdateLossReasonHandler changes the label of a loss reason or (de)activates it
+// @Summary Update a loss reason
+// @Description Updates the label or active flag of a loss reason. Reasons are deactivated rather than deleted so closed sales keep their reason. Admins only.
+// @Tags LossReason
+// @Accept json
+// @Produce json
+// @Param id path int true "Loss reason ID"
+// @Param label body string false "New label"
+// @Param active body bool false "Whether the reason can be chosen"
+// @Success 200 {object} lossReasonResponse
+// @Failure 400 {string} string "Invalid loss reason ID or request body"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Loss reason not found"
+// @Failure 500 {string} string "Failed to update loss reason"
+// @Router /loss-reasons/{id} [put]
+func (app *Config) UpdateLossReasonHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+	if caller.Role != RoleAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	reasonID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid loss reason ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Label  *string `json:"label"`
+		Active *bool   `json:"active"`
+	}
+
+	// Decode request body
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Find the loss reason by ID
+	var reason LossReason
+	if err := app.DB.First(&reason, reasonID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Loss reason not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Update the fields given in the request
+	if request.Label != nil {
+		if *request.Label == "" {
+			http.Error(w, "Label cannot be empty", http.StatusBadRequest)
+			return
+		}
+		reason.Label = *request.Label
+	}
+	if request.Active != nil {
+		reason.Active = *request.Active
+	}
+
+	if err := app.DB.Save(&reason).Error; err != nil {
+		http.Error(w, "Failed to update loss reason", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with the updated loss reason
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newLossReasonResponse(reason))
+}
+
+// WinLossReportHandler reports won and lost sales grouped by loss reason, owner and month
+// @Summary Win/loss report
+// @Description Counts the sales closed in the date range by outcome, grouped by loss reason, owner and month of closing
+// @Tags Reports
+// @Produce json
+// @Param from query string false "Start of the range (RFC 3339 or YYYY-MM-DD), defaults to one year ago"
+// @Param to query string false "End of the range (RFC 3339 or YYYY-MM-DD), defaults to now"
+// @Success 200 {object} winLossReport
+// @Failure 400 {string} string "Invalid date range"
+// @Failure 500 {string} string "Database error"
+// @Router /reports/win-loss [get]
+func (app *Config) WinLossReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch the sales closed in the range
+	var sales []Sale
+	err = app.DB.Where("stage = ? AND closed_at >= ? AND closed_at < ?", StageClosed, from, to).Find(&sales).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Load the catalog, including inactive reasons used by older sales
+	var catalog []LossReason
+	if err := app.DB.Find(&catalog).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	reasons := make(map[uint]LossReason, len(catalog))
+	for _, reason := range catalog {
+		reasons[reason.ID] = reason
+	}
+
+	report := buildWinLossReport(sales, reasons)
+	report.From = from.Format(time.RFC3339)
+	report.To = to.Format(time.RFC3339)
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// parseDateRange reads the "from" and "to" query parameters, defaulting to the year before now
+func parseDateRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	from, to := now.AddDate(-1, 0, 0), now
+
+	if param := r.URL.Query().Get("from"); param != "" {
+		t, err := parseDateParam(param)
+		if err != nil {
+			return from, to, errors.New("invalid from")
+		}
+		from = t
+	}
+	if param := r.URL.Query().Get("to"); param != "" {
+		t, err := parseDateParam(param)
+		if err != nil {
+			return from, to, errors.New("invalid to")
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// PipelineReportHandler reports stage counts and values, conversion rates and time spent in each stage
+// @Summary Pipeline analytics
+// @Description For the sales created in the date range, returns counts and values per stage, stage-to-stage conversion percentages and the average and median days spent in each stage
+// @Tags Reports
+// @Produce json
+// @Param from query string false "Start of the range (RFC 3339 or YYYY-MM-DD), defaults to one year ago"
+// @Param to query string false "End of the range (RFC 3339 or YYYY-MM-DD), defaults to now"
+// @Success 200 {object} pipelineReport
+// @Failure 400 {string} string "Invalid date range"
+// @Failure 500 {string} string "Database error"
+// @Router /reports/pipeline [get]
+func (app *Config) PipelineReportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch the sales created in the range
+	var sales []Sale
+	if err := app.DB.Where("created_at >= ? AND created_at < ?", from, to).Find(&sales).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch their stage history, oldest first
+	cohortIDs := app.DB.Model(&Sale{}).Select("id").Where("created_at >= ? AND created_at < ?", from, to)
+	var history []SaleStageHistory
+	err = app.DB.Where("sale_id IN (?)", cohortIDs).
+		Order("created_at ASC, id ASC").
+		Find(&history).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	report := buildPipelineReport(sales, history)
+	report.From = from.Format(time.RFC3339)
+	report.To = to.Format(time.RFC3339)
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
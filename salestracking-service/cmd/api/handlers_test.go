@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockApp returns a Config whose database is a sqlmock expecting nothing unless told so
+func newMockApp(t *testing.T) (*Config, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	return &Config{DB: db}, mock
+}
+
+// withID returns the request as chi routes it, with the {id} path parameter set
+func withID(r *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// withCaller returns the request as AuthMiddleware passes it on for the given caller
+func withCaller(r *http.Request, caller AuthUser) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authUserKey, caller))
+}
+
+// Test that IDs in the path are parsed as numbers and never reach the database as SQL
+func TestPathIDMustBeNumeric(t *testing.T) {
+	app, mock := newMockApp(t)
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		handler http.HandlerFunc
+	}{
+		{"transition", http.MethodPost, `{"stage":"InCommunication"}`, app.TransitionSaleHandler},
+		{"history", http.MethodGet, "", app.SaleHistoryHandler},
+		{"get", http.MethodGet, "", app.GetSaleHandler},
+		{"update", http.MethodPut, `{"probability":50}`, app.UpdateSaleHandler},
+		{"loss reason", http.MethodPut, `{"label":"Too expensive"}`, app.UpdateLossReasonHandler},
+	} {
+		for _, id := range []string{"id > 0 OR salename LIKE 'a%'", "1; DROP TABLE sales", "-1", ""} {
+			req := withID(withCaller(httptest.NewRequest(tc.method, "/", strings.NewReader(tc.body)), AuthUser{Username: "root", Role: RoleAdmin}), id)
+			rec := httptest.NewRecorder()
+			tc.handler(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, "%s with id %q", tc.name, id)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "No query may run with an invalid ID")
+}
+
+// Test that sales representatives can only update the sales they own while Admins update every sale
+func TestUpdateSaleRequiresOwner(t *testing.T) {
+	rep := AuthUser{ID: 5, Username: "alice", Role: "Sales Representative"}
+	admin := AuthUser{ID: 1, Username: "root", Role: RoleAdmin}
+
+	for _, tc := range []struct {
+		name   string
+		caller AuthUser
+		owner  interface{}
+		status int
+	}{
+		{"someone else's sale", rep, 9, http.StatusForbidden},
+		{"sale without owner", rep, nil, http.StatusForbidden},
+		{"token without user ID", AuthUser{Username: "alice", Role: "Sales Representative"}, 5, http.StatusForbidden},
+		{"own sale", rep, 5, http.StatusOK},
+		{"Admin", admin, 9, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, mock := newMockApp(t)
+			mock.ExpectQuery(`SELECT \* FROM "sales"`).WillReturnRows(sqlmock.NewRows([]string{"id", "salename", "owner_user_id"}).AddRow(3, "Deal", tc.owner))
+			if tc.status == http.StatusOK {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "sales"`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/sales/3", strings.NewReader(`{"probability":50}`))
+			req = withID(withCaller(req, tc.caller), "3")
+			rec := httptest.NewRecorder()
+			app.UpdateSaleHandler(rec, req)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
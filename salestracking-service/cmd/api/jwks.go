@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Defaults of JWKSCache.RefetchInterval and JWKSCache.RetryInterval
+const (
+	jwksRefetchInterval = time.Second
+	jwksRetryInterval   = 30 * time.Second
+)
+
+// ErrUnknownSigningKey is returned for tokens whose kid is not in the user-service JWKS
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// publicKey is a verification key published by user-service
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// jwk is a public key in JSON Web Key format as served by user-service
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// JWKSCache fetches the public keys user-service signs tokens with and caches them for TTL.
+// Tokens naming an unknown key trigger an early refetch, so rotated keys are picked up at once.
+type JWKSCache struct {
+	URL             string
+	TTL             time.Duration
+	RefetchInterval time.Duration // Minimum time between fetches, limits refetches caused by unknown key IDs
+	RetryInterval   time.Duration // Time to wait after a failed fetch, cached keys keep being used meanwhile
+	Client          *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]publicKey
+	fetchedAt   time.Time // Last successful fetch
+	attemptedAt time.Time // Last fetch, successful or not
+	failed      bool      // Whether the last fetch failed
+}
+
+// NewJWKSCache returns a cache for the JWKS served at url
+func NewJWKSCache(url string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		URL:             url,
+		TTL:             ttl,
+		RefetchInterval: jwksRefetchInterval,
+		RetryInterval:   jwksRetryInterval,
+		Client:          &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Keyfunc returns the public key a token names in its kid header, for use with jwt.Parse.
+// Tokens with an algorithm that does not match their key are rejected.
+func (c *JWKSCache) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownSigningKey
+	}
+
+	key, err := c.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.key, nil
+}
+
+// key looks up a key, refetching the JWKS when it is stale or does not know kid.
+// If user-service cannot be reached the cached keys keep being used.
+func (c *JWKSCache) key(kid string) (publicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.keys[kid]
+	stale := time.Since(c.fetchedAt) > c.TTL
+	wait := c.RefetchInterval
+	if c.failed {
+		wait = c.RetryInterval
+	}
+	if (stale || !ok) && time.Since(c.attemptedAt) >= wait {
+		c.attemptedAt = time.Now()
+		err := c.refresh()
+		c.failed = err != nil
+		if err != nil {
+			log.Printf("⚠️ Failed to fetch user-service JWKS: %v", err)
+		} else {
+			key, ok = c.keys[kid]
+		}
+	}
+
+	if !ok {
+		return publicKey{}, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// refresh replaces the cached keys with the ones user-service currently publishes. The caller must hold c.mu.
+func (c *JWKSCache) refresh() error {
+	resp, err := c.Client.Get(c.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("⚠️ Skipping JWKS key %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// publicKey decodes an RS256 or EdDSA key
+func (k jwk) publicKey() (publicKey, error) {
+	switch {
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return publicKey{}, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 {
+			return publicKey{}, errors.New("invalid RSA key")
+		}
+		return publicKey{alg: "RS256", key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}, nil
+
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && (k.Alg == "" || k.Alg == "EdDSA"):
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid Ed25519 key")
+		}
+		return publicKey{alg: "EdDSA", key: ed25519.PublicKey(x)}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
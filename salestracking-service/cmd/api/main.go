@@ -11,7 +11,8 @@ import (
 )
 
 type Config struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	Directory Directory // Looks up customers and sales representatives in the other services
 }
 
 // connectToDB retries connecting to PostgreSQL until it succeeds or fails after retries
@@ -38,11 +39,23 @@ func connectToDB() (*gorm.DB, error) {
 	}
 
 	// AutoMigrate to create tables
-	err = db.AutoMigrate(&Sale{})
+	err = db.AutoMigrate(&Sale{}, &SaleStageHistory{}, &LossReason{})
 	if err != nil {
 		log.Fatalf("❌ Failed to migrate database: %v", err)
 	}
 
+	// Convert rows from the old boolean status columns to stages
+	err = migrateSaleStages(db)
+	if err != nil {
+		log.Fatalf("❌ Failed to migrate sale stages: %v", err)
+	}
+
+	// Fill the loss reason catalog on first start
+	err = seedLossReasons(db)
+	if err != nil {
+		log.Fatalf("❌ Failed to seed loss reasons: %v", err)
+	}
+
 	return db, nil
 }
 
@@ -55,10 +68,13 @@ func main() {
 		log.Fatal("❌ Database connection failed:", err)
 	}
 
+	app := &Config{DB: db, Directory: NewHTTPDirectory(CustomerServiceURL, UserServiceURL)}
+	app.StartTrashPurging(TrashRetention)
+
 	fmt.Printf("🚀 %s is running on port: %s\n", ServiceName, ServicePort)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", ServicePort),
-		Handler: (&Config{DB: db}).routes(),
+		Handler: app.routes(),
 	}
 
 	err = srv.ListenAndServe()
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// migrateSaleStages converts rows written before the Stage column existed.
+// Older rows tracked the pipeline with four boolean columns (new, in_communication,
+// deal, closed). The most advanced flag that is set wins, then the old columns are dropped.
+func migrateSaleStages(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Sale{}, "closed") {
+		return nil // Nothing to convert
+	}
+
+	fmt.Println("🔄 Migrating sale status flags to stages...")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE sales SET stage = CASE
+			WHEN closed THEN ?
+			WHEN deal THEN ?
+			WHEN in_communication THEN ?
+			ELSE ?
+		END`, StageClosed, StageDeal, StageInCommunication, StageNew).Error
+		if err != nil {
+			return err
+		}
+
+		for _, column := range []string{"new", "in_communication", "deal", "closed"} {
+			if !tx.Migrator().HasColumn(&Sale{}, column) {
+				continue
+			}
+			if err := tx.Migrator().DropColumn(&Sale{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Sale stage migration done!")
+	return nil
+}
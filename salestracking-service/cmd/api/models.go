@@ -1,16 +1,163 @@
 package main
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Sale model for GORM
 type Sale struct {
-	ID              uint      `gorm:"primaryKey"`
-	Salename        string    `gorm:"type:varchar(255);uniqueIndex;not null"`
-	New             bool      `gorm:"default:false"`
-	InCommunication bool      `gorm:"default:false"`
-	Deal            bool      `gorm:"default:false"`
-	Closed          bool      `gorm:"default:false"`
-	Note            string    `gorm:"type:text"`
-	CreatedAt       time.Time `gorm:"autoCreateTime"`
-	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+	ID                uint       `gorm:"primaryKey"`
+	Salename          string     `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Stage             string     `gorm:"type:varchar(32);not null;default:'New';index"` // Current pipeline stage, see stages.go
+	CustomerID        *uint      `gorm:"index"`                                         // Customer in customer-service
+	OwnerUserID       *uint      `gorm:"index"`                                         // Owning sales representative in user-service
+	Amount            Amount     `gorm:"type:numeric(18,2);not null;default:0"`         // Deal value, see money.go
+	Currency          string     `gorm:"type:char(3)"`                                  // ISO 4217 currency code of Amount
+	Probability       int        `gorm:"not null;default:0"`                            // Win probability in percent (0-100)
+	ExpectedCloseDate *time.Time `gorm:"type:date;index"`
+	Outcome           string     `gorm:"type:varchar(8);index"` // won or lost once the sale is Closed, see outcomes.go
+	LossReasonID      *uint      `gorm:"index"`                 // Why the sale was lost, set only when Outcome is lost
+	ClosedAt          *time.Time `gorm:"index"`
+	Note              string     `gorm:"type:text"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime"`
+
+	// Set by /delete-sale, deleted sales are hidden until restored or purged, see trash.go
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// SaleStageHistory records every stage change of a sale
+type SaleStageHistory struct {
+	ID        uint      `gorm:"primaryKey"`
+	SaleID    uint      `gorm:"index;not null"`
+	FromStage string    `gorm:"type:varchar(32)"` // Empty for the entry written when the sale is created
+	ToStage   string    `gorm:"type:varchar(32);not null"`
+	Actor     string    `gorm:"type:varchar(255)"` // Username taken from the caller's verified JWT
+	Comment   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
+// LossReason is an entry of the configurable catalog of reasons a sale can be lost for
+type LossReason struct {
+	ID        uint      `gorm:"primaryKey"`
+	Code      string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Label     string    `gorm:"type:varchar(255);not null"`
+	Active    bool      `gorm:"default:true"` // Inactive reasons are kept for old sales but cannot be chosen
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// DateLayout is the format of calendar dates in requests and responses
+const DateLayout = "2006-01-02"
+
+// saleResponse is the JSON representation of a sale returned by the read endpoints
+type saleResponse struct {
+	ID                uint       `json:"id"`
+	Salename          string     `json:"salename"`
+	Stage             string     `json:"stage"`
+	CustomerID        *uint      `json:"customer_id"`
+	OwnerUserID       *uint      `json:"owner_user_id"`
+	Amount            Amount     `json:"amount"`
+	Currency          string     `json:"currency"`
+	Probability       int        `json:"probability"`
+	ExpectedCloseDate *string    `json:"expected_close_date"`
+	Outcome           string     `json:"outcome,omitempty"`
+	LossReasonID      *uint      `json:"loss_reason_id,omitempty"`
+	ClosedAt          *time.Time `json:"closed_at,omitempty"`
+	Note              string     `json:"note"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	DeletedAt         *time.Time `json:"deleted_at,omitempty"` // Only set for sales in the trash
+}
+
+// newSaleResponse copies the public fields of a sale into its response form
+func newSaleResponse(sale Sale) saleResponse {
+	response := saleResponse{
+		ID:           sale.ID,
+		Salename:     sale.Salename,
+		Stage:        sale.Stage,
+		CustomerID:   sale.CustomerID,
+		OwnerUserID:  sale.OwnerUserID,
+		Amount:       sale.Amount,
+		Currency:     sale.Currency,
+		Probability:  sale.Probability,
+		Outcome:      sale.Outcome,
+		LossReasonID: sale.LossReasonID,
+		ClosedAt:     sale.ClosedAt,
+		Note:         sale.Note,
+		CreatedAt:    sale.CreatedAt,
+		UpdatedAt:    sale.UpdatedAt,
+	}
+	if sale.ExpectedCloseDate != nil {
+		date := sale.ExpectedCloseDate.Format(DateLayout)
+		response.ExpectedCloseDate = &date
+	}
+	if sale.DeletedAt.Valid {
+		response.DeletedAt = &sale.DeletedAt.Time
+	}
+	return response
+}
+
+// saleValues holds the deal value fields accepted when creating or updating a sale.
+// Nil fields are left unchanged.
+type saleValues struct {
+	Amount            *Amount `json:"amount"`
+	Currency          *string `json:"currency"`
+	Probability       *int    `json:"probability"`
+	ExpectedCloseDate *string `json:"expected_close_date"` // YYYY-MM-DD, an empty string clears the date
+}
+
+// apply validates the values and copies the given ones onto the sale
+func (v saleValues) apply(sale *Sale) error {
+	if v.Amount != nil {
+		if *v.Amount < 0 {
+			return errors.New("amount cannot be negative")
+		}
+		sale.Amount = *v.Amount
+	}
+	if v.Currency != nil {
+		if !isValidCurrency(*v.Currency) {
+			return errors.New("currency must be an ISO 4217 code such as EUR")
+		}
+		sale.Currency = *v.Currency
+	}
+	if v.Probability != nil {
+		if *v.Probability < 0 || *v.Probability > 100 {
+			return errors.New("probability must be between 0 and 100")
+		}
+		sale.Probability = *v.Probability
+	}
+	if v.ExpectedCloseDate != nil {
+		if *v.ExpectedCloseDate == "" {
+			sale.ExpectedCloseDate = nil
+		} else {
+			date, err := time.Parse(DateLayout, *v.ExpectedCloseDate)
+			if err != nil {
+				return errors.New("expected_close_date must be a YYYY-MM-DD date")
+			}
+			sale.ExpectedCloseDate = &date
+		}
+	}
+
+	// An amount means nothing without its currency
+	if sale.Amount != 0 && sale.Currency == "" {
+		return errors.New("currency is required when amount is set")
+	}
+	return nil
+}
+
+// lossReasonResponse is the JSON representation of a loss reason
+type lossReasonResponse struct {
+	ID     uint   `json:"id"`
+	Code   string `json:"code"`
+	Label  string `json:"label"`
+	Active bool   `json:"active"`
+}
+
+// newLossReasonResponse copies a loss reason into its response form
+func newLossReasonResponse(reason LossReason) lossReasonResponse {
+	return lossReasonResponse{ID: reason.ID, Code: reason.Code, Label: reason.Label, Active: reason.Active}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount is an exact monetary value with two fraction digits.
+// It is kept as an integer number of hundredths so no precision is lost to floating point,
+// stored in a NUMERIC column and sent over JSON as a string such as "1250.50".
+type Amount int64
+
+// ErrInvalidAmount is returned for values that are not a decimal number with at most two fraction digits
+var ErrInvalidAmount = errors.New("invalid amount")
+
+var amountPattern = regexp.MustCompile(`^(-?)(\d+)(?:\.(\d{1,2}))?$`)
+
+// currencyPattern matches ISO 4217 alphabetic currency codes
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ParseAmount parses a decimal string such as "1250", "1250.5" or "1250.50"
+func ParseAmount(s string) (Amount, error) {
+	m := amountPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, ErrInvalidAmount
+	}
+
+	units, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil || units > (1<<62)/100 {
+		return 0, ErrInvalidAmount
+	}
+	cents := int64(0)
+	if m[3] != "" {
+		cents, _ = strconv.ParseInt((m[3] + "0")[:2], 10, 64)
+	}
+
+	value := units*100 + cents
+	if m[1] == "-" {
+		value = -value
+	}
+	return Amount(value), nil
+}
+
+// String formats the amount with exactly two fraction digits
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Weighted returns the amount multiplied by a percentage, rounded half away from zero
+func (a Amount) Weighted(percent int) Amount {
+	v := int64(a) * int64(percent)
+	if v >= 0 {
+		return Amount((v + 50) / 100)
+	}
+	return Amount((v - 50) / 100)
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts either a decimal string or a bare JSON number.
+// Numbers are parsed from their literal text, never through float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return ErrInvalidAmount
+		}
+	}
+
+	parsed, err := ParseAmount(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value stores the amount as a NUMERIC literal
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads the amount back from a NUMERIC column
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	case int64:
+		*a = Amount(v * 100)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Amount", src)
+	}
+}
+
+// scanString parses a NUMERIC value, which the database may return with trailing zeros
+func (a *Amount) scanString(s string) error {
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i > 3 {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// isValidCurrency reports whether code looks like an ISO 4217 currency code
+func isValidCurrency(code string) bool {
+	return currencyPattern.MatchString(code)
+}
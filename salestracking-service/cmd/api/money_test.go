@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that amounts are parsed exactly and rejected when they carry too many fraction digits
+func TestParseAmount(t *testing.T) {
+	cases := map[string]Amount{
+		"0":       0,
+		"1250":    125000,
+		"1250.5":  125050,
+		"1250.05": 125005,
+		"-3.10":   -310,
+	}
+	for input, expected := range cases {
+		amount, err := ParseAmount(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, amount, input)
+	}
+
+	for _, input := range []string{"", "abc", "1.234", "1,5", "1e3"} {
+		_, err := ParseAmount(input)
+		assert.ErrorIs(t, err, ErrInvalidAmount, input)
+	}
+}
+
+// Test that amounts round-trip through JSON as strings and accept bare numbers
+func TestAmountJSON(t *testing.T) {
+	var values struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+	}
+	err := json.Unmarshal([]byte(`{"a": "19.99", "b": 0.1}`), &values)
+	assert.NoError(t, err)
+	assert.Equal(t, Amount(1999), values.A)
+	assert.Equal(t, Amount(10), values.B)
+
+	data, _ := json.Marshal(values)
+	assert.JSONEq(t, `{"a": "19.99", "b": "0.10"}`, string(data))
+}
+
+// Test scanning NUMERIC values and weighting by probability
+func TestAmountScanAndWeighted(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan([]byte("1000.50")))
+	assert.Equal(t, Amount(100050), a)
+	assert.NoError(t, a.Scan("7.5000"))
+	assert.Equal(t, Amount(750), a)
+
+	assert.Equal(t, Amount(25013), Amount(100050).Weighted(25), "Weighted amounts should round half up")
+	assert.Equal(t, Amount(0), Amount(100050).Weighted(0))
+}
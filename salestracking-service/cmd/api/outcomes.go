@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Outcomes of a closed sale
+const (
+	OutcomeWon  = "won"
+	OutcomeLost = "lost"
+)
+
+// defaultLossReasons seeds the loss reason catalog the first time the service starts
+var defaultLossReasons = []LossReason{
+	{Code: "price", Label: "Price too high"},
+	{Code: "competitor", Label: "Lost to a competitor"},
+	{Code: "no_budget", Label: "No budget"},
+	{Code: "no_decision", Label: "No decision made"},
+	{Code: "other", Label: "Other"},
+}
+
+// saleOutcome holds the fields required when a sale is closed
+type saleOutcome struct {
+	Outcome    string `json:"outcome"`     // won or lost
+	LossReason string `json:"loss_reason"` // Code from the loss reason catalog, required when lost
+}
+
+// applyOutcome validates the outcome of a sale that has just been moved to Closed and records it
+func (app *Config) applyOutcome(sale *Sale, outcome saleOutcome) error {
+	sale.LossReasonID = nil
+
+	switch outcome.Outcome {
+	case OutcomeWon:
+		if outcome.LossReason != "" {
+			return errors.New("loss_reason is only allowed when the outcome is lost")
+		}
+	case OutcomeLost:
+		if outcome.LossReason == "" {
+			return errors.New("loss_reason is required when the outcome is lost")
+		}
+		var reason LossReason
+		err := app.DB.Where("code = ? AND active = ?", outcome.LossReason, true).First(&reason).Error
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("unknown loss_reason: %s", outcome.LossReason)
+		} else if err != nil {
+			return err
+		}
+		sale.LossReasonID = &reason.ID
+	default:
+		return errors.New("outcome must be won or lost")
+	}
+
+	closedAt := time.Now()
+	sale.Outcome = outcome.Outcome
+	sale.ClosedAt = &closedAt
+	return nil
+}
+
+// seedLossReasons fills the loss reason catalog with the defaults when it is empty
+func seedLossReasons(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&LossReason{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	reasons := make([]LossReason, len(defaultLossReasons))
+	copy(reasons, defaultLossReasons)
+	for i := range reasons {
+		reasons[i].Active = true
+	}
+	return db.Create(&reasons).Error
+}
+
+// winLossCount counts closed sales by outcome
+type winLossCount struct {
+	Won     int     `json:"won"`
+	Lost    int     `json:"lost"`
+	WinRate float64 `json:"win_rate"` // Percentage of closed sales that were won
+}
+
+func (c *winLossCount) add(outcome string) {
+	if outcome == OutcomeWon {
+		c.Won++
+	} else {
+		c.Lost++
+	}
+	c.WinRate = float64(c.Won) * 100 / float64(c.Won+c.Lost)
+}
+
+// winLossReport groups closed sales by loss reason, owner and month
+type winLossReport struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Total    winLossCount    `json:"total"`
+	ByReason []lossReasonRow `json:"by_reason"`
+	ByOwner  []ownerOutcomes `json:"by_owner"`
+	ByMonth  []monthOutcomes `json:"by_month"`
+}
+
+type lossReasonRow struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type ownerOutcomes struct {
+	OwnerUserID *uint `json:"owner_user_id"` // Null for sales without an owner
+	winLossCount
+}
+
+type monthOutcomes struct {
+	Month string `json:"month"` // YYYY-MM of the close date
+	winLossCount
+}
+
+// buildWinLossReport aggregates closed sales. reasons maps loss reason IDs to the catalog entries.
+func buildWinLossReport(sales []Sale, reasons map[uint]LossReason) winLossReport {
+	var report winLossReport
+	byReason := map[uint]*lossReasonRow{}
+	byOwner := map[uint]*ownerOutcomes{}
+	var unowned *ownerOutcomes
+	byMonth := map[string]*monthOutcomes{}
+
+	for _, sale := range sales {
+		if sale.Outcome == "" || sale.ClosedAt == nil {
+			continue // Closed before outcomes were recorded
+		}
+		report.Total.add(sale.Outcome)
+
+		if sale.Outcome == OutcomeLost && sale.LossReasonID != nil {
+			row, ok := byReason[*sale.LossReasonID]
+			if !ok {
+				reason := reasons[*sale.LossReasonID]
+				row = &lossReasonRow{Code: reason.Code, Label: reason.Label}
+				byReason[*sale.LossReasonID] = row
+			}
+			row.Count++
+		}
+
+		owner := unowned
+		if sale.OwnerUserID != nil {
+			owner = byOwner[*sale.OwnerUserID]
+		}
+		if owner == nil {
+			owner = &ownerOutcomes{OwnerUserID: sale.OwnerUserID}
+			if sale.OwnerUserID != nil {
+				byOwner[*sale.OwnerUserID] = owner
+			} else {
+				unowned = owner
+			}
+		}
+		owner.add(sale.Outcome)
+
+		month := sale.ClosedAt.Format("2006-01")
+		if byMonth[month] == nil {
+			byMonth[month] = &monthOutcomes{Month: month}
+		}
+		byMonth[month].add(sale.Outcome)
+	}
+
+	report.ByReason = make([]lossReasonRow, 0, len(byReason))
+	for _, row := range byReason {
+		report.ByReason = append(report.ByReason, *row)
+	}
+	sort.Slice(report.ByReason, func(i, j int) bool {
+		if report.ByReason[i].Count != report.ByReason[j].Count {
+			return report.ByReason[i].Count > report.ByReason[j].Count
+		}
+		return report.ByReason[i].Code < report.ByReason[j].Code
+	})
+
+	report.ByOwner = make([]ownerOutcomes, 0, len(byOwner)+1)
+	for _, row := range byOwner {
+		report.ByOwner = append(report.ByOwner, *row)
+	}
+	sort.Slice(report.ByOwner, func(i, j int) bool {
+		return *report.ByOwner[i].OwnerUserID < *report.ByOwner[j].OwnerUserID
+	})
+	if unowned != nil {
+		report.ByOwner = append(report.ByOwner, *unowned)
+	}
+
+	report.ByMonth = make([]monthOutcomes, 0, len(byMonth))
+	for _, row := range byMonth {
+		report.ByMonth = append(report.ByMonth, *row)
+	}
+	sort.Slice(report.ByMonth, func(i, j int) bool {
+		return report.ByMonth[i].Month < report.ByMonth[j].Month
+	})
+	return report
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the outcome rules that do not need the loss reason catalog
+func TestApplyOutcome(t *testing.T) {
+	app := &Config{}
+
+	sale := Sale{Stage: StageClosed}
+	assert.NoError(t, app.applyOutcome(&sale, saleOutcome{Outcome: OutcomeWon}))
+	assert.Equal(t, OutcomeWon, sale.Outcome)
+	assert.NotNil(t, sale.ClosedAt)
+
+	assert.Error(t, app.applyOutcome(&Sale{}, saleOutcome{}), "An outcome is required")
+	assert.Error(t, app.applyOutcome(&Sale{}, saleOutcome{Outcome: "maybe"}))
+	assert.Error(t, app.applyOutcome(&Sale{}, saleOutcome{Outcome: OutcomeLost}), "Lost sales need a reason")
+	assert.Error(t, app.applyOutcome(&Sale{}, saleOutcome{Outcome: OutcomeWon, LossReason: "price"}), "Won sales cannot have a loss reason")
+}
+
+// Test that the report groups outcomes by reason, owner and month
+func TestBuildWinLossReport(t *testing.T) {
+	march := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2025, 4, 2, 0, 0, 0, 0, time.UTC)
+	alice, bob := uint(1), uint(2)
+	price, competitor := uint(10), uint(11)
+
+	sales := []Sale{
+		{Outcome: OutcomeWon, OwnerUserID: &alice, ClosedAt: &march},
+		{Outcome: OutcomeLost, LossReasonID: &price, OwnerUserID: &alice, ClosedAt: &march},
+		{Outcome: OutcomeLost, LossReasonID: &price, OwnerUserID: &bob, ClosedAt: &april},
+		{Outcome: OutcomeLost, LossReasonID: &competitor, ClosedAt: &april},
+		{Stage: StageClosed}, // Closed before outcomes existed, ignored
+	}
+	reasons := map[uint]LossReason{
+		price:      {ID: price, Code: "price", Label: "Price too high"},
+		competitor: {ID: competitor, Code: "competitor", Label: "Lost to a competitor"},
+	}
+
+	report := buildWinLossReport(sales, reasons)
+
+	assert.Equal(t, winLossCount{Won: 1, Lost: 3, WinRate: 25}, report.Total)
+	assert.Equal(t, []lossReasonRow{
+		{Code: "price", Label: "Price too high", Count: 2},
+		{Code: "competitor", Label: "Lost to a competitor", Count: 1},
+	}, report.ByReason)
+
+	assert.Len(t, report.ByOwner, 3)
+	assert.Equal(t, &alice, report.ByOwner[0].OwnerUserID)
+	assert.Equal(t, winLossCount{Won: 1, Lost: 1, WinRate: 50}, report.ByOwner[0].winLossCount)
+	assert.Nil(t, report.ByOwner[2].OwnerUserID, "Sales without an owner come last")
+
+	assert.Equal(t, []monthOutcomes{
+		{Month: "2025-03", winLossCount: winLossCount{Won: 1, Lost: 1, WinRate: 50}},
+		{Month: "2025-04", winLossCount: winLossCount{Lost: 2}},
+	}, report.ByMonth)
+}
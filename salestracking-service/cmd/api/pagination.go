@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size limits for list endpoints
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// sortField describes a column a list can be sorted by
+type sortField struct {
+	Column string
+	IsTime bool
+}
+
+// saleSortFields are the columns GET /sales may be sorted by
+var saleSortFields = map[string]sortField{
+	"id":         {Column: "id"},
+	"salename":   {Column: "salename"},
+	"stage":      {Column: "stage"},
+	"amount":     {Column: "amount"},
+	"created_at": {Column: "created_at", IsTime: true},
+	"updated_at": {Column: "updated_at", IsTime: true},
+}
+
+// deletedSortFields are the columns the trash is sorted by, most recently deleted first
+var deletedSortFields = map[string]sortField{
+	"deleted_at": {Column: "deleted_at", IsTime: true},
+}
+
+// listSort is a parsed "sort" query parameter, e.g. "-created_at"
+type listSort struct {
+	Key   string
+	Field sortField
+	Desc  bool
+}
+
+// pageCursor marks the last row of a page. It is handed to clients as an opaque string.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// parseSort parses a sort parameter against the allowed fields, falling back to def when empty
+func parseSort(param, def string, fields map[string]sortField) (listSort, error) {
+	if param == "" {
+		param = def
+	}
+
+	key := strings.TrimPrefix(param, "-")
+	field, ok := fields[key]
+	if !ok {
+		return listSort{}, fmt.Errorf("invalid sort field: %s", key)
+	}
+	return listSort{Key: param, Field: field, Desc: strings.HasPrefix(param, "-")}, nil
+}
+
+// OrderClause returns the ORDER BY clause for the sort, using id as a tie breaker
+func (s listSort) OrderClause() string {
+	dir := "ASC"
+	if s.Desc {
+		dir = "DESC"
+	}
+	if s.Field.Column == "id" {
+		return "id " + dir
+	}
+	return fmt.Sprintf("%s %s, id %s", s.Field.Column, dir, dir)
+}
+
+// AfterCursor returns the WHERE clause and arguments selecting rows after the cursor
+func (s listSort) AfterCursor(c pageCursor) (string, []interface{}, error) {
+	op := ">"
+	if s.Desc {
+		op = "<"
+	}
+	if s.Field.Column == "id" {
+		return "id " + op + " ?", []interface{}{c.ID}, nil
+	}
+
+	var value interface{} = c.Value
+	if s.Field.IsTime {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return "", nil, errors.New("invalid cursor")
+		}
+		value = t
+	}
+	return fmt.Sprintf("(%s, id) %s (?, ?)", s.Field.Column, op), []interface{}{value, c.ID}, nil
+}
+
+// encodeCursor turns a cursor into the opaque string sent to clients
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor string and checks that it was issued for the same sort
+func decodeCursor(raw string, sort listSort) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, errors.New("invalid cursor")
+	}
+	if c.Sort != sort.Key {
+		return c, errors.New("cursor does not match sort")
+	}
+	return c, nil
+}
+
+// parsePageSize reads the "limit" query parameter
+func parsePageSize(param string) (int, error) {
+	if param == "" {
+		return DefaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 {
+		return 0, errors.New("invalid limit")
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return limit, nil
+}
+
+// setPaginationLinks writes a Link header pointing to the first and, if there is one, the next page
+func setPaginationLinks(w http.ResponseWriter, r *http.Request, next string) {
+	query := r.URL.Query()
+	query.Del("cursor")
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(r, query))}
+
+	if next != "" {
+		query.Set("cursor", next)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, query)))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// pageURL builds the request path with the given query
+func pageURL(r *http.Request, query url.Values) string {
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// parseDateParam accepts either an RFC 3339 timestamp or a plain YYYY-MM-DD date
+func parseDateParam(param string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", param)
+}
+
+// likePattern wraps a search term for ILIKE, escaping the wildcard characters it contains
+func likePattern(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(term) + "%"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that sort parameters are parsed against the allowed fields
+func TestParseSort(t *testing.T) {
+	sort, err := parseSort("", "-created_at", saleSortFields)
+	assert.NoError(t, err)
+	assert.True(t, sort.Desc)
+	assert.Equal(t, "created_at DESC, id DESC", sort.OrderClause())
+
+	sort, err = parseSort("salename", "-created_at", saleSortFields)
+	assert.NoError(t, err)
+	assert.Equal(t, "salename ASC, id ASC", sort.OrderClause())
+
+	_, err = parseSort("password", "-created_at", saleSortFields)
+	assert.Error(t, err, "Unknown sort fields should be rejected")
+}
+
+// Test that a cursor survives encoding and is only accepted for the sort it was issued for
+func TestCursorRoundTrip(t *testing.T) {
+	sort, _ := parseSort("salename", "", saleSortFields)
+	raw := encodeCursor(pageCursor{Sort: "salename", Value: "Deal 42", ID: 7})
+
+	cursor, err := decodeCursor(raw, sort)
+	assert.NoError(t, err)
+	assert.Equal(t, "Deal 42", cursor.Value)
+	assert.Equal(t, uint(7), cursor.ID)
+
+	clause, args, err := sort.AfterCursor(cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, "(salename, id) > (?, ?)", clause)
+	assert.Equal(t, []interface{}{"Deal 42", uint(7)}, args)
+
+	other, _ := parseSort("-created_at", "", saleSortFields)
+	_, err = decodeCursor(raw, other)
+	assert.Error(t, err, "A cursor issued for another sort should be rejected")
+
+	_, err = decodeCursor("not-a-cursor", sort)
+	assert.Error(t, err)
+}
+
+// Test that the Link header carries the filters and the next cursor
+func TestSetPaginationLinks(t *testing.T) {
+	r := httptest.NewRequest("GET", "/sales?stage=Deal&cursor=old", nil)
+	w := httptest.NewRecorder()
+
+	setPaginationLinks(w, r, "abc")
+
+	assert.Equal(t, `</sales?stage=Deal>; rel="first", </sales?cursor=abc&stage=Deal>; rel="next"`, w.Header().Get("Link"))
+}
@@ -0,0 +1,120 @@
+package main
+
+import "sort"
+
+// pipelineSteps are the forward moves measured by the conversion report.
+// The last step counts sales that were closed as won.
+var pipelineSteps = [][2]string{
+	{StageNew, StageInCommunication},
+	{StageInCommunication, StageDeal},
+	{StageDeal, OutcomeWon},
+}
+
+// conversionRow is the share of sales that reached From and went on to reach To
+type conversionRow struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Entered   int     `json:"entered"`   // Sales that reached From
+	Converted int     `json:"converted"` // Of those, sales that reached To
+	Rate      float64 `json:"rate"`      // Converted as a percentage of Entered
+}
+
+// stageDurationRow summarises how long sales stayed in a stage before leaving it
+type stageDurationRow struct {
+	Stage       string  `json:"stage"`
+	Stays       int     `json:"stays"` // Completed stays measured
+	AverageDays float64 `json:"average_days"`
+	MedianDays  float64 `json:"median_days"`
+}
+
+// pipelineReport is the response of GET /reports/pipeline
+type pipelineReport struct {
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	Sales       int                `json:"sales"`
+	Stages      []forecastRow      `json:"stages"`
+	Conversions []conversionRow    `json:"conversions"`
+	TimeInStage []stageDurationRow `json:"time_in_stage"`
+}
+
+// buildPipelineReport aggregates the given sales and their stage history.
+// history must be ordered by creation time; entries of other sales are ignored.
+func buildPipelineReport(sales []Sale, history []SaleStageHistory) pipelineReport {
+	report := pipelineReport{Sales: len(sales), Stages: buildForecast(sales)}
+
+	bySale := map[uint][]SaleStageHistory{}
+	for _, h := range history {
+		bySale[h.SaleID] = append(bySale[h.SaleID], h)
+	}
+
+	// Work out which stages every sale reached and how long each completed stay lasted
+	reached := map[string]map[uint]bool{}
+	mark := func(stage string, saleID uint) {
+		if reached[stage] == nil {
+			reached[stage] = map[uint]bool{}
+		}
+		reached[stage][saleID] = true
+	}
+	stays := map[string][]float64{}
+
+	for _, sale := range sales {
+		// Every sale starts as New; sales migrated from the old flags have no history but do have a stage
+		mark(StageNew, sale.ID)
+		mark(sale.Stage, sale.ID)
+		if sale.Outcome == OutcomeWon {
+			mark(OutcomeWon, sale.ID)
+		}
+
+		entries := bySale[sale.ID]
+		for i, h := range entries {
+			mark(h.ToStage, sale.ID)
+			if i+1 < len(entries) {
+				days := entries[i+1].CreatedAt.Sub(h.CreatedAt).Hours() / 24
+				stays[h.ToStage] = append(stays[h.ToStage], days)
+			}
+		}
+	}
+
+	for _, step := range pipelineSteps {
+		row := conversionRow{From: step[0], To: step[1]}
+		for saleID := range reached[step[0]] {
+			row.Entered++
+			if reached[step[1]][saleID] {
+				row.Converted++
+			}
+		}
+		if row.Entered > 0 {
+			row.Rate = float64(row.Converted) * 100 / float64(row.Entered)
+		}
+		report.Conversions = append(report.Conversions, row)
+	}
+
+	for _, stage := range []string{StageNew, StageInCommunication, StageDeal} {
+		row := stageDurationRow{Stage: stage, Stays: len(stays[stage])}
+		row.AverageDays, row.MedianDays = averageAndMedian(stays[stage])
+		report.TimeInStage = append(report.TimeInStage, row)
+	}
+	return report
+}
+
+// averageAndMedian returns the mean and median of values, or zeros when there are none
+func averageAndMedian(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+
+	mid := len(sorted) / 2
+	median := sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sum / float64(len(sorted)), median
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test conversion rates and time-in-stage statistics computed from stage history
+func TestBuildPipelineReport(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	sales := []Sale{
+		{ID: 1, Stage: StageClosed, Outcome: OutcomeWon, Currency: "EUR", Amount: 1000},
+		{ID: 2, Stage: StageDeal, Currency: "EUR", Amount: 500},
+		{ID: 3, Stage: StageNew},
+	}
+	history := []SaleStageHistory{
+		{SaleID: 1, ToStage: StageNew, CreatedAt: start},
+		{SaleID: 1, ToStage: StageInCommunication, CreatedAt: start.Add(2 * day)},
+		{SaleID: 1, ToStage: StageDeal, CreatedAt: start.Add(5 * day)},
+		{SaleID: 1, ToStage: StageClosed, CreatedAt: start.Add(6 * day)},
+		{SaleID: 2, ToStage: StageNew, CreatedAt: start},
+		{SaleID: 2, ToStage: StageInCommunication, CreatedAt: start.Add(4 * day)},
+		{SaleID: 2, ToStage: StageDeal, CreatedAt: start.Add(5 * day)},
+		{SaleID: 3, ToStage: StageNew, CreatedAt: start},
+	}
+
+	report := buildPipelineReport(sales, history)
+
+	assert.Equal(t, 3, report.Sales)
+	assert.Equal(t, []conversionRow{
+		{From: StageNew, To: StageInCommunication, Entered: 3, Converted: 2, Rate: 200.0 / 3},
+		{From: StageInCommunication, To: StageDeal, Entered: 2, Converted: 2, Rate: 100},
+		{From: StageDeal, To: OutcomeWon, Entered: 2, Converted: 1, Rate: 50},
+	}, report.Conversions)
+
+	assert.Equal(t, []stageDurationRow{
+		{Stage: StageNew, Stays: 2, AverageDays: 3, MedianDays: 3},
+		{Stage: StageInCommunication, Stays: 2, AverageDays: 2, MedianDays: 2},
+		{Stage: StageDeal, Stays: 1, AverageDays: 1, MedianDays: 1},
+	}, report.TimeInStage, "Only completed stays are measured")
+}
+
+// Test the median of odd and even sized samples
+func TestAverageAndMedian(t *testing.T) {
+	avg, median := averageAndMedian([]float64{5, 1, 3})
+	assert.Equal(t, 3.0, avg)
+	assert.Equal(t, 3.0, median)
+
+	avg, median = averageAndMedian([]float64{1, 2, 3, 10})
+	assert.Equal(t, 4.0, avg)
+	assert.Equal(t, 2.5, median)
+
+	avg, median = averageAndMedian(nil)
+	assert.Equal(t, 0.0, avg)
+	assert.Equal(t, 0.0, median)
+}
@@ -32,16 +32,44 @@ func (app *Config) routes() http.Handler {
 	// Custom health check endpoint
 	mux.Get("/health", app.HealthCheckHandler) // Route to check if the service is healthy and running
 
-	// POST routes for creating resources
-	mux.Post("/insert-sale", app.InsertSaleHandler) // Route to insert a new sale record into the system
+	// Protected Routes (Require a JWT issued by user-service)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(AuthMiddleware) // Apply JWT authentication middleware to all routes within this group
 
-	// DELETE routes for removing resources
-	mux.Delete("/delete-sale", app.DeleteSaleHandler) // Route to delete an existing sale record from the system
+		// GET routes for reading sales
+		mux.Get("/sales", app.ListSalesHandler)                        // Route to list sales with filters, sorting and pagination
+		mux.Get("/sales/forecast", app.ForecastHandler)                // Route to get the weighted pipeline value per stage for a period
+		mux.Get("/sales/trash", app.DeletedSalesHandler)               // Route to list deleted sales
+		mux.Get("/sales/{id}", app.GetSaleHandler)                     // Route to get a single sale by its ID
+		mux.Get("/customers/{id}/sales", app.ListCustomerSalesHandler) // Route to list the sales of a customer
+		mux.Get("/users/{id}/sales", app.ListOwnerSalesHandler)        // Route to list the sales owned by a sales representative
 
-	// PUT routes for updating existing resources
-	mux.Put("/update-incommunication", app.UpdateInCommunicationHandler) // Route to update the "in communication" status of a sale
-	mux.Put("/update-deal", app.UpdateDealHandler)                       // Route to update the "deal" status of a sale
-	mux.Put("/update-closed", app.UpdateClosedHandler)                   // Route to update the "closed" status of a sale
+		// POST routes for creating resources
+		mux.Post("/insert-sale", app.InsertSaleHandler)         // Route to insert a new sale record into the system
+		mux.Post("/sales/{id}/restore", app.RestoreSaleHandler) // Route to take a sale back out of the trash
+
+		// DELETE routes for removing resources
+		mux.Delete("/delete-sale", app.DeleteSaleHandler) // Route to move an existing sale record to the trash
+
+		// PUT routes for updating existing resources
+		mux.Put("/update-incommunication", app.UpdateInCommunicationHandler) // Route to update the "in communication" status of a sale
+		mux.Put("/update-deal", app.UpdateDealHandler)                       // Route to update the "deal" status of a sale
+		mux.Put("/update-closed", app.UpdateClosedHandler)                   // Route to update the "closed" status of a sale
+		mux.Put("/sales/{id}", app.UpdateSaleHandler)                        // Route to update the customer, owner and deal value of a sale
+
+		// Stage transitions
+		mux.Post("/sales/{id}/transition", app.TransitionSaleHandler) // Route to move a sale to another pipeline stage
+		mux.Get("/sales/{id}/history", app.SaleHistoryHandler)        // Route to list the stage history of a sale
+
+		// Loss reason catalog
+		mux.Get("/loss-reasons", app.ListLossReasonsHandler)       // Route to list the loss reasons
+		mux.Post("/loss-reasons", app.CreateLossReasonHandler)     // Route to add a loss reason (Admin only)
+		mux.Put("/loss-reasons/{id}", app.UpdateLossReasonHandler) // Route to rename or (de)activate a loss reason (Admin only)
+
+		// Reports
+		mux.Get("/reports/win-loss", app.WinLossReportHandler)  // Route to count won and lost sales by reason, owner and month
+		mux.Get("/reports/pipeline", app.PipelineReportHandler) // Route to get stage counts, conversion rates and time spent in each stage
+	})
 
 	// Return the configured router to be used by the server
 	return mux
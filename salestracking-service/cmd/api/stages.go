@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Pipeline stages a sale moves through
+const (
+	StageNew             = "New"
+	StageInCommunication = "InCommunication"
+	StageDeal            = "Deal"
+	StageClosed          = "Closed"
+)
+
+// ErrIllegalStageTransition is returned when a sale is asked to move to a stage
+// that is not reachable from its current stage
+var ErrIllegalStageTransition = errors.New("illegal stage transition")
+
+// stageTransitions lists the stages each stage may move to.
+// The pipeline runs New -> InCommunication -> Deal -> Closed, a sale may step back
+// one stage while it is still open, and an open sale can be closed at any point.
+// Closed is terminal.
+var stageTransitions = map[string][]string{
+	StageNew:             {StageInCommunication, StageClosed},
+	StageInCommunication: {StageNew, StageDeal, StageClosed},
+	StageDeal:            {StageInCommunication, StageClosed},
+	StageClosed:          {},
+}
+
+// isValidStage reports whether stage is one of the known pipeline stages
+func isValidStage(stage string) bool {
+	_, ok := stageTransitions[stage]
+	return ok
+}
+
+// canTransition reports whether a sale in stage from may move to stage to
+func canTransition(from, to string) bool {
+	for _, next := range stageTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionSale moves the sale to the given stage and appends the note, if any.
+// The caller is responsible for saving the sale.
+func transitionSale(sale *Sale, to, note string) error {
+	if !canTransition(sale.Stage, to) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalStageTransition, sale.Stage, to)
+	}
+
+	sale.Stage = to
+
+	// Append the new note to the existing note (on a new line)
+	if note != "" {
+		sale.Note += "\n" + note
+	}
+
+	sale.UpdatedAt = time.Now()
+	return nil
+}
+
+// saveSaleTransition saves the sale and records the stage change in its history.
+// Both writes happen in one transaction so the history never disagrees with the sale.
+func (app *Config) saveSaleTransition(sale *Sale, from, actor, comment string) error {
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(sale).Error; err != nil {
+			return err
+		}
+
+		entry := SaleStageHistory{
+			SaleID:    sale.ID,
+			FromStage: from,
+			ToStage:   sale.Stage,
+			Actor:     actor,
+			Comment:   comment,
+			CreatedAt: sale.UpdatedAt,
+		}
+		return tx.Create(&entry).Error
+	})
+}
+
+// stageHistoryEntry is a history entry as returned by the history endpoint
+type stageHistoryEntry struct {
+	FromStage   string    `json:"from_stage"`
+	ToStage     string    `json:"to_stage"`
+	Actor       string    `json:"actor"`
+	Comment     string    `json:"comment,omitempty"`
+	ChangedAt   time.Time `json:"changed_at"`
+	DaysInStage float64   `json:"days_in_stage"` // Time spent in ToStage, up to now for the current stage
+}
+
+// buildStageHistory turns ordered history rows into response entries,
+// measuring each stage until the next change (or until now for the current stage)
+func buildStageHistory(history []SaleStageHistory, now time.Time) []stageHistoryEntry {
+	entries := make([]stageHistoryEntry, 0, len(history))
+	for i, h := range history {
+		left := now
+		if i+1 < len(history) {
+			left = history[i+1].CreatedAt
+		}
+
+		entries = append(entries, stageHistoryEntry{
+			FromStage:   h.FromStage,
+			ToStage:     h.ToStage,
+			Actor:       h.Actor,
+			Comment:     h.Comment,
+			ChangedAt:   h.CreatedAt,
+			DaysInStage: left.Sub(h.CreatedAt).Hours() / 24,
+		})
+	}
+	return entries
+}
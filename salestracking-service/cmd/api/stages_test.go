@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that the transition table only allows the documented moves
+func TestCanTransition(t *testing.T) {
+	assert.True(t, canTransition(StageNew, StageInCommunication), "New should move to InCommunication")
+	assert.True(t, canTransition(StageInCommunication, StageDeal), "InCommunication should move to Deal")
+	assert.True(t, canTransition(StageDeal, StageClosed), "Deal should move to Closed")
+	assert.True(t, canTransition(StageInCommunication, StageNew), "InCommunication should step back to New")
+	assert.True(t, canTransition(StageDeal, StageInCommunication), "Deal should step back to InCommunication")
+
+	assert.False(t, canTransition(StageNew, StageDeal), "New should not skip to Deal")
+	assert.False(t, canTransition(StageDeal, StageNew), "Deal should not step back two stages")
+	assert.False(t, canTransition(StageClosed, StageDeal), "Closed should be terminal")
+	assert.False(t, canTransition(StageNew, StageNew), "Staying in the same stage is not a transition")
+	assert.False(t, canTransition("Unknown", StageNew), "Unknown stages should not transition")
+}
+
+// Test that transitionSale updates the stage and note, and leaves the sale untouched on illegal moves
+func TestTransitionSale(t *testing.T) {
+	sale := Sale{Salename: "TestSale", Stage: StageNew, Note: "first"}
+
+	err := transitionSale(&sale, StageInCommunication, "second")
+	assert.NoError(t, err)
+	assert.Equal(t, StageInCommunication, sale.Stage)
+	assert.Equal(t, "first\nsecond", sale.Note)
+
+	err = transitionSale(&sale, StageClosed, "")
+	assert.NoError(t, err)
+
+	err = transitionSale(&sale, StageDeal, "reopen")
+	assert.True(t, errors.Is(err, ErrIllegalStageTransition), "Closed sales should not reopen")
+	assert.Equal(t, StageClosed, sale.Stage)
+	assert.Equal(t, "first\nsecond", sale.Note)
+}
+
+// Test that each history entry measures the time until the next stage change
+func TestBuildStageHistory(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	history := []SaleStageHistory{
+		{ToStage: StageNew, Actor: "alice", CreatedAt: start},
+		{FromStage: StageNew, ToStage: StageInCommunication, Actor: "alice", CreatedAt: start.Add(48 * time.Hour)},
+		{FromStage: StageInCommunication, ToStage: StageDeal, Actor: "bob", CreatedAt: start.Add(60 * time.Hour)},
+	}
+
+	entries := buildStageHistory(history, start.Add(96*time.Hour))
+
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 2.0, entries[0].DaysInStage)
+	assert.Equal(t, 0.5, entries[1].DaysInStage)
+	assert.Equal(t, 1.5, entries[2].DaysInStage, "The current stage should be measured until now")
+	assert.Equal(t, "bob", entries[2].Actor)
+}
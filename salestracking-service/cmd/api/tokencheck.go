@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrTokenCheckUnavailable is returned when user-service cannot tell whether a token is still valid
+var ErrTokenCheckUnavailable = errors.New("user-service token check unavailable")
+
+// tokenCheck is a cached answer of user-service about one token
+type tokenCheck struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// TokenChecker asks user-service whether access tokens are still accepted and caches the answers for TTL.
+// The signature only proves that user-service issued a token; logouts, revoked sessions, password
+// changes and deactivated users are only known to user-service. A revoked token is therefore
+// accepted here for at most TTL, instead of until it expires.
+type TokenChecker struct {
+	URL    string
+	TTL    time.Duration
+	Client *http.Client
+
+	mu     sync.Mutex
+	checks map[string]tokenCheck
+}
+
+// NewTokenChecker returns a checker calling the user-service token check at url
+func NewTokenChecker(url string, ttl time.Duration) *TokenChecker {
+	return &TokenChecker{
+		URL:    url,
+		TTL:    ttl,
+		Client: &http.Client{Timeout: 5 * time.Second},
+		checks: map[string]tokenCheck{},
+	}
+}
+
+// Active reports whether user-service still accepts the token. Failed checks are not cached.
+func (c *TokenChecker) Active(ctx context.Context, tokenString string) (bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	check, ok := c.checks[tokenString]
+	c.mu.Unlock()
+	if ok && now.Sub(check.checkedAt) < c.TTL {
+		return check.active, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrTokenCheckUnavailable, err)
+	}
+	resp.Body.Close()
+
+	var active bool
+	switch resp.StatusCode {
+	case http.StatusOK:
+		active = true
+	case http.StatusUnauthorized:
+		active = false
+	default:
+		return false, fmt.Errorf("%w: %s returned %d", ErrTokenCheckUnavailable, c.URL, resp.StatusCode)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Forget expired answers so tokens that are no longer used do not pile up
+	for cached, old := range c.checks {
+		if now.Sub(old.checkedAt) >= c.TTL {
+			delete(c.checks, cached)
+		}
+	}
+	c.checks[tokenString] = tokenCheck{active: active, checkedAt: now}
+	return active, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const trashPurgeInterval = time.Hour // How often sales deleted longer than TrashRetention ago are purged
+
+// purgeDeletedSales hard-deletes the sales deleted before the retention window together with their
+// stage history, and returns how many sales were purged
+func purgeDeletedSales(db *gorm.DB, now time.Time, retention time.Duration) (int64, error) {
+	var ids []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&Sale{}).Where("deleted_at < ?", now.Add(-retention)).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Where("sale_id IN ?", ids).Delete(&SaleStageHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Sale{}, ids).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// StartTrashPurging purges sales deleted longer than retention ago at startup and then once an hour
+func (app *Config) StartTrashPurging(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purged, err := purgeDeletedSales(app.DB, time.Now(), retention)
+			if err != nil {
+				log.Printf("⚠️ Failed to purge deleted sales: %v", err)
+			} else if purged > 0 {
+				fmt.Printf("🧹 Purged %d sales deleted more than %s ago\n", purged, retention)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// DeletedSalesHandler lists the sales in the trash
+// @Summary List deleted sales
+// @Description Lists the sales moved to the trash by /delete-sale, most recently deleted first, with the time they were deleted. Results are paginated with an opaque cursor; the next page is advertised in the Link header.
+// @Tags Sale
+// @Produce json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page's Link header"
+// @Success 200 {array} saleResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Database error"
+// @Router /sales/trash [get]
+func (app *Config) DeletedSalesHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	sort, _ := parseSort("", "-deleted_at", deletedSortFields)
+	limit, err := parsePageSize(params.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := app.DB.Unscoped().Model(&Sale{}).Where("deleted_at IS NOT NULL")
+
+	// Continue after the cursor, if one was given
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw, sort)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clause, args, err := sort.AfterCursor(cursor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(clause, args...)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var sales []Sale
+	if err := query.Order(sort.OrderClause()).Limit(limit + 1).Find(&sales).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(sales) > limit {
+		sales = sales[:limit]
+		last := sales[len(sales)-1]
+		next = encodeCursor(pageCursor{Sort: sort.Key, Value: last.DeletedAt.Time.Format(time.RFC3339Nano), ID: last.ID})
+	}
+
+	response := make([]saleResponse, 0, len(sales))
+	for _, sale := range sales {
+		response = append(response, newSaleResponse(sale))
+	}
+
+	// Send JSON response with pagination links
+	setPaginationLinks(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreSaleHandler takes a sale back out of the trash
+// @Summary Restore a deleted sale
+// @Description Takes a sale deleted by /delete-sale back out of the trash with its stage, deal values and stage history
+// @Tags Sale
+// @Produce json
+// @Param id path int true "Sale ID"
+// @Success 200 {object} saleResponse
+// @Failure 400 {string} string "Invalid sale ID"
+// @Failure 404 {string} string "Sale not found in trash"
+// @Failure 500 {string} string "Database error"
+// @Router /sales/{id}/restore [post]
+func (app *Config) RestoreSaleHandler(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sale ID", http.StatusBadRequest)
+		return
+	}
+
+	var sale Sale
+	if err := app.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", saleID).First(&sale).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Sale not found in trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.DB.Unscoped().Model(&sale).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	sale.DeletedAt = gorm.DeletedAt{}
+	log.Printf("Sale %s restored by %s", sale.Salename, requestActor(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSaleResponse(sale))
+}
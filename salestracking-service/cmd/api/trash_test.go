@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Test that sales deleted before the retention window are purged with their stage history
+func TestPurgeDeletedSales(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "sales" WHERE deleted_at < \$1`).
+		WithArgs(now.Add(-30 * 24 * time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec(`DELETE FROM "sale_stage_histories" WHERE sale_id IN \(\$1\)`).WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM "sales" WHERE "sales"."id" = \$1`).WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	purged, err := purgeDeletedSales(db, now, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	// Nothing to purge, nothing is deleted
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "sales" WHERE deleted_at < \$1`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	purged, err = purgeDeletedSales(db, now, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test that a sale in the trash is listed with the time it was deleted
+func TestDeletedSaleResponse(t *testing.T) {
+	deletedAt := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	response := newSaleResponse(Sale{ID: 1, Salename: "Acme", DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}})
+	require.NotNil(t, response.DeletedAt)
+	assert.Equal(t, deletedAt, *response.DeletedAt)
+	assert.Nil(t, newSaleResponse(Sale{ID: 2}).DeletedAt)
+}
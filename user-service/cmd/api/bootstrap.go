@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// BootstrapAdmin creates the first Admin of an empty system from USER_SERVICE_BOOTSTRAP_ADMIN_USERNAME,
+// USER_SERVICE_BOOTSTRAP_ADMIN_MAIL and USER_SERVICE_BOOTSTRAP_ADMIN_PASSWORD, so no Admin is ever
+// created by an anonymous request. Nothing happens once an Admin exists, and it reports whether
+// the Admin was created. The account is activated at once, its mail address is set by the operator.
+func (app *Config) BootstrapAdmin(username, mailAddress, password string) (bool, error) {
+	if username == "" {
+		return false, nil
+	}
+	if mailAddress == "" || password == "" {
+		return false, errors.New("USER_SERVICE_BOOTSTRAP_ADMIN_MAIL and USER_SERVICE_BOOTSTRAP_ADMIN_PASSWORD are required with a bootstrap Admin")
+	}
+	if err := app.validateNewPassword(password, 0, "", username, mailAddress); err != nil {
+		return false, fmt.Errorf("bootstrap Admin password: %w", err)
+	}
+	hashedPassword, err := app.HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		// Instances starting at the same time must not both see no Admin, the lock holds until the commit
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+				return err
+			}
+		}
+
+		var admins int64
+		if err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return nil
+		}
+
+		var existing int64
+		if err := tx.Unscoped().Model(&User{}).Where("username = ? OR mail_address = ?", username, mailAddress).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("bootstrap Admin %s: username or mail address is taken by another user", username)
+		}
+
+		admin := User{
+			Username:    username,
+			MailAddress: mailAddress,
+			Password:    hashedPassword,
+			Role:        RoleAdmin,
+			Activated:   true,
+		}
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
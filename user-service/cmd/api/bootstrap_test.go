@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the bootstrap Admin is created once, activated, and only while no Admin exists
+func TestBootstrapAdmin(t *testing.T) {
+	app := newTestApp(t)
+
+	created, err := app.BootstrapAdmin("", "", "")
+	require.NoError(t, err)
+	assert.False(t, created, "Nothing is created without a username")
+
+	_, err = app.BootstrapAdmin("root", "root@example.com", "")
+	assert.Error(t, err, "The password is required")
+	_, err = app.BootstrapAdmin("root", "root@example.com", "short")
+	assert.Error(t, err, "The password must follow the policy")
+
+	created, err = app.BootstrapAdmin("root", "root@example.com", "Password123")
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	var admin User
+	require.NoError(t, app.DB.Where("username = ?", "root").First(&admin).Error)
+	assert.Equal(t, RoleAdmin, admin.Role)
+	assert.True(t, admin.Activated)
+	assert.False(t, admin.VerificationPending)
+	assert.True(t, app.CheckPassword(admin.Password, "Password123"))
+
+	// Restarts with the same settings leave the existing Admin alone
+	created, err = app.BootstrapAdmin("other", "other@example.com", "Password123")
+	require.NoError(t, err)
+	assert.False(t, created)
+	var admins int64
+	require.NoError(t, app.DB.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error)
+	assert.Equal(t, int64(1), admins)
+}
+
+// Test that the bootstrap Admin does not take over the name of another user
+func TestBootstrapAdminNameTaken(t *testing.T) {
+	app := newTestApp(t)
+	createTestUser(t, app, "root", "Password123", RoleSalesRep)
+
+	_, err := app.BootstrapAdmin("root", "root@example.com", "Password123")
+	assert.Error(t, err)
+}
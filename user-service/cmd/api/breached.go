@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Length of the hash prefix that groups the breached password hashes into ranges
+const breachedPrefixLength = 5
+
+// BreachedPasswords is a list of passwords known from data breaches. It is loaded from a file in
+// the format of the Pwned Passwords downloads: one upper- or lower-case hex SHA-1 hash per line,
+// optionally followed by ":<count>". Empty lines and lines starting with # are skipped.
+// Like the Pwned Passwords range API the hashes are grouped by their first five hex digits, so a
+// lookup only searches the suffixes in the range of the password's hash.
+type BreachedPasswords struct {
+	ranges map[string][]string // Hash prefix to the sorted suffixes of the hashes in its range
+	count  int
+}
+
+// LoadBreachedPasswords reads the breached password file at path. Without a path it returns nil,
+// which contains no passwords.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := &BreachedPasswords{ranges: map[string][]string{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(entry, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		prefix := hash[:breachedPrefixLength]
+		breached.ranges[prefix] = append(breached.ranges[prefix], hash[breachedPrefixLength:])
+		breached.count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range breached.ranges {
+		sort.Strings(suffixes)
+	}
+	return breached, nil
+}
+
+// Contains reports whether the password is in the list
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes := b.ranges[hash[:breachedPrefixLength]]
+	i := sort.SearchStrings(suffixes, hash[breachedPrefixLength:])
+	return i < len(suffixes) && suffixes[i] == hash[breachedPrefixLength:]
+}
+
+// Len returns the number of hashes in the list
+func (b *BreachedPasswords) Len() int {
+	if b == nil {
+		return 0
+	}
+	return b.count
+}
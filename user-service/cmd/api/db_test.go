@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestApp returns a Config backed by a fresh in-memory SQLite database
+func newTestApp(t *testing.T) *Config {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	// Every connection to ":memory:" opens its own database, keep a single one
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	require.NoError(t, db.AutoMigrate(&User{}, &Session{}, &RefreshToken{}, &RecoveryCode{}, &PasswordResetToken{}, &Invitation{}, &LoginEvent{}, &SecurityEvent{}, &PasswordHistory{}))
+
+	// Ed25519 keys are generated much faster than RSA keys
+	keys, err := NewKeyManager("", AlgEdDSA, AccessTokenTTL)
+	require.NoError(t, err)
+	// Emails are written to a file the tests can read
+	mailer := &FileMailer{Path: filepath.Join(t.TempDir(), "mail.txt"), From: "no-reply@example.com"}
+	verificationKey, err := LoadVerificationKey("")
+	require.NoError(t, err)
+	return &Config{DB: db, Keys: keys, LoginThrottle: NewLoginThrottle(), Mailer: mailer, VerificationKey: verificationKey, Hasher: BcryptHasher{Cost: bcrypt.MinCost}}
+}
+
+// createTestUser stores an activated user with the given password and role
+func createTestUser(t *testing.T, app *Config, username, password, role string) User {
+	hashedPassword, err := app.HashPassword(password)
+	require.NoError(t, err)
+
+	user := User{
+		Username:    username,
+		MailAddress: username + "@example.com",
+		Password:    hashedPassword,
+		Role:        role,
+		Activated:   true,
+	}
+	require.NoError(t, app.DB.Create(&user).Error)
+	return user
+}
+
+// bearer opens a session for the user and returns the Authorization header value of its access token
+func bearer(t *testing.T, app *Config, user User) string {
+	pair, err := app.startSession(user, sessionClient{DeviceLabel: "Test device"})
+	require.NoError(t, err)
+	return "Bearer " + pair.AccessToken
+}
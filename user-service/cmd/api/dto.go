@@ -0,0 +1,153 @@
+package main
+
+import "time"
+
+// Request and response types of the API. Handlers decode requests into and encode responses from
+// these types only, never from the GORM models, so a column added to a model cannot be set by a
+// client or leak into a response by accident. The field names match the JSON keys clients already use.
+
+// registerRequest is the body of POST /register
+type registerRequest struct {
+	Username    string
+	MailAddress string
+	Password    string
+	Role        string // Defaults to Sales Representative
+}
+
+// loginRequest is the body of POST /login
+type loginRequest struct {
+	Username string
+	Password string
+}
+
+// userResponse is the JSON representation of a user returned by the read endpoints. It leaves out the
+// password hash, the 2FA secret and the bookkeeping of tokens, verification emails and failed logins.
+type userResponse struct {
+	ID                  uint
+	Username            string
+	MailAddress         string
+	Role                string
+	Activated           bool
+	LoginStatus         bool
+	VerificationPending bool
+	TOTPEnabled         bool
+	LockedUntil         *time.Time `json:",omitempty"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           *time.Time `json:",omitempty"` // Only set for users in the trash
+}
+
+// newUserResponse copies the public fields of a user into its response form
+func newUserResponse(user User) userResponse {
+	response := userResponse{
+		ID:                  user.ID,
+		Username:            user.Username,
+		MailAddress:         user.MailAddress,
+		Role:                user.Role,
+		Activated:           user.Activated,
+		LoginStatus:         user.LoginStatus,
+		VerificationPending: user.VerificationPending,
+		TOTPEnabled:         user.TOTPEnabled,
+		LockedUntil:         user.LockedUntil,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
+	}
+	if user.DeletedAt.Valid {
+		response.DeletedAt = &user.DeletedAt.Time
+	}
+	return response
+}
+
+// invitationResponse is the JSON representation of an invitation, without the token hash
+type invitationResponse struct {
+	ID          uint
+	MailAddress string
+	Role        string
+	InvitedBy   string
+	ExpiresAt   time.Time
+	AcceptedAt  *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+}
+
+// newInvitationResponse copies the public fields of an invitation into its response form
+func newInvitationResponse(invitation Invitation) invitationResponse {
+	return invitationResponse{
+		ID:          invitation.ID,
+		MailAddress: invitation.MailAddress,
+		Role:        invitation.Role,
+		InvitedBy:   invitation.InvitedBy,
+		ExpiresAt:   invitation.ExpiresAt,
+		AcceptedAt:  invitation.AcceptedAt,
+		RevokedAt:   invitation.RevokedAt,
+		CreatedAt:   invitation.CreatedAt,
+	}
+}
+
+// securityEventResponse is the JSON representation of a security event
+type securityEventResponse struct {
+	ID        uint
+	Type      string
+	Username  string
+	IPAddress string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// newSecurityEventResponse copies a security event into its response form
+func newSecurityEventResponse(event SecurityEvent) securityEventResponse {
+	return securityEventResponse{
+		ID:        event.ID,
+		Type:      event.Type,
+		Username:  event.Username,
+		IPAddress: event.IPAddress,
+		Detail:    event.Detail,
+		CreatedAt: event.CreatedAt,
+	}
+}
+
+// loginEventResponse is the JSON representation of an entry of the login history
+type loginEventResponse struct {
+	ID        uint
+	Success   bool
+	Reason    string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// newLoginEventResponse copies a login event into its response form
+func newLoginEventResponse(event LoginEvent) loginEventResponse {
+	return loginEventResponse{
+		ID:        event.ID,
+		Success:   event.Success,
+		Reason:    event.Reason,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		CreatedAt: event.CreatedAt,
+	}
+}
+
+// sessionResponse is the JSON representation of a session, without the session ID carried by its tokens
+type sessionResponse struct {
+	ID          uint
+	DeviceLabel string
+	IPAddress   string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	Current     bool // The session of the token the list was requested with
+}
+
+// newSessionResponse copies a session into its response form
+func newSessionResponse(session Session, currentSessionID string) sessionResponse {
+	return sessionResponse{
+		ID:          session.ID,
+		DeviceLabel: session.DeviceLabel,
+		IPAddress:   session.IPAddress,
+		UserAgent:   session.UserAgent,
+		CreatedAt:   session.CreatedAt,
+		LastSeenAt:  session.LastSeenAt,
+		Current:     session.SessionID == currentSessionID,
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secretKeys are JSON keys no response may contain, compared case-insensitively
+var secretKeys = []string{"password", "tokenhash", "totpsecret", "codehash", "tokenversion"}
+
+// assertNoSecrets fails if the JSON body has a secret key at any depth or contains a bcrypt hash
+func assertNoSecrets(t *testing.T, body []byte) {
+	t.Helper()
+	assert.NotContains(t, string(body), "$2a$", "No bcrypt hash may be returned")
+
+	var value interface{}
+	require.NoError(t, json.Unmarshal(body, &value), string(body))
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				for _, secret := range secretKeys {
+					assert.NotEqual(t, secret, strings.ToLower(key), "Secret field in %s", body)
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(value)
+}
+
+// Test that no endpoint returning users, invitations or events serializes a password or another secret
+func TestResponsesContainNoSecrets(t *testing.T) {
+	enableSelfRegistration(t)
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	require.NoError(t, app.DB.Model(&admin).Update("totp_secret", "JBSWY3DPEHPK3PXP").Error)
+	token := bearer(t, app, admin)
+
+	rec := postJSON(router, "/register", "", `{"username":"rep","mailAddress":"rep@example.com","password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertNoSecrets(t, rec.Body.Bytes())
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"new@example.com"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertNoSecrets(t, rec.Body.Bytes())
+	postJSON(router, "/login", "", `{"username":"admin","password":"wrong"}`)
+
+	for _, path := range []string{fmt.Sprintf("/user?id=%d", admin.ID), "/users", "/invitations", "/security-events"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		assert.NotContains(t, rec.Body.String(), "JBSWY3DPEHPK3PXP", path)
+		assertNoSecrets(t, rec.Body.Bytes())
+	}
+}
+
+// Test that registration only takes over the fields of the request
+func TestRegisterIgnoresAccountState(t *testing.T) {
+	enableSelfRegistration(t)
+	app := newTestApp(t)
+	router := app.routes()
+
+	rec := postJSON(router, "/register", "", `{"username":"rep","mailAddress":"rep@example.com","password":"Password123","Activated":true,"VerificationPending":false,"TOTPEnabled":true,"ID":42}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var stored User
+	require.NoError(t, app.DB.Where("username = ?", "rep").First(&stored).Error)
+	assert.NotEqual(t, uint(42), stored.ID)
+	assert.False(t, stored.Activated)
+	assert.True(t, stored.VerificationPending)
+	assert.False(t, stored.TOTPEnabled)
+}
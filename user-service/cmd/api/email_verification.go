@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for email verification
+const (
+	EventVerificationSent = "verification_sent" // Verification link emailed
+	EventEmailVerified    = "email_verified"    // Mail address confirmed, the account is activated
+
+	EventEmailChangeRequested = "email_change_requested" // Link to confirm a new mail address emailed to it
+	EventEmailChanged         = "email_changed"          // New mail address confirmed and applied
+)
+
+const (
+	verificationResendInterval = time.Minute // Minimum time between two verification emails to the same user
+	verificationKeySize        = 32          // Bytes of the key generated when no secret is configured
+
+	VerificationSent            = "If the address belongs to an account waiting for verification, a new verification link has been sent to it"
+	ErrEmailNotVerified         = "Email address is not verified"
+	ErrInvalidVerificationToken = "Invalid verification link"
+	ErrExpiredVerificationToken = "Verification link has expired, request a new one with /verify-email/resend"
+	ErrEmailInUse               = "Email address is already in use"
+)
+
+var (
+	errVerificationTokenInvalid = errors.New("invalid email verification token")
+	errVerificationTokenExpired = errors.New("expired email verification token")
+)
+
+// verificationClaims are signed into an email verification link. The link only works for the
+// mail address it was sent to, changing the address makes earlier links useless.
+type verificationClaims struct {
+	UserID      uint   `json:"sub"`
+	MailAddress string `json:"mail"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+// LoadVerificationKey returns the key verification links are signed with. Without a secret a random
+// key is generated, links sent before a restart then stop working and have to be requested again.
+func LoadVerificationKey(secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	key := make([]byte, verificationKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// signVerificationToken returns the claims followed by their HMAC-SHA256, both base64url encoded
+func signVerificationToken(key []byte, claims verificationClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseVerificationToken checks the signature and the expiry of a verification token
+func parseVerificationToken(key []byte, token string, now time.Time) (verificationClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	var claims verificationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return verificationClaims{}, errVerificationTokenInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return verificationClaims{}, errVerificationTokenExpired
+	}
+	return claims, nil
+}
+
+// sendVerificationEmail emails a verification link to a pending user and remembers when it was sent.
+// A failed delivery is only logged, the user can ask for another link.
+func (app *Config) sendVerificationEmail(user User, now time.Time) error {
+	token, err := signVerificationToken(app.VerificationKey, verificationClaims{
+		UserID:      user.ID,
+		MailAddress: user.MailAddress,
+		ExpiresAt:   now.Add(EmailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.Model(&User{}).Where("id = ?", user.ID).Update("verification_sent_at", now).Error; err != nil {
+		return err
+	}
+
+	msg := Message{
+		To:      user.MailAddress,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account was registered with this email address. Open the link below within %s to verify the address and activate the account:\n\n%s\n\n"+
+			"If you did not register, ignore this email. The account stays inactive.\n", user.Username, EmailVerificationTTL, link),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to send verification email to %s: %v", user.Username, err)
+	}
+	return nil
+}
+
+// sendEmailChangeEmail emails a link confirming the pending mail address of a user to that address.
+// Like verification emails, a failed delivery is only logged and the change can be requested again.
+func (app *Config) sendEmailChangeEmail(user User, now time.Time) error {
+	token, err := signVerificationToken(app.VerificationKey, verificationClaims{
+		UserID:      user.ID,
+		MailAddress: user.PendingMailAddress,
+		ExpiresAt:   now.Add(EmailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		To:      user.PendingMailAddress,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nThis email address was entered as the new address of your account. Open the link below within %s to confirm it:\n\n%s\n\n"+
+			"If you did not ask for this, ignore this email. The account keeps its current address.\n", user.Username, EmailVerificationTTL, link),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to send email change confirmation to %s: %v", user.Username, err)
+	}
+	return nil
+}
+
+// confirmEmailChange applies the pending mail address of a user whose confirmation link was opened.
+// It bumps the token version, so access tokens issued before the change stop working and the
+// user gets new ones from a refresh or login.
+func (app *Config) confirmEmailChange(w http.ResponseWriter, r *http.Request, user User) {
+	var taken int64
+	err := app.DB.Unscoped().Model(&User{}).Where("mail_address = ? AND id <> ?", user.PendingMailAddress, user.ID).Count(&taken).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if taken > 0 {
+		http.Error(w, ErrEmailInUse, http.StatusConflict)
+		return
+	}
+
+	// Only the address the link was sent to is applied, a later request replaces it
+	result := app.DB.Model(&User{}).Where("id = ? AND pending_mail_address = ?", user.ID, user.PendingMailAddress).Updates(map[string]interface{}{
+		"mail_address":         user.PendingMailAddress,
+		"pending_mail_address": "",
+		"token_version":        gorm.Expr("token_version + 1"),
+	})
+	if result.Error != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+		return
+	}
+
+	app.recordSecurityEvent(EventEmailChanged, user.Username, clientIP(r), user.MailAddress+" -> "+user.PendingMailAddress)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address changed"})
+}
+
+// VerifyEmailHandler activates an account with the link emailed at registration
+// @Summary Verify email address
+// @Description Confirms the mail address of a pending account and activates it. Opening the link again answers that the address is already verified.
+// @Description Links sent by /update-email apply the new mail address instead.
+// @Tags Users
+// @Produce json
+// @Param token query string true "Token of the emailed verification link"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid or expired verification link"
+// @Failure 409 {string} string "Email address is already in use"
+// @Failure 500 {string} string "Database error"
+// @Router /verify-email [get]
+func (app *Config) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := parseVerificationToken(app.VerificationKey, r.URL.Query().Get("token"), time.Now())
+	if errors.Is(err, errVerificationTokenExpired) {
+		http.Error(w, ErrExpiredVerificationToken, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Links sent by /update-email confirm the pending address instead
+	if user.PendingMailAddress != "" && user.PendingMailAddress == claims.MailAddress {
+		app.confirmEmailChange(w, r, user)
+		return
+	}
+	if user.MailAddress != claims.MailAddress {
+		http.Error(w, ErrInvalidVerificationToken, http.StatusBadRequest)
+		return
+	}
+
+	// Only a pending account is activated, an old link cannot undo a later deactivation by an Admin
+	result := app.DB.Model(&User{}).Where("id = ? AND verification_pending = ?", user.ID, true).Updates(map[string]interface{}{
+		"verification_pending": false,
+		"activated":            true,
+	})
+	if result.Error != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Email address verified, the account is activated"
+	if result.RowsAffected == 0 {
+		message = "Email address already verified"
+	} else {
+		app.recordSecurityEvent(EventEmailVerified, user.Username, clientIP(r), "")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// ResendVerificationHandler emails a new verification link
+// @Summary Resend the verification link
+// @Description Emails a new verification link to the given address if it belongs to an account waiting for verification.
+// @Description The answer is the same whether or not the address is known, so it does not reveal which addresses have accounts.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{MailAddress string `json:"mailAddress"`} true "Mail address of the account"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body or empty mail address"
+// @Failure 500 {string} string "Database error"
+// @Router /verify-email/resend [post]
+func (app *Config) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MailAddress string `json:"mailAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestData.MailAddress == "" {
+		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	respond := func() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": VerificationSent})
+	}
+
+	var user User
+	if err := app.DB.Where("mail_address = ?", requestData.MailAddress).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respond()
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Do not flood the mailbox when the form is sent repeatedly
+	now := time.Now()
+	if !user.VerificationPending || (user.VerificationSentAt != nil && now.Sub(*user.VerificationSentAt) < verificationResendInterval) {
+		respond()
+		return
+	}
+
+	if err := app.sendVerificationEmail(user, now); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventVerificationSent, user.Username, clientIP(r), "resend")
+	respond()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyEmail opens the verification link with the token
+func verifyEmail(router http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/verify-email?token="+token, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test that a registered account can only log in after opening the emailed link
+func TestRegistrationRequiresVerification(t *testing.T) {
+	enableSelfRegistration(t)
+	app := newTestApp(t)
+	router := app.routes()
+
+	rec := postJSON(router, "/register", "", `{"username":"rep","mailAddress":"rep@example.com","password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := decodeBody(t, rec)
+	assert.Equal(t, true, body["verification_required"])
+	assert.Nil(t, body["token"], "No tokens before the address is verified")
+
+	rec = postJSON(router, "/login", "", `{"username":"rep","password":"Password123"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrEmailNotVerified)
+
+	mail := sentMail(t, app)
+	assert.Contains(t, mail, "To: rep@example.com")
+	match := linkTokenPattern.FindStringSubmatch(mail)
+	require.Len(t, match, 2)
+	token := match[1]
+
+	// A tampered link is refused
+	assert.Equal(t, http.StatusBadRequest, verifyEmail(router, token+"x").Code)
+
+	rec = verifyEmail(router, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	login(t, router, "rep", "Password123")
+
+	rec = verifyEmail(router, token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already verified")
+
+	// Verified accounts get no further links
+	postJSON(router, "/verify-email/resend", "", `{"mailAddress":"rep@example.com"}`)
+	assert.Equal(t, 1, strings.Count(sentMail(t, app), "Subject:"))
+}
+
+// Test that expired links, links to another address and resending are handled
+func TestVerificationLinkExpires(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	require.NoError(t, app.DB.Model(&rep).Updates(map[string]interface{}{"activated": false, "verification_pending": true}).Error)
+
+	expired, err := signVerificationToken(app.VerificationKey, verificationClaims{UserID: rep.ID, MailAddress: rep.MailAddress, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, err)
+	rec := verifyEmail(router, expired)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "expired")
+
+	otherAddress, err := signVerificationToken(app.VerificationKey, verificationClaims{UserID: rep.ID, MailAddress: "old@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, verifyEmail(router, otherAddress).Code)
+
+	// Unknown addresses get the same answer and no email, a second request at once sends nothing
+	unknown := postJSON(router, "/verify-email/resend", "", `{"mailAddress":"nobody@example.com"}`)
+	require.Equal(t, http.StatusOK, unknown.Code)
+	known := postJSON(router, "/verify-email/resend", "", `{"mailAddress":"rep@example.com"}`)
+	require.Equal(t, http.StatusOK, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+	postJSON(router, "/verify-email/resend", "", `{"mailAddress":"rep@example.com"}`)
+	mail := sentMail(t, app)
+	assert.Equal(t, 1, strings.Count(mail, "Subject:"))
+
+	match := linkTokenPattern.FindStringSubmatch(mail)
+	require.Len(t, match, 2)
+	require.Equal(t, http.StatusOK, verifyEmail(router, match[1]).Code)
+	login(t, router, "rep", "Password123")
+}
+
+// updateEmail sends PUT /update-email with the token
+func updateEmail(router http.Handler, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/update-email", strings.NewReader(body))
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test that a new address needs the current password and only applies once the link sent to it is opened
+func TestUpdateEmailRequiresConfirmation(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	createTestUser(t, app, "other", "Password123", RoleSalesRep)
+	token := bearer(t, app, rep)
+
+	rec := updateEmail(router, token, `{"username":"rep","new_email":"new@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "The current password is required")
+	rec = updateEmail(router, token, `{"username":"rep","new_email":"new@example.com","current_password":"wrong"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = updateEmail(router, token, `{"username":"rep","new_email":"other@example.com","current_password":"Password123"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Empty(t, sentMail(t, app))
+
+	rec = updateEmail(router, token, `{"username":"rep","new_email":"new@example.com","current_password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Nothing changes before the link is opened
+	require.NoError(t, app.DB.First(&rep, rep.ID).Error)
+	assert.Equal(t, "rep@example.com", rep.MailAddress)
+	assert.Equal(t, "new@example.com", rep.PendingMailAddress)
+	assert.Equal(t, http.StatusOK, getUser(router, token, rep))
+
+	mail := sentMail(t, app)
+	assert.Contains(t, mail, "To: new@example.com")
+	match := linkTokenPattern.FindStringSubmatch(mail)
+	require.Len(t, match, 2)
+
+	// /update-user cannot skip the confirmation
+	req := httptest.NewRequest(http.MethodPut, "/update-user", strings.NewReader(`{"username":"rep","email":"sneaky@example.com"}`))
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = verifyEmail(router, match[1])
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, app.DB.First(&rep, rep.ID).Error)
+	assert.Equal(t, "new@example.com", rep.MailAddress)
+	assert.Empty(t, rep.PendingMailAddress)
+	assert.Equal(t, 1, rep.TokenVersion)
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, token, rep), "Tokens issued before the change stop working")
+
+	// Opening the link again changes nothing more
+	rec = verifyEmail(router, match[1])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already verified")
+	require.NoError(t, app.DB.First(&rep, rep.ID).Error)
+	assert.Equal(t, 1, rep.TokenVersion)
+}
+
+// Test that an address taken while the change was pending is not applied
+func TestUpdateEmailTakenBeforeConfirmation(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	other := createTestUser(t, app, "other", "Password123", RoleSalesRep)
+
+	rec := updateEmail(router, bearer(t, app, rep), `{"username":"rep","new_email":"new@example.com","current_password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	match := linkTokenPattern.FindStringSubmatch(sentMail(t, app))
+	require.Len(t, match, 2)
+
+	require.NoError(t, app.DB.Model(&other).Update("mail_address", "new@example.com").Error)
+	assert.Equal(t, http.StatusConflict, verifyEmail(router, match[1]).Code)
+	require.NoError(t, app.DB.First(&rep, rep.ID).Error)
+	assert.Equal(t, "rep@example.com", rep.MailAddress)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Load environment variables
@@ -14,9 +17,128 @@ var (
 	DBName      = os.Getenv("USER_POSTGRES_DB_NAME")
 	ServicePort = os.Getenv("USER_SERVICE_PORT")
 	ServiceName = os.Getenv("USER_SERVICE_NAME")
-	JWTSecret   = os.Getenv("USER_SERVICE_JWT_SECRET")
+
+	// Token lifetimes, optional
+	AccessTokenTTL  = durationEnv("USER_SERVICE_ACCESS_TOKEN_TTL", 15*time.Minute)
+	RefreshTokenTTL = durationEnv("USER_SERVICE_REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	// Access token signing keys, optional. Without a key directory keys only live in memory.
+	JWTAlgorithm   = stringEnv("USER_SERVICE_JWT_ALG", AlgRS256)
+	JWTKeyDir      = os.Getenv("USER_SERVICE_JWT_KEY_DIR")
+	JWTKeyRotation = durationEnv("USER_SERVICE_JWT_KEY_ROTATION", 30*24*time.Hour)
+
+	// Login throttling, optional
+	MaxFailedLogins = intEnv("USER_SERVICE_MAX_FAILED_LOGINS", 5)
+	LockoutDuration = durationEnv("USER_SERVICE_LOCKOUT_DURATION", 15*time.Minute)
+
+	// Two-factor authentication, optional. Set the roles to an empty value to make 2FA optional for everybody.
+	TwoFactorRequiredRoles = listEnv("USER_SERVICE_2FA_REQUIRED_ROLES", []string{RoleAdmin})
+	TOTPIssuer             = stringEnv("USER_SERVICE_TOTP_ISSUER", "Mindset CRM")
+
+	// Outgoing email, optional. The file mailer writes to USER_SERVICE_MAIL_FILE, or stdout when it is unset.
+	MailerKind   = stringEnv("USER_SERVICE_MAILER", MailerFile)
+	MailFile     = os.Getenv("USER_SERVICE_MAIL_FILE")
+	MailFrom     = stringEnv("USER_SERVICE_MAIL_FROM", "no-reply@localhost")
+	SMTPHost     = os.Getenv("USER_SERVICE_SMTP_HOST")
+	SMTPPort     = stringEnv("USER_SERVICE_SMTP_PORT", "587")
+	SMTPUsername = os.Getenv("USER_SERVICE_SMTP_USERNAME")
+	SMTPPassword = os.Getenv("USER_SERVICE_SMTP_PASSWORD")
+
+	// Password reset, optional. The emailed link is PasswordResetURL with the token in its "token" query parameter.
+	PasswordResetURL = stringEnv("USER_SERVICE_PASSWORD_RESET_URL", "http://localhost:3000/reset-password")
+	PasswordResetTTL = durationEnv("USER_SERVICE_PASSWORD_RESET_TTL", time.Hour)
+
+	// Email verification, optional. The emailed link is EmailVerificationURL with the token in its "token" query parameter.
+	// Without a secret links are signed with a random key and stop working when the service restarts.
+	EmailVerificationURL    = stringEnv("USER_SERVICE_EMAIL_VERIFICATION_URL", "http://localhost:8080/verify-email")
+	EmailVerificationTTL    = durationEnv("USER_SERVICE_EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	EmailVerificationSecret = os.Getenv("USER_SERVICE_EMAIL_VERIFICATION_SECRET")
+
+	// Registration, optional. Self-registration is off by default: /register only accepts Admins and everybody else is invited.
+	// The emailed invitation link is InvitationURL with the token in its "token" query parameter.
+	SelfRegistration = boolEnv("USER_SERVICE_SELF_REGISTRATION", false)
+	InvitationURL    = stringEnv("USER_SERVICE_INVITATION_URL", "http://localhost:3000/accept-invitation")
+	InvitationTTL    = durationEnv("USER_SERVICE_INVITATION_TTL", 7*24*time.Hour)
+
+	// First Admin, optional. Created at startup while no Admin exists, later Admins are registered or invited by Admins.
+	BootstrapAdminUsername = os.Getenv("USER_SERVICE_BOOTSTRAP_ADMIN_USERNAME")
+	BootstrapAdminMail     = os.Getenv("USER_SERVICE_BOOTSTRAP_ADMIN_MAIL")
+	BootstrapAdminPassword = os.Getenv("USER_SERVICE_BOOTSTRAP_ADMIN_PASSWORD")
+
+	// Login history, optional. Older login events are deleted.
+	LoginHistoryRetention = durationEnv("USER_SERVICE_LOGIN_HISTORY_RETENTION", 90*24*time.Hour)
+
+	// Trash, optional. Deleted users can be restored until they are purged after this time.
+	TrashRetention = durationEnv("USER_SERVICE_TRASH_RETENTION", 30*24*time.Hour)
+
+	// Password hashing, optional. Existing hashes of the other algorithm or with other costs are
+	// replaced at the next successful login. Argon2 memory is in KiB.
+	PasswordHasherKind = stringEnv("USER_SERVICE_PASSWORD_HASHER", HasherArgon2id)
+	BcryptCost         = intEnv("USER_SERVICE_BCRYPT_COST", 10)
+	Argon2Memory       = intEnv("USER_SERVICE_ARGON2_MEMORY", 64*1024)
+	Argon2Iterations   = intEnv("USER_SERVICE_ARGON2_ITERATIONS", 3)
+	Argon2Parallelism  = intEnv("USER_SERVICE_ARGON2_PARALLELISM", 2)
+
+	// Password policy, optional. Classes are letter, upper, lower, digit and symbol. With a history of N
+	// passwords, new passwords must differ from the last N passwords of the account; 0 turns this off.
+	// The breached password file lists SHA-1 hashes of passwords that are refused, see breached.go.
+	PasswordMinLength       = intEnv("USER_SERVICE_PASSWORD_MIN_LENGTH", 8)
+	PasswordRequiredClasses = listEnv("USER_SERVICE_PASSWORD_REQUIRED_CLASSES", []string{ClassLetter, ClassDigit})
+	PasswordHistoryLength   = intEnv("USER_SERVICE_PASSWORD_HISTORY", 0)
+	BreachedPasswordsFile   = os.Getenv("USER_SERVICE_BREACHED_PASSWORDS_FILE")
 )
 
+// listEnv reads a comma separated list from the environment, falling back to def when unset.
+// Unlike the other helpers an empty value is kept, it means an empty list.
+func listEnv(name string, def []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// boolEnv reads "true" or "false" from the environment, falling back to def when unset or invalid
+func boolEnv(name string, def bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// intEnv reads a positive number from the environment, falling back to def when unset or invalid
+func intEnv(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// stringEnv reads a string from the environment, falling back to def when unset
+func stringEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// durationEnv reads a duration such as "15m" from the environment, falling back to def when unset or invalid
+func durationEnv(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
 // Set DBPort explicitly to 5432 inside the container
 const DBPort = "5432"
 
@@ -30,7 +152,41 @@ func PrintEnvVariables() {
 	fmt.Printf("DBPort: %s\n", DBPort)
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
-	fmt.Printf("JWTSecret: %s\n", JWTSecret)
+	fmt.Printf("AccessTokenTTL: %s\n", AccessTokenTTL)
+	fmt.Printf("RefreshTokenTTL: %s\n", RefreshTokenTTL)
+	fmt.Printf("JWTAlgorithm: %s\n", JWTAlgorithm)
+	fmt.Printf("JWTKeyDir: %s\n", JWTKeyDir)
+	fmt.Printf("JWTKeyRotation: %s\n", JWTKeyRotation)
+	fmt.Printf("MaxFailedLogins: %d\n", MaxFailedLogins)
+	fmt.Printf("LockoutDuration: %s\n", LockoutDuration)
+	fmt.Printf("TwoFactorRequiredRoles: %s\n", strings.Join(TwoFactorRequiredRoles, ", "))
+	fmt.Printf("TOTPIssuer: %s\n", TOTPIssuer)
+	fmt.Printf("MailerKind: %s\n", MailerKind)
+	fmt.Printf("MailFile: %s\n", MailFile)
+	fmt.Printf("MailFrom: %s\n", MailFrom)
+	fmt.Printf("SMTPHost: %s\n", SMTPHost)
+	fmt.Printf("SMTPPort: %s\n", SMTPPort)
+	fmt.Printf("SMTPUsername: %s\n", SMTPUsername)
+	fmt.Printf("PasswordResetURL: %s\n", PasswordResetURL)
+	fmt.Printf("PasswordResetTTL: %s\n", PasswordResetTTL)
+	fmt.Printf("EmailVerificationURL: %s\n", EmailVerificationURL)
+	fmt.Printf("EmailVerificationTTL: %s\n", EmailVerificationTTL)
+	fmt.Printf("SelfRegistration: %t\n", SelfRegistration)
+	fmt.Printf("InvitationURL: %s\n", InvitationURL)
+	fmt.Printf("InvitationTTL: %s\n", InvitationTTL)
+	fmt.Printf("BootstrapAdminUsername: %s\n", BootstrapAdminUsername)
+	fmt.Printf("BootstrapAdminMail: %s\n", BootstrapAdminMail)
+	fmt.Printf("LoginHistoryRetention: %s\n", LoginHistoryRetention)
+	fmt.Printf("TrashRetention: %s\n", TrashRetention)
+	fmt.Printf("PasswordHasherKind: %s\n", PasswordHasherKind)
+	fmt.Printf("BcryptCost: %d\n", BcryptCost)
+	fmt.Printf("Argon2Memory: %d\n", Argon2Memory)
+	fmt.Printf("Argon2Iterations: %d\n", Argon2Iterations)
+	fmt.Printf("Argon2Parallelism: %d\n", Argon2Parallelism)
+	fmt.Printf("PasswordMinLength: %d\n", PasswordMinLength)
+	fmt.Printf("PasswordRequiredClasses: %s\n", strings.Join(PasswordRequiredClasses, ", "))
+	fmt.Printf("PasswordHistoryLength: %d\n", PasswordHistoryLength)
+	fmt.Printf("BreachedPasswordsFile: %s\n", BreachedPasswordsFile)
 
 	// Ensure all required environment variables are set
 	missingEnvVars := false
@@ -23,7 +23,8 @@ func TestLoadEnvVariables(t *testing.T) {
 	DBName := os.Getenv("USER_POSTGRES_DB_NAME")
 	ServicePort := os.Getenv("USER_SERVICE_PORT")
 	ServiceName := os.Getenv("USER_SERVICE_NAME")
-	JWTSecret := os.Getenv("USER_SERVICE_JWT_SECRET")
+	JWTAlgorithm := os.Getenv("USER_SERVICE_JWT_ALG")
+	JWTKeyDir := os.Getenv("USER_SERVICE_JWT_KEY_DIR")
 
 	fmt.Println("🔧 Loaded Environment Variables-USER_SERVICE")
 	fmt.Printf("DBUser: %s\n", DBUser)
@@ -32,7 +33,8 @@ func TestLoadEnvVariables(t *testing.T) {
 	fmt.Printf("DBHost: %s\n", DBHost)
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
-	fmt.Printf("JWTSecret: %s\n", JWTSecret)
+	fmt.Printf("JWTAlgorithm: %s\n", JWTAlgorithm)
+	fmt.Printf("JWTKeyDir: %s\n", JWTKeyDir)
 
 	// Verify the environment variables are set correctly
 	assert.Equal(t, "user-db", DBHost, "DBHost should be localhost")
@@ -41,5 +43,6 @@ func TestLoadEnvVariables(t *testing.T) {
 	assert.Equal(t, "user_db", DBName, "DBName should be userdb")
 	assert.Equal(t, "8080", ServicePort, "ServicePort should be 8080")
 	assert.Equal(t, "USER-SERVICE", ServiceName, "ServiceName should be user-service")
-	assert.Equal(t, "6fjZ2@sjKl#F8tTr1&n!X2ZjzGp#nJ2k2ZoLs45!Vqa5m0F!ztr7@1f#Vjz1j", JWTSecret, "JWTSecret should be secret")
+	assert.Equal(t, "RS256", JWTAlgorithm, "JWTAlgorithm should be RS256")
+	assert.Equal(t, "/app/keys", JWTKeyDir, "JWTKeyDir should be /app/keys")
 }
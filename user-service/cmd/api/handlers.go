@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -26,23 +29,25 @@ const (
 	LoginSuccess          = "Login successful"
 )
 
-// Secret key for JWT signing
-var jwtSecret = []byte(JWTSecret)
-
-// GenerateJWT creates a JWT token for a user
-func GenerateJWT(username, role string) (string, error) {
+// GenerateJWT creates a short-lived access token for a user's session, signed with the current key of app.Keys
+func (app *Config) GenerateJWT(user User, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
-		"username": username,
-		"role":     role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+		"uid":       user.ID, // Lets salestracking-service match the caller to the owners of its sales
+		"username":  user.Username,
+		"role":      user.Role,
+		"ver":       user.TokenVersion, // Tokens with an older version are rejected by AuthMiddleware
+		"sid":       sessionID,         // Tokens of a revoked session are rejected by AuthMiddleware
+		"token_use": "access",          // Partial login tokens, see totp.go, are signed by the same keys
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(AccessTokenTTL).Unix(), // Token expires after AccessTokenTTL, renew it with /token/refresh
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return app.Keys.Sign(claims)
 }
 
-// AuthMiddleware verifies JWT tokens
-func AuthMiddleware(next http.Handler) http.Handler {
+// AuthMiddleware verifies JWT tokens. It rejects tokens of deactivated users, tokens issued
+// before the user's last password change and tokens of sessions ended by logout.
+func (app *Config) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -57,25 +62,105 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Parse and verify JWT
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		// Parse and verify JWT against the user and session it was issued for
+		caller, err := app.verifyCaller(tokenString)
+		if err != nil {
+			writeCallerError(w, err)
 			return
 		}
 
-		// Add username and role to request context (optional)
-		r.Header.Set("X-Username", claims["username"].(string))
-		r.Header.Set("X-Role", claims["role"].(string))
+		// Remember when the session was last used, shown by GET /me/sessions
+		if err := touchSession(app.DB, caller.SessionID, time.Now()); err != nil {
+			log.Printf("⚠️ Failed to update the last seen time of a session of %s: %v", caller.Username, err)
+		}
+
+		// Add username and role to the request headers and context
+		r.Header.Set("X-Username", caller.Username)
+		r.Header.Set("X-Role", caller.Role)
+		ctx := context.WithValue(r.Context(), authUserKey, caller)
 
-		next.ServeHTTP(w, r) // Call the next handler
+		next.ServeHTTP(w, r.WithContext(ctx)) // Call the next handler
 	})
 }
 
+// Reasons verifyCaller rejects a well-formed token for
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenRevoked = errors.New("token has been revoked")
+)
+
+// verifyCaller checks a token like AuthMiddleware does: besides its signature, it must belong to an
+// existing, activated user, carry their current token version and come from a session that has not
+// been logged out or revoked. Other errors are database errors.
+func (app *Config) verifyCaller(tokenString string) (AuthUser, error) {
+	caller, ok := app.parseUserToken(tokenString)
+	if !ok {
+		return AuthUser{}, errInvalidToken
+	}
+
+	var user User
+	result := app.DB.Select("id", "activated", "token_version").Where("username = ?", caller.Username).First(&user)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return AuthUser{}, errInvalidToken
+		}
+		return AuthUser{}, result.Error
+	}
+	if !user.Activated {
+		return AuthUser{}, ErrAccountDeactivated
+	}
+	if caller.TokenVersion != user.TokenVersion {
+		return AuthUser{}, errTokenRevoked
+	}
+
+	active, err := sessionActive(app.DB, caller.SessionID)
+	if err != nil {
+		return AuthUser{}, err
+	}
+	if !active {
+		return AuthUser{}, errTokenRevoked
+	}
+	caller.ID = user.ID
+	return caller, nil
+}
+
+// callerRejected reports whether verifyCaller rejected the token rather than failing to check it
+func callerRejected(err error) bool {
+	return err == errInvalidToken || err == errTokenRevoked || err == ErrAccountDeactivated
+}
+
+// writeCallerError answers a token rejected by verifyCaller with 401, other errors are database errors
+func writeCallerError(w http.ResponseWriter, err error) {
+	switch err {
+	case errInvalidToken:
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	case errTokenRevoked:
+		http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+	case ErrAccountDeactivated:
+		http.Error(w, "Account is deactivated", http.StatusUnauthorized)
+	default:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// parseUserToken verifies a token issued by GenerateJWT and returns the user it was issued to
+func (app *Config) parseUserToken(tokenString string) (AuthUser, bool) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, app.Keys.Keyfunc)
+	if err != nil || !token.Valid || claims["token_use"] != "access" {
+		return AuthUser{}, false
+	}
+
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	version, _ := claims["ver"].(float64)
+	sessionID, _ := claims["sid"].(string)
+	if username == "" || sessionID == "" {
+		return AuthUser{}, false
+	}
+	return AuthUser{Username: username, Role: role, TokenVersion: int(version), SessionID: sessionID}, true
+}
+
 // HealthCheckHandler checks the database connection
 // @Summary Health Check
 // @Description Checks if the database connection is healthy
@@ -102,50 +187,90 @@ func (app *Config) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-// HashPassword hashes a password using bcrypt
+// HashPassword hashes a password with the configured hasher
 func (app *Config) HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+	return app.Hasher.Hash(password)
 }
 
-// CheckPassword compares a hashed password with a plain one
+// CheckPassword compares a hashed password with a plain one. Both bcrypt and Argon2id hashes are recognized.
 func (app *Config) CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	return comparePassword(hashedPassword, password)
 }
 
 // CreateUserHandler registers a new user
 // @Summary Register User
-// @Description Creates a new user account
+// @Description Creates a new user account waiting for verification and emails a verification link to its mail address.
+// @Description The account is activated by GET /verify-email, no tokens are issued before that.
+// @Description Only Admins can be registered unless USER_SERVICE_SELF_REGISTRATION=true, other users are invited with POST /invitations.
 // @Tags Users
 // @Accept json
 // @Produce json
-// @Param user body User true "User Data"
+// @Param user body registerRequest true "User Data"
 // @Success 200 {object} map[string]string
-// @Failure 400 {string} string "Invalid request body"
+// @Failure 400 {string} string "Invalid request body, or JSON with the violated rules when the password is rejected by the policy"
+// @Failure 403 {string} string "Self-registration is disabled or only an Admin can register another Admin"
 // @Failure 409 {string} string "User already exists"
-// @Failure 500 {string} string "Error inserting user"
+// @Failure 500 {string} string "Error inserting user or failed to send verification email"
 // @Router /register [post]
 func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var request registerRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
 		return
 	}
 
+	// Only the fields of the request are taken over, clients cannot set the account state
+	user := User{
+		Username:    request.Username,
+		MailAddress: request.MailAddress,
+		Password:    request.Password,
+		Role:        request.Role,
+	}
+
 	// Ensure MailAddress is provided
 	if user.MailAddress == "" {
 		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
 		return
 	}
 
-	// Check if user already exists (by username OR mail address)
+	// New accounts are sales representatives unless another role is asked for
+	if user.Role == "" {
+		user.Role = RoleSalesRep
+	}
+	if !isValidRole(user.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	// Without self-registration everybody but Admins is invited, see invitations.go
+	if !SelfRegistration && user.Role != RoleAdmin {
+		http.Error(w, ErrSelfRegistrationDisabled, http.StatusForbidden)
+		return
+	}
+
+	// Only admins may create other admins, the first one is created by BootstrapAdmin
+	if user.Role == RoleAdmin {
+		allowed, err := app.canRegisterAdmin(r)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Only an Admin can register another Admin", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Enforce the password policy
+	if err := app.validateNewPassword(user.Password, 0, "", user.Username, user.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+
+	// Check if user already exists (by username OR mail address), users in the trash keep theirs until purged
 	var existingUser User
-	if err := app.DB.Where("username = ? OR mail_address = ?", user.Username, user.MailAddress).First(&existingUser).Error; err == nil {
+	if err := app.DB.Unscoped().Where("username = ? OR mail_address = ?", user.Username, user.MailAddress).First(&existingUser).Error; err == nil {
 		http.Error(w, "User already exists", http.StatusConflict)
 		return
 	}
@@ -158,8 +283,9 @@ func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	user.Password = hashedPassword
 
-	//  Sets Activated = true for every new user.
-	user.Activated = true
+	// New accounts stay inactive until the emailed verification link is opened
+	user.Activated = false
+	user.VerificationPending = true
 
 	// Insert user into database using GORM
 	result := app.DB.Create(&user)
@@ -168,35 +294,53 @@ func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := GenerateJWT(user.Username, user.Role)
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+	if err := app.sendVerificationEmail(user, time.Now()); err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
 		return
 	}
+	app.recordSecurityEvent(EventVerificationSent, user.Username, clientIP(r), "registration")
 
-	// Send response
+	// Send response, tokens are only issued by /login once the address is verified
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":     UserCreatedSuccess,
-		"token":       token,
-		"mailAddress": user.MailAddress, // Include mail address in the response
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":               UserCreatedSuccess,
+		"mailAddress":           user.MailAddress, // Include mail address in the response
+		"verification_required": true,
 	})
 }
 
+// canRegisterAdmin reports whether the registration request may create an Admin, which takes a valid
+// Admin token. Tokens of deactivated users, revoked sessions or older token versions do not count.
+// The first Admin is created at startup by BootstrapAdmin.
+func (app *Config) canRegisterAdmin(r *http.Request) (bool, error) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	caller, err := app.verifyCaller(tokenString)
+	if err != nil {
+		if callerRejected(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return hasPermission(caller.Role, PermManageRoles), nil
+}
+
 // LoginUserHandler authenticates a user
 // @Summary Login User
-// @Description Logs in a user and returns a JWT token
+// @Description Logs in a user and returns a short-lived access token and a refresh token.
+// @Description Users with 2FA, or whose role requires it, get an mfa_token instead, to be completed with /login/2fa or /login/2fa/enroll.
 // @Tags Users
 // @Accept json
 // @Produce json
-// @Param credentials body User true "User Credentials"
+// @Param credentials body loginRequest true "User Credentials"
 // @Success 200 {object} map[string]string
 // @Failure 400 {string} string "Invalid request body"
 // @Failure 401 {string} string "Invalid credentials"
+// @Failure 403 {string} string "Email address is not verified or account is deactivated"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
 // @Router /login [post]
 func (app *Config) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
+	var user loginRequest
 	var storedUser User
 
 	err := json.NewDecoder(r.Body).Decode(&user)
@@ -205,58 +349,147 @@ func (app *Config) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find user in DB
+	// IP addresses that keep failing are slowed down, whichever accounts they try
+	ip := clientIP(r)
+	now := time.Now()
+	if wait := app.LoginThrottle.RetryAfter(ip, now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, user.Username, ip, "IP address backoff")
+		app.recordLoginEvent(r, 0, user.Username, false, LoginReasonIPThrottled)
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	// Find user in DB. Unknown usernames get the same answer, in the same time, as wrong passwords.
 	result := app.DB.Where("username = ?", user.Username).First(&storedUser)
 	if result.Error != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+		if result.Error != gorm.ErrRecordNotFound {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if wait := app.LoginThrottle.UnknownRetryAfter(user.Username, now); wait > 0 {
+			app.recordSecurityEvent(EventLoginBlocked, user.Username, ip, "account backoff or lockout")
+			app.recordLoginEvent(r, 0, user.Username, false, LoginReasonAccountThrottled)
+			writeTooManyAttempts(w, wait)
+			return
+		}
+		app.CheckPassword(app.dummyPasswordHash(), user.Password)
+		app.LoginThrottle.Fail(ip, now)
+		app.LoginThrottle.FailUnknown(user.Username, now)
+		app.recordSecurityEvent(EventLoginFailed, user.Username, ip, "unknown username")
+		app.recordLoginEvent(r, 0, user.Username, false, LoginReasonUnknownUsername)
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	// Accounts wait longer after every failure and are locked after MaxFailedLogins
+	if wait := storedUser.loginRetryAfter(now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, storedUser.Username, ip, "account backoff or lockout")
+		app.recordLoginEvent(r, storedUser.ID, storedUser.Username, false, LoginReasonAccountThrottled)
+		writeTooManyAttempts(w, wait)
 		return
 	}
 
 	// Compare passwords
 	if !app.CheckPassword(storedUser.Password, user.Password) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		locked, err := app.recordLoginFailure(storedUser, now)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		app.LoginThrottle.Fail(ip, now)
+		app.recordSecurityEvent(EventLoginFailed, storedUser.Username, ip, "wrong password")
+		app.recordLoginEvent(r, storedUser.ID, storedUser.Username, false, LoginReasonWrongPassword)
+		if locked {
+			app.recordSecurityEvent(EventAccountLocked, storedUser.Username, ip, fmt.Sprintf("locked for %s", LockoutDuration))
+		}
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
+	app.upgradePasswordHash(&storedUser, user.Password)
 
-	// Generate JWT token
-	token, err := GenerateJWT(storedUser.Username, storedUser.Role)
+	// New accounts cannot log in before their mail address is verified
+	if storedUser.VerificationPending {
+		app.recordLoginEvent(r, storedUser.ID, storedUser.Username, false, LoginReasonNotVerified)
+		http.Error(w, ErrEmailNotVerified, http.StatusForbidden)
+		return
+	}
+
+	// Deactivated accounts cannot log in until an Admin activates them again
+	if !storedUser.Activated {
+		app.recordLoginEvent(r, storedUser.ID, storedUser.Username, false, LoginReasonDeactivated)
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+
+	// Users with 2FA, or whose role requires it, only get a partial token until the code is checked.
+	// Earlier failures are not forgotten yet, wrong codes keep counting towards the lockout.
+	if storedUser.TOTPEnabled || requiresTwoFactor(storedUser.Role) {
+		app.writeMFAChallenge(w, storedUser)
+		return
+	}
+
+	app.completeLogin(w, r, storedUser, LoginReasonPassword, nil)
+}
+
+// completeLogin opens a session for a user who passed every login step, records the login with
+// the given reason in the login history and sends the tokens. extra fields are added to the response.
+func (app *Config) completeLogin(w http.ResponseWriter, r *http.Request, user User, reason string, extra map[string]interface{}) {
+	// Open a session and generate its tokens
+	tokens, err := app.startSession(user, newSessionClient(r))
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Update login_status to true
-	storedUser.LoginStatus = true
-	app.DB.Save(&storedUser)
-
-	// Send token to client
+	// Update login_status to true and forget earlier failures
+	err = app.DB.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"login_status":          true,
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordLoginEvent(r, user.ID, user.Username, true, reason)
+
+	// Send tokens to client
+	response := map[string]interface{}{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"message":       LoginSuccess,
+		"loginStatus":   "true",
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"token":       token,
-		"message":     "Login successful",
-		"loginStatus": "true",
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-// UpdatePasswordHandler updates a user's password if they exist
+// UpdatePasswordHandler changes a user's password
 // @Summary Update user password
-// @Description Updates the password for an existing user
+// @Description Changes the caller's password after checking the current one. Admins can reset the password of another user without it; such resets are audited.
+// @Description Every token issued before the change stops working, the caller gets a fresh token for their own account.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param request body struct {
-// @Param username body string true "Username of the user"
-// @Param new_password body string true "New password for the user"
-// } true "Request body"
-// @Success 200 {string} string "Password updated successfully"
-// @Failure 400 {string} string "Invalid request body"
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{Username string `json:"username"`; CurrentPassword string `json:"current_password"`; NewPassword string `json:"new_password"`} true "Target username (defaults to the caller), current and new password"
+// @Success 200 {object} map[string]string "Password updated successfully"
+// @Failure 400 {string} string "Invalid request body or password rejected by the policy"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden or current password is incorrect"
 // @Failure 404 {string} string "User not found"
 // @Failure 500 {string} string "Database error or hashing error"
 // @Router /update-password [post]
 func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var requestData struct {
-		Username    string `json:"username"`
-		NewPassword string `json:"new_password"`
+		Username        string `json:"username"`
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
 	}
 
 	// Decode request body
@@ -266,26 +499,52 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Log the incoming request for debugging
-	fmt.Println("Received request to update password for username:", requestData.Username)
+	// The target defaults to the authenticated user
+	caller, _ := authUserFromContext(r.Context())
+	if requestData.Username == "" {
+		requestData.Username = caller.Username
+	}
+	selfChange := requestData.Username == caller.Username
+
+	// Changing somebody else's password is an Admin reset
+	if !selfChange && !hasPermission(caller.Role, PermManageUsers) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Find the user by username
 	var user User
 	result := app.DB.Where("username = ?", requestData.Username).First(&user)
-
-	// Log the result of the query to help debug
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, ErrUserNotFound, http.StatusNotFound)
-			fmt.Println("User not found:", requestData.Username) // Add log for failed query
 			return
 		}
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Log the found user for debugging
-	fmt.Println("Found user:", user)
+	// Users changing their own password must prove they know the current one
+	if selfChange {
+		if requestData.CurrentPassword == "" {
+			http.Error(w, "Current password is required", http.StatusBadRequest)
+			return
+		}
+		if !app.CheckPassword(user.Password, requestData.CurrentPassword) {
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+			return
+		}
+		if requestData.CurrentPassword == requestData.NewPassword {
+			http.Error(w, "New password must differ from the current password", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Enforce the password policy, including the password history
+	if err := app.validateNewPassword(requestData.NewPassword, user.ID, user.Password, user.Username, user.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
 
 	// Hash new password
 	hashedPassword, err := app.HashPassword(requestData.NewPassword)
@@ -294,15 +553,51 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Update the password
+	// Update the password and invalidate every token issued so far.
+	// The caller's own session survives a self change, every other session is ended.
+	replacedHash := user.Password
 	user.Password = hashedPassword
-	app.DB.Save(&user)
+	user.TokenVersion++
+	keepSessionID := ""
+	if selfChange {
+		keepSessionID = caller.SessionID
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if err := rememberPassword(tx, user.ID, replacedHash); err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, keepSessionID)
+	})
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
 
-	// Log successful password update
-	fmt.Println("Password updated for user:", requestData.Username)
+	response := map[string]string{
+		"message":  "Password updated successfully",
+		"username": user.Username,
+	}
+
+	if selfChange {
+		log.Printf("Password changed by user %s", user.Username)
+
+		// Keep the caller signed in with a token carrying the new version
+		token, err := app.GenerateJWT(user, caller.SessionID)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		response["token"] = token
+	} else {
+		log.Printf("AUDIT: password of user %s reset by admin %s", user.Username, caller.Username)
+	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Password updated successfully")
+	json.NewEncoder(w).Encode(response)
 }
 
 // GetUserHandler retrieves a user by ID
@@ -310,16 +605,30 @@ func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request)
 // @Description Fetch a user by ID
 // @Tags Users
 // @Produce json
-// @Param id query string true "User ID"
-// @Success 200 {object} User
+// @Param id query int true "User ID"
+// @Success 200 {object} userResponse
+// @Failure 400 {string} string "Invalid user ID"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
 // @Router /user [get]
 func (app *Config) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
-	id := r.URL.Query().Get("id")
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Sales representatives can only read their own profile, checked before the lookup so that
+	// other IDs answer 403 whether they exist or not
+	caller, _ := authUserFromContext(r.Context())
+	if !canAccessUserID(caller, uint(id), PermReadUsers) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Fetch the user by ID from the database
-	result := app.DB.First(&user, id)
+	var user User
+	result := app.DB.First(&user, uint(id))
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, ErrUserNotFound, http.StatusNotFound)
@@ -329,34 +638,161 @@ func (app *Config) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the MailAddress is empty and log a warning or handle appropriately
-	if user.MailAddress == "" {
-		// You can log this if necessary or handle the empty field case
-		fmt.Println("Warning: User has no MailAddress.")
+	// Respond with user data in JSON format, without the password hash
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// ListUsersHandler lists users with filtering, search, sorting and cursor pagination
+// @Summary List users
+// @Description Lists the users matching the filters. Results are paginated with an opaque cursor; the next page is advertised in the Link header.
+// @Tags Users
+// @Produce json
+// @Param role query string false "Only users with this role"
+// @Param activated query bool false "Only activated (true) or deactivated (false) users"
+// @Param login_status query bool false "Only logged in (true) or logged out (false) users"
+// @Param created_after query string false "Only users created after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Only users created before this time (RFC 3339 or YYYY-MM-DD)"
+// @Param q query string false "Search term matched case-insensitively against username and mail address"
+// @Param sort query string false "Sort field (id, username, mail_address, role, created_at, updated_at), prefix with - for descending. Default username"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page's Link header"
+// @Success 200 {array} userResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Database error"
+// @Router /users [get]
+func (app *Config) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := app.DB.Model(&User{})
+
+	// Parse sorting and page size
+	sort, err := parseSort(params.Get("sort"), "username", userSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parsePageSize(params.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Apply filters
+	if role := params.Get("role"); role != "" {
+		if !isValidRole(role) {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("role = ?", role)
+	}
+	for param, column := range map[string]string{"activated": "activated", "login_status": "login_status"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid "+param, http.StatusBadRequest)
+			return
+		}
+		query = query.Where(column+" = ?", flag)
+	}
+	if createdAfter := params.Get("created_after"); createdAfter != "" {
+		t, err := parseDateParam(createdAfter)
+		if err != nil {
+			http.Error(w, "Invalid created_after", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at > ?", t)
+	}
+	if createdBefore := params.Get("created_before"); createdBefore != "" {
+		t, err := parseDateParam(createdBefore)
+		if err != nil {
+			http.Error(w, "Invalid created_before", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	if q := params.Get("q"); q != "" {
+		// LOWER ... LIKE instead of ILIKE, which only PostgreSQL knows
+		pattern := strings.ToLower(likePattern(q))
+		query = query.Where(`(LOWER(username) LIKE ? ESCAPE '\' OR LOWER(mail_address) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+
+	// Continue after the cursor, if one was given
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw, sort)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clause, args, err := sort.AfterCursor(cursor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(clause, args...)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var users []User
+	if err := query.Order(sort.OrderClause()).Limit(limit + 1).Find(&users).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(users) > limit {
+		users = users[:limit]
+		next = encodeCursor(userCursor(users[len(users)-1], sort))
 	}
 
-	// Respond with user data in JSON format
+	response := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newUserResponse(user))
+	}
+
+	// Send JSON response with pagination links
+	setPaginationLinks(w, r, next)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(response)
+}
+
+// userCursor builds the cursor pointing after the given user for the given sort
+func userCursor(user User, sort listSort) pageCursor {
+	cursor := pageCursor{Sort: sort.Key, ID: user.ID}
+	switch sort.Field.Column {
+	case "username":
+		cursor.Value = user.Username
+	case "mail_address":
+		cursor.Value = user.MailAddress
+	case "role":
+		cursor.Value = user.Role
+	case "created_at":
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = user.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
 }
 
 // UpdateUserHandler updates user information if they exist
 // @Summary Update user details
-// @Description Updates the details of an existing user, such as password, email, or role
+// @Description Updates the details of an existing user, such as the role. Passwords are changed with /update-password and email addresses with /update-email.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param request body struct {
 // @Param username body string true "Username of the user"
-// @Param password body string false "New password for the user (optional)"
-// @Param email body string false "New email for the user (optional)"
 // @Param role body string false "New role for the user (optional)"
 // } true "Request body"
 // @Success 200 {object} map[string]string "User updated successfully"
-// @Failure 400 {string} string "Invalid request body or missing username"
+// @Failure 400 {string} string "Invalid request body, missing username, or password or email given"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
-// @Failure 500 {string} string "Database error or hashing error"
+// @Failure 500 {string} string "Database error"
 // @Router /update-user [post]
 func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body to get username and updated fields
@@ -377,6 +813,35 @@ func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Passwords only change through the verified /update-password flow
+	if requestBody.Password != "" {
+		http.Error(w, "Use /update-password to change the password", http.StatusBadRequest)
+		return
+	}
+
+	// Sales representatives can only update their own profile and never their role
+	caller, _ := authUserFromContext(r.Context())
+	if !canAccessUser(caller, requestBody.Username, PermManageUsers) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Mail addresses only change through the confirmed /update-email flow
+	if requestBody.Email != "" {
+		http.Error(w, "Use /update-email to change the email", http.StatusBadRequest)
+		return
+	}
+	if requestBody.Role != "" {
+		if !hasPermission(caller.Role, PermManageRoles) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !isValidRole(requestBody.Role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Find user by username
 	var user User
 	result := app.DB.Where("username = ?", requestBody.Username).First(&user)
@@ -390,19 +855,9 @@ func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update user fields if provided
-	if requestBody.Password != "" {
-		hashedPassword, err := app.HashPassword(requestBody.Password)
-		if err != nil {
-			http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
-			return
-		}
-		user.Password = hashedPassword
-	}
-	if requestBody.Email != "" {
-		user.MailAddress = requestBody.Email
-	}
-	if requestBody.Role != "" {
+	if requestBody.Role != "" && requestBody.Role != user.Role {
 		user.Role = requestBody.Role
+		user.TokenVersion++ // Tokens still carrying the old role stop working
 	}
 
 	// Save updated user
@@ -421,13 +876,15 @@ func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 // DeactivateUserHandler deactivates a user by setting the "Activated" field to false
 // @Summary Deactivate a user
-// @Description Deactivates a user account by setting their "Activated" status to false
+// @Description Deactivates a user account by setting their "Activated" status to false.
+// @Description All tokens and sessions of the user end immediately and logging in is refused until the account is activated again.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param requestBody body struct{Username string `json:"username"`} true "Username of the user to deactivate"
 // @Success 200 {object} map[string]string "User deactivated successfully"
 // @Failure 400 {string} string "Invalid request body or missing username"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
 // @Failure 500 {string} string "Failed to deactivate user"
 // @Router /deactivate-user [post]
@@ -455,11 +912,19 @@ func (app *Config) DeactivateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Set Activated to false
+	// Set Activated to false and end all sessions and tokens of the user
 	user.Activated = false
+	user.LoginStatus = false
+	user.TokenVersion++
 
 	// Update the user in the database
-	if err := app.DB.Save(&user).Error; err != nil {
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "")
+	})
+	if err != nil {
 		http.Error(w, "Failed to deactivate user", http.StatusInternalServerError)
 		return
 	}
@@ -481,6 +946,7 @@ func (app *Config) DeactivateUserHandler(w http.ResponseWriter, r *http.Request)
 // @Param requestBody body struct{Username string `json:"username"`} true "Username of the user to activate"
 // @Success 200 {object} map[string]string "User activated successfully"
 // @Failure 400 {string} string "Invalid request body or missing username"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
 // @Failure 500 {string} string "Failed to activate user"
 // @Router /activate-user [post]
@@ -525,50 +991,30 @@ func (app *Config) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// UpdateEmailHandler updates a user's email
+// UpdateEmailHandler requests a change of a user's email
 // @Summary Update a user's email
-// @Description Updates a user's email address after validating their JWT token
+// @Description Emails a confirmation link to the new address, the address changes only once the link is opened with GET /verify-email.
+// @Description Users changing their own address must send their current password, Admins can change the address of others without it.
+// @Description Applying the change invalidates the user's access tokens.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param Authorization header string true "Bearer JWT token"
-// @Param requestBody body struct{Username string `json:"username"`; NewEmail string `json:"new_email"`} true "Username and new email"
-// @Success 200 {object} map[string]string "Email updated successfully"
-// @Failure 400 {string} string "Invalid request body or email format"
+// @Param requestBody body struct{Username string `json:"username"`; NewEmail string `json:"new_email"`; CurrentPassword string `json:"current_password"`} true "Username, new email and current password"
+// @Success 200 {object} map[string]string "Confirmation link sent to the new email"
+// @Failure 400 {string} string "Invalid request body, email format or missing current password"
 // @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden or current password is incorrect"
 // @Failure 404 {string} string "User not found"
+// @Failure 409 {string} string "Email address is already in use"
 // @Failure 500 {string} string "Failed to update email"
-// @Router /update-email [post]
+// @Router /update-email [put]
 func (app *Config) UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
-
-	// Extract token from "Bearer <token>"
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	// Parse and validate JWT
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	// Parse the request body (expects JSON with username and new email)
+	// Parse the request body (expects JSON with username, new email and the current password)
 	var requestData struct {
-		Username string `json:"username"`
-		NewEmail string `json:"new_email"`
+		Username        string `json:"username"`
+		NewEmail        string `json:"new_email"`
+		CurrentPassword string `json:"current_password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -587,6 +1033,14 @@ func (app *Config) UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Sales representatives can only change their own email
+	caller, _ := authUserFromContext(r.Context())
+	if !canAccessUser(caller, requestData.Username, PermManageUsers) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	selfChange := requestData.Username == caller.Username
+
 	// Find user by username
 	var user User
 	result := app.DB.Where("username = ?", requestData.Username).First(&user)
@@ -599,63 +1053,75 @@ func (app *Config) UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update email
-	user.MailAddress = requestData.NewEmail
-	if err := app.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to update email", http.StatusInternalServerError)
+	// Users changing their own address must prove they know the password, a stolen token is not enough
+	if selfChange {
+		if requestData.CurrentPassword == "" {
+			http.Error(w, "Current password is required", http.StatusBadRequest)
+			return
+		}
+		if !app.CheckPassword(user.Password, requestData.CurrentPassword) {
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+			return
+		}
+	}
+
+	if requestData.NewEmail == user.MailAddress {
+		http.Error(w, "New email must differ from the current email", http.StatusBadRequest)
+		return
+	}
+	var taken int64
+	if err := app.DB.Unscoped().Model(&User{}).Where("mail_address = ?", requestData.NewEmail).Count(&taken).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if taken > 0 {
+		http.Error(w, ErrEmailInUse, http.StatusConflict)
 		return
 	}
 
-	// Log the email update action (optional)
-	fmt.Printf("User %s updated their email to %s\n", user.Username, user.MailAddress)
+	// Remember the new address until the link emailed to it is opened, a newer request replaces it
+	user.PendingMailAddress = requestData.NewEmail
+	if err := app.DB.Model(&User{}).Where("id = ?", user.ID).Update("pending_mail_address", user.PendingMailAddress).Error; err != nil {
+		http.Error(w, "Failed to update email", http.StatusInternalServerError)
+		return
+	}
+	if err := app.sendEmailChangeEmail(user, time.Now()); err != nil {
+		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventEmailChangeRequested, user.Username, clientIP(r), "by "+caller.Username)
+	if !selfChange {
+		log.Printf("AUDIT: email change of user %s requested by admin %s", user.Username, caller.Username)
+	}
 
 	// Send success response
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":   "Email updated successfully",
-		"username":  user.Username,
-		"new_email": user.MailAddress,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":               "Confirmation link sent to the new email, the address changes once it is opened",
+		"username":              user.Username,
+		"pending_email":         user.PendingMailAddress,
+		"verification_required": true,
 	})
 }
 
 // UpdateRoleHandler updates a user's role
 // @Summary Update a user's role
-// @Description Updates a user's role after validating their JWT token
+// @Description Updates a user's role after validating their JWT token.
+// @Description Access tokens issued with the old role stop working; the user gets the new role on the next refresh or login.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param Authorization header string true "Bearer JWT token"
 // @Param requestBody body struct{Username string `json:"username"`; Role string `json:"role"`} true "Username and new role"
 // @Success 200 {string} string "User role updated successfully"
-// @Failure 400 {string} string "Invalid request body or missing fields"
+// @Failure 400 {string} string "Invalid request body, missing fields or unknown role"
 // @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
 // @Failure 500 {string} string "Failed to update role"
 // @Router /update-role [post]
 func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
-
-	// Extract the token and parse it
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
 	// Extract the username and role from the request body
 	var requestData struct {
 		Username string `json:"username"`
@@ -664,7 +1130,7 @@ func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Decode the JSON body into requestData
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&requestData)
+	err := decoder.Decode(&requestData)
 	if err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -679,6 +1145,10 @@ func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Role is required", http.StatusBadRequest)
 		return
 	}
+	if !isValidRole(requestData.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
 
 	// Find the user by username
 	var user User
@@ -688,8 +1158,11 @@ func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the role
-	user.Role = requestData.Role
+	// Update the role, tokens still carrying the old role stop working
+	if user.Role != requestData.Role {
+		user.Role = requestData.Role
+		user.TokenVersion++
+	}
 	result = app.DB.Save(&user)
 	if result.Error != nil {
 		http.Error(w, "Failed to update role", http.StatusInternalServerError)
@@ -701,6 +1174,83 @@ func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "User role updated to: %s", user.Role)
 }
 
+// RefreshTokenHandler exchanges a refresh token for a new access and refresh token
+// @Summary Refresh tokens
+// @Description Rotates a refresh token: the given token is revoked and a new pair is returned. Reusing a revoked refresh token ends its session.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{RefreshToken string `json:"refresh_token"`} true "Refresh token"
+// @Success 200 {object} tokenPair
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Invalid, expired or reused refresh token, deactivated account or 2FA required"
+// @Failure 500 {string} string "Database error"
+// @Router /token/refresh [post]
+func (app *Config) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.RefreshToken == "" {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := app.rotateRefreshToken(requestData.RefreshToken)
+	switch {
+	case errors.Is(err, ErrRefreshTokenReused):
+		log.Printf("Refresh token reuse detected, session revoked")
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrRefreshTokenInvalid):
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrAccountDeactivated):
+		http.Error(w, "Account is deactivated", http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrTwoFactorRequired):
+		http.Error(w, "Two-factor authentication required, log in again", http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// LogoutHandler ends the caller's session
+// @Summary Logout
+// @Description Revokes the refresh tokens of the caller's session; its access tokens stop working immediately
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Success 200 {object} map[string]string
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 500 {string} string "Database error"
+// @Router /logout [post]
+func (app *Config) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeSession(tx, caller.SessionID); err != nil {
+			return err
+		}
+
+		// The user counts as logged out once no session is left
+		return updateLoginStatus(tx, caller.ID)
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Logout successful",
+	})
+}
+
 // Helper function to validate email format (simple validation)
 func isValidEmail(email string) bool {
 	// You can use a more robust regex or library for email validation
@@ -709,12 +1259,13 @@ func isValidEmail(email string) bool {
 	return re.MatchString(email)
 }
 
-// DeleteUserHandler deletes a user
+// DeleteUserHandler moves a user to the trash
 // @Summary Delete User
-// @Description Removes a user by ID
+// @Description Moves a user to the trash and ends all of their sessions. The user can be restored with POST /users/{id}/restore until the trash retention has passed, then the user is purged for good.
 // @Tags Users
-// @Param id query string true "User ID"
+// @Param username body string true "Username"
 // @Success 200 {string} string "User deleted successfully"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "User not found"
 // @Router /user [delete]
 func (app *Config) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -737,35 +1288,12 @@ func (app *Config) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
-
-	// Extract the token and parse it
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	// Optional: Add the username from the token to the request context (for logging or further checks)
-	authenticatedUsername := claims["username"].(string)
+	// The caller verified by AuthMiddleware, for logging
+	caller, _ := authUserFromContext(r.Context())
 
 	// Find the user to delete by username
 	var user User
-	result := app.DB.Where("username = ?", requestData.Username).Delete(&user)
+	result := app.DB.Where("username = ?", requestData.Username).First(&user)
 
 	// If no rows were affected, the user was not found
 	if result.RowsAffected == 0 {
@@ -773,8 +1301,24 @@ func (app *Config) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Move the user to the trash and end their sessions, the tokens are kept until the user is purged
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeUserSessions(tx, user.ID, ""); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("login_status", false).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventUserDeleted, user.Username, clientIP(r), "deleted by "+caller.Username)
+
 	// Log the deletion action (optional)
-	fmt.Printf("User %s (Username: %s) deleted by %s\n", user.Username, requestData.Username, authenticatedUsername)
+	fmt.Printf("User %s (Username: %s) deleted by %s\n", user.Username, requestData.Username, caller.Username)
 
 	// Respond with success message
 	w.WriteHeader(http.StatusOK)
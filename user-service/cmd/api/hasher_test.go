@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small Argon2id costs keep the tests fast
+var testArgon2 = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+// Test that both hashers verify their own and each other's hashes and detect outdated parameters
+func TestPasswordHashers(t *testing.T) {
+	bcryptHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Password123")
+	require.NoError(t, err)
+	argonHash, err := testArgon2.Hash("Password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$"), argonHash)
+
+	for _, hash := range []string{bcryptHash, argonHash} {
+		assert.True(t, comparePassword(hash, "Password123"))
+		assert.False(t, comparePassword(hash, "Password124"))
+	}
+	assert.False(t, comparePassword("$argon2id$v=19$m=1024,t=1,p=1$broken", "Password123"))
+	assert.False(t, comparePassword("", "Password123"))
+
+	assert.False(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(bcryptHash))
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(bcryptHash), "Other cost")
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(argonHash), "Other algorithm")
+	assert.False(t, testArgon2.NeedsRehash(argonHash))
+	assert.True(t, Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1}.NeedsRehash(argonHash), "Other memory")
+	assert.True(t, Argon2idHasher{Memory: 1024, Iterations: 2, Parallelism: 1}.NeedsRehash(argonHash), "Other iterations")
+	assert.True(t, testArgon2.NeedsRehash(bcryptHash), "Other algorithm")
+
+	_, err = NewPasswordHasher("md5")
+	assert.Error(t, err)
+}
+
+// Test that a login replaces an outdated bcrypt hash with an Argon2id hash that keeps working
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	user := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	app.Hasher = testArgon2
+
+	login(t, router, "rep", "Password123")
+	var stored User
+	require.NoError(t, app.DB.First(&stored, user.ID).Error)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"), stored.Password)
+	assert.False(t, testArgon2.NeedsRehash(stored.Password))
+	login(t, router, "rep", "Password123")
+
+	// Wrong passwords leave the hash alone
+	app.Hasher = BcryptHasher{Cost: bcrypt.MinCost}
+	rec := postJSON(router, "/login", "", `{"username":"rep","password":"Password124"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	var unchanged User
+	require.NoError(t, app.DB.First(&unchanged, user.ID).Error)
+	assert.Equal(t, stored.Password, unchanged.Password)
+}
+
+// Test that a rehash does not bring back a password that was changed after the login checked it
+func TestUpgradePasswordHashKeepsNewerPassword(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	app.Hasher = testArgon2
+
+	newHash, err := app.HashPassword("NewPassword456")
+	require.NoError(t, err)
+	require.NoError(t, app.DB.Model(&User{}).Where("id = ?", user.ID).Update("password", newHash).Error)
+
+	app.upgradePasswordHash(&user, "Password123")
+	var stored User
+	require.NoError(t, app.DB.First(&stored, user.ID).Error)
+	assert.Equal(t, newHash, stored.Password)
+	assert.True(t, app.CheckPassword(stored.Password, "NewPassword456"))
+}
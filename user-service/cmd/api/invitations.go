@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for invitations
+const (
+	EventInvitationSent     = "invitation_sent"     // Invitation emailed by an Admin
+	EventInvitationAccepted = "invitation_accepted" // Account created with an invitation
+	EventInvitationRevoked  = "invitation_revoked"  // Invitation withdrawn by an Admin
+)
+
+const (
+	maxInvitationTTL = 30 * 24 * time.Hour // Longest expiry an Admin may give an invitation
+
+	ErrSelfRegistrationDisabled = "Self-registration is disabled, ask an Admin for an invitation"
+	ErrInvalidInvitation        = "Invalid or expired invitation"
+	ErrInvitationNotFound       = "Invitation not found"
+)
+
+// errInvitationInvalid is returned for unknown, accepted, revoked or expired invitations
+var errInvitationInvalid = errors.New("invalid invitation")
+
+// openInvitation returns the invitation of a token if it can still be accepted
+func openInvitation(tx *gorm.DB, token string, now time.Time) (Invitation, error) {
+	var invitation Invitation
+	err := tx.Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", hashToken(token), now).First(&invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Invitation{}, errInvitationInvalid
+	}
+	return invitation, err
+}
+
+// CreateInvitationHandler invites a new user
+// @Summary Invite a user
+// @Description Emails an invitation to create an account with the given mail address and role. Inviting the same address again revokes the earlier invitation.
+// @Description expires_in is a duration such as "72h", USER_SERVICE_INVITATION_TTL by default and at most 30 days. Inviting an Admin needs the permission to manage roles.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{MailAddress string `json:"mailAddress"`; Role string `json:"role"`; ExpiresIn string `json:"expires_in"`} true "Mail address, role (Sales Representative by default) and expiry of the invitation"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid request body, mail address, role or expiry"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "User already exists"
+// @Failure 500 {string} string "Database error"
+// @Router /invitations [post]
+func (app *Config) CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MailAddress string `json:"mailAddress"`
+		Role        string `json:"role"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if !isValidEmail(requestData.MailAddress) {
+		http.Error(w, "Invalid mail address", http.StatusBadRequest)
+		return
+	}
+	if requestData.Role == "" {
+		requestData.Role = RoleSalesRep
+	}
+	if !isValidRole(requestData.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	caller, _ := authUserFromContext(r.Context())
+	if requestData.Role == RoleAdmin && !hasPermission(caller.Role, PermManageRoles) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	ttl := InvitationTTL
+	if requestData.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(requestData.ExpiresIn)
+		if err != nil || parsed <= 0 || parsed > maxInvitationTTL {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	var existing int64
+	if err := app.DB.Unscoped().Model(&User{}).Where("mail_address = ?", requestData.MailAddress).Count(&existing).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if existing > 0 {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		http.Error(w, "Failed to generate invitation", http.StatusInternalServerError)
+		return
+	}
+	link, err := tokenLink(InvitationURL, token)
+	if err != nil {
+		http.Error(w, "Invalid invitation URL", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	invitation := Invitation{
+		MailAddress: requestData.MailAddress,
+		Role:        requestData.Role,
+		TokenHash:   hashToken(token),
+		InvitedBy:   caller.Username,
+		ExpiresAt:   now.Add(ttl),
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Invitation{}).
+			Where("mail_address = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.MailAddress).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	msg := Message{
+		To:      invitation.MailAddress,
+		Subject: "You are invited to Mindset CRM",
+		Body: fmt.Sprintf("Hello,\n\n%s invited you to join Mindset CRM as %s. Open the link below within %s to choose a username and password:\n\n%s\n\n"+
+			"If you did not expect this invitation, ignore this email.\n", caller.Username, invitation.Role, ttl, link),
+	}
+	// The invitation stays valid when the delivery fails, inviting the address again sends a new link
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to send invitation email to %s: %v", invitation.MailAddress, err)
+	}
+	app.recordSecurityEvent(EventInvitationSent, caller.Username, clientIP(r), fmt.Sprintf("invited %s as %s", invitation.MailAddress, invitation.Role))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Invitation sent",
+		"id":          invitation.ID,
+		"mailAddress": invitation.MailAddress,
+		"role":        invitation.Role,
+		"expires_at":  invitation.ExpiresAt,
+	})
+}
+
+// ListInvitationsHandler lists invitations, newest first
+// @Summary List invitations
+// @Description Returns sent invitations, newest first. The tokens are never returned.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param mailAddress query string false "Only invitations to this address"
+// @Param limit query int false "Maximum number of invitations, 100 by default and at most 1000"
+// @Success 200 {array} invitationResponse
+// @Failure 400 {string} string "Invalid limit"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Database error"
+// @Router /invitations [get]
+func (app *Config) ListInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	query := app.DB.Order("created_at DESC, id DESC").Limit(limit)
+	if mailAddress := r.URL.Query().Get("mailAddress"); mailAddress != "" {
+		query = query.Where("mail_address = ?", mailAddress)
+	}
+
+	invitations := []Invitation{}
+	if err := query.Find(&invitations).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]invitationResponse, 0, len(invitations))
+	for _, invitation := range invitations {
+		response = append(response, newInvitationResponse(invitation))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeInvitationHandler withdraws an invitation that was not accepted yet
+// @Summary Revoke an invitation
+// @Description Makes the link of an open invitation stop working.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{ID uint `json:"id"`} true "ID of the invitation"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Invitation not found"
+// @Failure 409 {string} string "Invitation already accepted or revoked"
+// @Failure 500 {string} string "Database error"
+// @Router /invitations [delete]
+func (app *Config) RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		ID uint `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.ID == 0 {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	var invitation Invitation
+	if err := app.DB.First(&invitation, requestData.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrInvitationNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	result := app.DB.Model(&Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Invitation already accepted or revoked", http.StatusConflict)
+		return
+	}
+	caller, _ := authUserFromContext(r.Context())
+	app.recordSecurityEvent(EventInvitationRevoked, caller.Username, clientIP(r), "revoked invitation of "+invitation.MailAddress)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation revoked"})
+}
+
+// AcceptInvitationHandler creates the account of an invited user
+// @Summary Accept an invitation
+// @Description Creates an activated account with the mail address and role of the invitation and the chosen username and password. The invitation works once.
+// @Description The mail address counts as verified, as the invitation was sent to it. Users of roles with mandatory 2FA enroll at their first login.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{Token string `json:"token"`; Username string `json:"username"`; Password string `json:"password"`} true "Invitation token, username and password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body, invalid or expired invitation or password rejected by the policy"
+// @Failure 409 {string} string "User already exists"
+// @Failure 500 {string} string "Database error or hashing error"
+// @Router /invitations/accept [post]
+func (app *Config) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.Token == "" {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestData.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := openInvitation(app.DB, requestData.Token, time.Now())
+	if errors.Is(err, errInvitationInvalid) {
+		http.Error(w, ErrInvalidInvitation, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := app.validateNewPassword(requestData.Password, 0, "", requestData.Username, invitation.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+	var existing int64
+	if err := app.DB.Unscoped().Model(&User{}).Where("username = ? OR mail_address = ?", requestData.Username, invitation.MailAddress).Count(&existing).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if existing > 0 {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	hashedPassword, err := app.HashPassword(requestData.Password)
+	if err != nil {
+		http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
+		return
+	}
+
+	user := User{
+		Username:    requestData.Username,
+		MailAddress: invitation.MailAddress,
+		Password:    hashedPassword,
+		Role:        invitation.Role,
+		Activated:   true,
+	}
+	// The invitation is used up in the same transaction, so two requests racing with it create one account
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvitationInvalid
+		}
+		return tx.Create(&user).Error
+	})
+	if errors.Is(err, errInvitationInvalid) {
+		http.Error(w, ErrInvalidInvitation, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ErrInsertingUser, http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventInvitationAccepted, user.Username, clientIP(r), fmt.Sprintf("invited by %s as %s", invitation.InvitedBy, user.Role))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":     "Account created, you can log in now",
+		"username":    user.Username,
+		"mailAddress": user.MailAddress,
+		"role":        user.Role,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// invitationToken returns the token of the newest invitation the test app has emailed
+func invitationToken(t *testing.T, app *Config) string {
+	matches := linkTokenPattern.FindAllStringSubmatch(sentMail(t, app), -1)
+	require.NotEmpty(t, matches, "No invitation was sent")
+	return matches[len(matches)-1][1]
+}
+
+// Test the whole flow: an Admin invites a rep who creates an activated account with the invited address and role
+func TestInvitation(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	token := bearer(t, app, admin)
+
+	rec := postJSON(router, "/invitations", bearer(t, app, rep), `{"mailAddress":"new@example.com"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Reps cannot invite")
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"rep@example.com"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"new@example.com","expires_in":"9999h"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Inviting the address again revokes the first link
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"new@example.com"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	first := invitationToken(t, app)
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"new@example.com","expires_in":"48h"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	second := invitationToken(t, app)
+	assert.Contains(t, sentMail(t, app), "To: new@example.com")
+
+	rec = postJSON(router, "/invitations/accept", "", `{"token":"`+first+`","username":"newrep","password":"Password123"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = postJSON(router, "/invitations/accept", "", `{"token":"`+second+`","username":"newrep","password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "The password policy applies")
+	rec = postJSON(router, "/invitations/accept", "", `{"token":"`+second+`","username":"newrep","password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = postJSON(router, "/invitations/accept", "", `{"token":"`+second+`","username":"another","password":"Password123"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "Invitations work once")
+
+	var stored User
+	require.NoError(t, app.DB.Where("username = ?", "newrep").First(&stored).Error)
+	assert.Equal(t, "new@example.com", stored.MailAddress)
+	assert.Equal(t, RoleSalesRep, stored.Role)
+	assert.True(t, stored.Activated)
+	assert.False(t, stored.VerificationPending)
+	login(t, router, "newrep", "Password123")
+
+	var invitations []Invitation
+	require.NoError(t, app.DB.Order("id").Find(&invitations).Error)
+	require.Len(t, invitations, 2)
+	assert.NotNil(t, invitations[0].RevokedAt)
+	assert.NotNil(t, invitations[1].AcceptedAt)
+	assert.Equal(t, "admin", invitations[1].InvitedBy)
+}
+
+// enableSelfRegistration opts in to open registration for the test, it is off by default
+func enableSelfRegistration(t *testing.T) {
+	SelfRegistration = true
+	t.Cleanup(func() { SelfRegistration = false })
+}
+
+// Test that without self-registration, the default, only Admins register and an Admin can revoke invitations
+func TestSelfRegistrationDisabled(t *testing.T) {
+	require.False(t, SelfRegistration, "Self-registration is opt-in")
+	app := newTestApp(t)
+	router := app.routes()
+
+	rec := postJSON(router, "/register", "", `{"username":"rep","mailAddress":"rep@example.com","password":"Password123"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrSelfRegistrationDisabled)
+
+	// Even on an empty system Admins need an Admin token, the first one is created by BootstrapAdmin
+	rec = postJSON(router, "/register", "", `{"username":"admin","mailAddress":"admin@example.com","password":"Password123","role":"Admin"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	other := createTestUser(t, app, "other", "Password123", RoleAdmin)
+	token := bearer(t, app, other)
+	rec = postJSON(router, "/register", token, `{"username":"admin","mailAddress":"admin@example.com","password":"Password123","role":"Admin"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = postJSON(router, "/invitations", token, `{"mailAddress":"rep@example.com"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	id := decodeBody(t, rec)["id"]
+	invitation := invitationToken(t, app)
+
+	req := httptest.NewRequest(http.MethodGet, "/invitations?mailAddress=rep@example.com", nil)
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"Role":"Sales Representative"`)
+	assert.NotContains(t, rec.Body.String(), "TokenHash")
+
+	req = httptest.NewRequest(http.MethodDelete, "/invitations", strings.NewReader(fmt.Sprintf(`{"id":%v}`, id)))
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = postJSON(router, "/invitations/accept", "", `{"token":"`+invitation+`","username":"rep","password":"Password123"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
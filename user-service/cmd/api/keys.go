@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Algorithms access tokens can be signed with
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// jwksMaxAge is how long clients may cache the JWKS. Verifiers refetch it early when they see an unknown kid.
+const jwksMaxAge = 5 * time.Minute
+
+// ErrUnknownSigningKey is returned for tokens whose kid names no key of the KeyManager
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// signingKey is a private key access tokens are signed with
+type signingKey struct {
+	ID        string // kid header of the tokens signed with the key
+	Alg       string
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// KeyManager holds the keys user-service signs access tokens with. The newest key signs, older keys
+// are still published in the JWKS and accepted until every token they signed has expired.
+type KeyManager struct {
+	Dir     string        // Where keys are stored as PEM files, empty keeps them in memory only
+	Alg     string        // Algorithm of newly generated keys
+	Overlap time.Duration // How long a replaced key keeps verifying tokens
+
+	mu   sync.RWMutex
+	keys []*signingKey // Oldest first, the last key signs
+}
+
+// NewKeyManager loads the keys stored in dir and generates a first key if there is none
+func NewKeyManager(dir, alg string, overlap time.Duration) (*KeyManager, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q, use %s or %s", alg, AlgRS256, AlgEdDSA)
+	}
+
+	km := &KeyManager{Dir: dir, Alg: alg, Overlap: overlap}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := km.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(km.keys) == 0 {
+		if err := km.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return km, nil
+}
+
+// pemCreatedHeader is the PEM header holding the creation time of a stored key
+const pemCreatedHeader = "Created"
+
+// load reads every *.pem file of the key directory
+func (km *KeyManager) load() error {
+	paths, err := filepath.Glob(filepath.Join(km.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s: no PEM data", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("%s: unsupported key type", path)
+		}
+		createdAt, err := time.Parse(time.RFC3339Nano, block.Headers[pemCreatedHeader])
+		if err != nil {
+			return fmt.Errorf("%s: missing or invalid %s header", path, pemCreatedHeader)
+		}
+
+		key, err := newSigningKey(signer, createdAt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		km.keys = append(km.keys, key)
+	}
+
+	sort.Slice(km.keys, func(i, j int) bool { return km.keys[i].CreatedAt.Before(km.keys[j].CreatedAt) })
+	return nil
+}
+
+// newSigningKey derives the algorithm and key ID of a private key
+func newSigningKey(private crypto.Signer, createdAt time.Time) (*signingKey, error) {
+	var alg string
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		alg = AlgRS256
+	case ed25519.PrivateKey:
+		alg = AlgEdDSA
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	// The key ID is derived from the public key, so it stays the same across restarts
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &signingKey{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Alg:       alg,
+		Private:   private,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// Rotate generates a new signing key. The previous key keeps verifying tokens for Overlap.
+func (km *KeyManager) Rotate() error {
+	var private crypto.Signer
+	var err error
+	if km.Alg == AlgEdDSA {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := newSigningKey(private, time.Now())
+	if err != nil {
+		return err
+	}
+	if km.Dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return err
+		}
+		data := pem.EncodeToMemory(&pem.Block{
+			Type:    "PRIVATE KEY",
+			Headers: map[string]string{pemCreatedHeader: key.CreatedAt.Format(time.RFC3339Nano)},
+			Bytes:   der,
+		})
+		if err := os.WriteFile(km.keyPath(key), data, 0o600); err != nil {
+			return err
+		}
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.keys = append(km.keys, key)
+	km.prune(time.Now())
+	return nil
+}
+
+// prune drops the keys that were replaced more than Overlap ago. The caller must hold km.mu.
+func (km *KeyManager) prune(now time.Time) {
+	kept := km.keys[:0]
+	for i, key := range km.keys {
+		// A key stopped signing when its successor was created
+		if i < len(km.keys)-1 && now.Sub(km.keys[i+1].CreatedAt) > km.Overlap {
+			if km.Dir != "" {
+				if err := os.Remove(km.keyPath(key)); err != nil && !os.IsNotExist(err) {
+					log.Printf("⚠️ Failed to remove retired signing key %s: %v", key.ID, err)
+				}
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	km.keys = kept
+}
+
+// keyPath returns the file a key is stored in
+func (km *KeyManager) keyPath(key *signingKey) string {
+	return filepath.Join(km.Dir, key.ID+".pem")
+}
+
+// StartRotation replaces the signing key once it is older than interval and drops retired keys
+func (km *KeyManager) StartRotation(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			km.mu.Lock()
+			km.prune(time.Now())
+			current := km.keys[len(km.keys)-1]
+			km.mu.Unlock()
+
+			if time.Since(current.CreatedAt) < interval {
+				continue
+			}
+			if err := km.Rotate(); err != nil {
+				log.Printf("⚠️ Failed to rotate signing key: %v", err)
+				continue
+			}
+			fmt.Println("🔑 Rotated JWT signing key")
+		}
+	}()
+}
+
+// Sign signs the claims with the current key and names the key in the kid header
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	km.mu.RLock()
+	key := km.keys[len(km.keys)-1]
+	km.mu.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc returns the public key a token names in its kid header, for use with jwt.Parse.
+// Tokens without a known kid or with an algorithm that does not match the key are rejected.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	for _, key := range km.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Alg {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.Private.Public(), nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // Curve of OKP keys
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of every key that still verifies tokens
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Alg}
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler publishes the public keys tokens are verified with
+// @Summary JSON Web Key Set
+// @Description Returns the public keys access tokens are signed with. Tokens name their key in the kid header.
+// @Description A replaced key stays listed until every token it signed has expired.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} JWKSet
+// @Router /.well-known/jwks.json [get]
+func (app *Config) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(app.Keys.JWKS())
+}
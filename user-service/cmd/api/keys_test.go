@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that a replaced key keeps verifying its tokens for the overlap and is dropped afterwards
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := NewKeyManager(dir, AlgEdDSA, time.Hour)
+	require.NoError(t, err)
+
+	verify := func(tokenString string) error {
+		_, err := jwt.Parse(tokenString, keys.Keyfunc)
+		return err
+	}
+
+	before, err := keys.Sign(jwt.MapClaims{"username": "alice"})
+	require.NoError(t, err)
+	require.NoError(t, keys.Rotate())
+	after, err := keys.Sign(jwt.MapClaims{"username": "alice"})
+	require.NoError(t, err)
+
+	assert.NoError(t, verify(before), "Tokens of the replaced key are accepted during the overlap")
+	assert.NoError(t, verify(after))
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	// A restart picks up both keys and keeps signing with the newest one
+	reloaded, err := NewKeyManager(dir, AlgEdDSA, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, keys.JWKS(), reloaded.JWKS())
+	token, _ := jwt.Parse(after, keys.Keyfunc)
+	again, err := reloaded.Sign(jwt.MapClaims{"username": "alice"})
+	require.NoError(t, err)
+	tokenAgain, _ := jwt.Parse(again, reloaded.Keyfunc)
+	assert.Equal(t, token.Header["kid"], tokenAgain.Header["kid"])
+
+	// Once the overlap has passed the old key is gone, from memory and from disk
+	keys.mu.Lock()
+	keys.prune(time.Now().Add(2 * time.Hour))
+	keys.mu.Unlock()
+	assert.Error(t, verify(before))
+	assert.NoError(t, verify(after))
+	assert.Len(t, keys.JWKS().Keys, 1)
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+// Test that only tokens signed by a published key with its own algorithm are accepted
+func TestKeyfuncRejectsForeignTokens(t *testing.T) {
+	keys, err := NewKeyManager("", AlgEdDSA, time.Hour)
+	require.NoError(t, err)
+	valid, err := keys.Sign(jwt.MapClaims{"username": "alice"})
+	require.NoError(t, err)
+	parsed, _ := jwt.Parse(valid, keys.Keyfunc)
+	kid := parsed.Header["kid"]
+
+	// HS256 token naming the right key, signed with the public key as secret
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "alice"})
+	hmacToken.Header["kid"] = kid
+	forged, err := hmacToken.SignedString([]byte(keys.JWKS().Keys[0].X))
+	require.NoError(t, err)
+	_, err = jwt.Parse(forged, keys.Keyfunc)
+	assert.Error(t, err)
+
+	// Token signed by a key that was never published
+	other, err := NewKeyManager("", AlgEdDSA, time.Hour)
+	require.NoError(t, err)
+	foreign, err := other.Sign(jwt.MapClaims{"username": "alice"})
+	require.NoError(t, err)
+	_, err = jwt.Parse(foreign, keys.Keyfunc)
+	assert.Error(t, err)
+}
+
+// Test that RS256 access tokens verify with nothing but the published JWKS
+func TestJWKSHandler(t *testing.T) {
+	app := newTestApp(t)
+	keys, err := NewKeyManager("", AlgRS256, time.Hour)
+	require.NoError(t, err)
+	app.Keys = keys
+	router := app.routes()
+	createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	tokens := login(t, router, "rep", "Password123")
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var set JWKSet
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	jwk := set.Keys[0]
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, AlgRS256, jwk.Alg)
+
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	require.NoError(t, err)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	token, err := jwt.Parse(tokens.AccessToken, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, jwk.Kid, token.Header["kid"])
+		return public, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "rep", token.Claims.(jwt.MapClaims)["username"])
+}
+
+// Test that unreadable key files stop the service instead of silently generating a new key
+func TestNewKeyManagerRejectsBadKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0o600))
+
+	_, err := NewKeyManager(dir, AlgRS256, time.Hour)
+	assert.Error(t, err)
+
+	// Key files always carry their creation time
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "undated.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	_, err = NewKeyManager(dir, AlgEdDSA, time.Hour)
+	assert.ErrorContains(t, err, "Created")
+
+	_, err = NewKeyManager("", "HS256", time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent
+const (
+	EventLoginFailed     = "login_failed"     // Wrong password or unknown username
+	EventLoginBlocked    = "login_blocked"    // Attempt refused during a backoff or lockout
+	EventAccountLocked   = "account_locked"   // MaxFailedLogins reached
+	EventAccountUnlocked = "account_unlocked" // Lockout lifted by an Admin
+)
+
+const (
+	loginBackoffBase = time.Second // Wait after the first failure, doubled for every further failure
+	ipFreeFailures   = 10          // Failures an IP address may make before it is slowed down
+)
+
+// ErrTooManyLoginAttempts is returned while an account or IP address has to wait before the next login
+const ErrTooManyLoginAttempts = "Too many failed login attempts, try again later"
+
+// loginBackoff returns how long to wait after the given number of consecutive failures
+// when the first free failures are not slowed down. It never exceeds LockoutDuration.
+func loginBackoff(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	exponent := failures - free - 1
+	if exponent > 30 {
+		return LockoutDuration
+	}
+	delay := loginBackoffBase << exponent
+	if delay > LockoutDuration {
+		return LockoutDuration
+	}
+	return delay
+}
+
+// loginRetryAfter returns how long the user has to wait before the next login attempt
+func (user User) loginRetryAfter(now time.Time) time.Duration {
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return user.LockedUntil.Sub(now)
+	}
+	if user.LockedUntil != nil || user.LastFailedLoginAt == nil {
+		return 0 // A lockout that has run out allows a fresh series of attempts
+	}
+	return user.LastFailedLoginAt.Add(loginBackoff(user.FailedLoginAttempts, 0)).Sub(now)
+}
+
+// recordLoginFailure counts a wrong password and locks the account once MaxFailedLogins is reached.
+// It reports whether the account was locked.
+func (app *Config) recordLoginFailure(user User, now time.Time) (bool, error) {
+	locked := false
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		// A lockout that has run out starts a fresh series of attempts
+		attempts := gorm.Expr("failed_login_attempts + 1")
+		if user.LockedUntil != nil {
+			attempts = gorm.Expr("1")
+		}
+		err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"failed_login_attempts": attempts,
+			"last_failed_login_at":  now,
+			"locked_until":          nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var failures int
+		if err := tx.Model(&User{}).Select("failed_login_attempts").Where("id = ?", user.ID).Scan(&failures).Error; err != nil {
+			return err
+		}
+		if failures < MaxFailedLogins {
+			return nil
+		}
+		locked = true
+		return tx.Model(&User{}).Where("id = ?", user.ID).Update("locked_until", now.Add(LockoutDuration)).Error
+	})
+	return locked, err
+}
+
+// resetLoginFailures clears the failed attempts and lockout of a user
+func resetLoginFailures(tx *gorm.DB, userID uint) error {
+	return tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+}
+
+// recordSecurityEvent stores an event for review. Failing to store it must not fail the request.
+func (app *Config) recordSecurityEvent(eventType, username, ip, detail string) {
+	event := SecurityEvent{Type: eventType, Username: username, IPAddress: ip, Detail: detail}
+	if err := app.DB.Create(&event).Error; err != nil {
+		log.Printf("⚠️ Failed to record security event %s for %s: %v", eventType, username, err)
+	}
+}
+
+// writeTooManyAttempts rejects a login attempt made during a backoff or lockout
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
+}
+
+// clientIP returns the address the request came from. Forwarding headers are ignored as
+// clients could set them to dodge the per-IP throttling.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipFailures counts the failed logins of an IP address or an unknown username
+type ipFailures struct {
+	count int
+	last  time.Time
+}
+
+// LoginThrottle slows down IP addresses that fail to log in repeatedly, whichever accounts
+// they try. It also slows down and locks usernames that belong to no user the way
+// accounts are, so the answers do not tell which usernames exist. Failures are forgotten
+// LockoutDuration after the last one.
+type LoginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*ipFailures // By IP address
+	unknown  map[string]*ipFailures // By unknown username
+	pruned   time.Time
+}
+
+// NewLoginThrottle returns an empty LoginThrottle
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{failures: map[string]*ipFailures{}, unknown: map[string]*ipFailures{}}
+}
+
+// RetryAfter returns how long the IP address has to wait before the next login attempt
+func (t *LoginThrottle) RetryAfter(ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.failures[ip]
+	if !ok || now.Sub(entry.last) > LockoutDuration {
+		return 0
+	}
+	return entry.last.Add(loginBackoff(entry.count, ipFreeFailures)).Sub(now)
+}
+
+// UnknownRetryAfter returns how long an unknown username has to wait before the next login
+// attempt, with the same backoff and lockout as accounts
+func (t *LoginThrottle) UnknownRetryAfter(name string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.unknown[name]
+	if !ok || now.Sub(entry.last) > LockoutDuration {
+		return 0
+	}
+	if entry.count >= MaxFailedLogins {
+		return entry.last.Add(LockoutDuration).Sub(now)
+	}
+	return entry.last.Add(loginBackoff(entry.count, 0)).Sub(now)
+}
+
+// Fail counts a failed login of the IP address
+func (t *LoginThrottle) Fail(ip string, now time.Time) {
+	t.fail(t.failures, ip, now)
+}
+
+// FailUnknown counts a failed login with a username that belongs to no user
+func (t *LoginThrottle) FailUnknown(name string, now time.Time) {
+	t.fail(t.unknown, name, now)
+}
+
+func (t *LoginThrottle) fail(failures map[string]*ipFailures, key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Drop forgotten entries now and then so the maps do not grow forever
+	if now.Sub(t.pruned) > time.Minute {
+		for _, entries := range []map[string]*ipFailures{t.failures, t.unknown} {
+			for key, entry := range entries {
+				if now.Sub(entry.last) > LockoutDuration {
+					delete(entries, key)
+				}
+			}
+		}
+		t.pruned = now
+	}
+
+	entry, ok := failures[key]
+	if !ok || now.Sub(entry.last) > LockoutDuration {
+		entry = &ipFailures{}
+		failures[key] = entry
+	}
+	entry.count++
+	entry.last = now
+}
+
+// UnlockUserHandler lifts the lockout of a user
+// @Summary Unlock a user
+// @Description Clears the failed login attempts and lockout of a user so they can log in again at once
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param requestBody body struct{Username string `json:"username"`} true "Username of the user to unlock"
+// @Success 200 {object} map[string]string "User unlocked successfully"
+// @Failure 400 {string} string "Invalid request body or missing username"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Failed to unlock user"
+// @Router /unlock-user [put]
+func (app *Config) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestBody.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.Where("username = ?", requestBody.Username).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, ErrUserNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := resetLoginFailures(app.DB, user.ID); err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+	caller, _ := authUserFromContext(r.Context())
+	app.recordSecurityEvent(EventAccountUnlocked, user.Username, clientIP(r), "unlocked by "+caller.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "User unlocked successfully",
+		"username": user.Username,
+	})
+}
+
+// SecurityEventsHandler lists recorded security events, newest first
+// @Summary List security events
+// @Description Returns failed and blocked logins, lockouts, unlocks, 2FA changes, password resets, email verifications, invitations, session revocations and deleted or restored users, newest first
+// @Tags users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param username query string false "Only events of this username"
+// @Param type query string false "Only events of this type: login_failed, login_blocked, account_locked, account_unlocked, 2fa_enabled, 2fa_disabled, 2fa_reset, password_reset_requested, password_reset, verification_sent, email_verified, invitation_sent, invitation_accepted, invitation_revoked, session_revoked, sessions_revoked, user_deleted or user_restored"
+// @Param limit query int false "Maximum number of events, 100 by default and at most 1000"
+// @Success 200 {array} securityEventResponse
+// @Failure 400 {string} string "Invalid limit"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Database error"
+// @Router /security-events [get]
+func (app *Config) SecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	query := app.DB.Order("created_at DESC, id DESC").Limit(limit)
+	if username := r.URL.Query().Get("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if eventType := r.URL.Query().Get("type"); eventType != "" {
+		query = query.Where("type = ?", eventType)
+	}
+
+	events := []SecurityEvent{}
+	if err := query.Find(&events).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]securityEventResponse, 0, len(events))
+	for _, event := range events {
+		response = append(response, newSecurityEventResponse(event))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endBackoff moves the user's last failure into the past, as if the backoff had been waited out
+func endBackoff(t *testing.T, app *Config, username string) {
+	require.NoError(t, app.DB.Model(&User{}).Where("username = ?", username).
+		Update("last_failed_login_at", time.Now().Add(-time.Hour)).Error)
+}
+
+// Test the exponential backoff
+func TestLoginBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), loginBackoff(0, 0))
+	assert.Equal(t, time.Second, loginBackoff(1, 0))
+	assert.Equal(t, 8*time.Second, loginBackoff(4, 0))
+	assert.Equal(t, time.Duration(0), loginBackoff(10, 10), "Free failures are not slowed down")
+	assert.Equal(t, 2*time.Second, loginBackoff(12, 10))
+	assert.Equal(t, LockoutDuration, loginBackoff(100, 0), "The backoff never exceeds the lockout")
+}
+
+// Test that failures slow the account down, lock it after MaxFailedLogins and that an Admin can unlock it
+func TestAccountLockout(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	wrong := `{"username":"rep","password":"Wrong123"}`
+	right := `{"username":"rep","password":"Password123"}`
+
+	rec := postJSON(router, "/login", "", wrong)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The next attempt has to wait, even with the right password
+	rec = postJSON(router, "/login", "", right)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	for i := 2; i <= MaxFailedLogins; i++ {
+		endBackoff(t, app, "rep")
+		rec = postJSON(router, "/login", "", wrong)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// Locked: waiting out the backoff is not enough
+	endBackoff(t, app, "rep")
+	rec = postJSON(router, "/login", "", right)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	var locked int64
+	app.DB.Model(&SecurityEvent{}).Where("type = ? AND username = ?", EventAccountLocked, "rep").Count(&locked)
+	assert.Equal(t, int64(1), locked)
+
+	req := httptest.NewRequest(http.MethodPut, "/unlock-user", strings.NewReader(`{"username":"rep"}`))
+	req.Header.Set("Authorization", bearer(t, app, admin))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	login(t, router, "rep", "Password123")
+	var stored User
+	require.NoError(t, app.DB.Where("username = ?", "rep").First(&stored).Error)
+	assert.Zero(t, stored.FailedLoginAttempts)
+	assert.Nil(t, stored.LockedUntil)
+}
+
+// Test that a successful login forgets earlier failures and that an expired lockout starts over
+func TestLockoutExpires(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, app.DB.Model(&rep).Updates(map[string]interface{}{
+		"failed_login_attempts": MaxFailedLogins,
+		"last_failed_login_at":  past.Add(-LockoutDuration),
+		"locked_until":          past,
+	}).Error)
+
+	// One more wrong password after the lockout ran out does not lock the account again
+	rec := postJSON(router, "/login", "", `{"username":"rep","password":"Wrong123"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	var stored User
+	require.NoError(t, app.DB.First(&stored, rep.ID).Error)
+	assert.Equal(t, 1, stored.FailedLoginAttempts)
+	assert.Nil(t, stored.LockedUntil)
+
+	endBackoff(t, app, "rep")
+	login(t, router, "rep", "Password123")
+	require.NoError(t, app.DB.First(&stored, rep.ID).Error)
+	assert.Zero(t, stored.FailedLoginAttempts)
+}
+
+// Test that unknown usernames cannot be told apart from wrong passwords
+func TestLoginErrorsAreUniform(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+
+	unknown := postJSON(router, "/login", "", `{"username":"nobody","password":"Wrong123"}`)
+	wrong := postJSON(router, "/login", "", `{"username":"rep","password":"Wrong123"}`)
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, wrong.Code, unknown.Code)
+	assert.Equal(t, wrong.Body.String(), unknown.Body.String())
+
+	// Unknown usernames back off like accounts, so a quick second attempt does not tell them apart either
+	unknown = postJSON(router, "/login", "", `{"username":"nobody","password":"Wrong123"}`)
+	wrong = postJSON(router, "/login", "", `{"username":"rep","password":"Wrong123"}`)
+	assert.Equal(t, http.StatusTooManyRequests, unknown.Code)
+	assert.Equal(t, wrong.Code, unknown.Code)
+	assert.Equal(t, wrong.Header().Get("Retry-After"), unknown.Header().Get("Retry-After"))
+	assert.Equal(t, wrong.Body.String(), unknown.Body.String())
+}
+
+// Test that unknown usernames are slowed down and locked like accounts
+func TestLoginThrottleUnknown(t *testing.T) {
+	throttle := NewLoginThrottle()
+	now := time.Now()
+
+	throttle.FailUnknown("nobody", now)
+	assert.Equal(t, time.Second, throttle.UnknownRetryAfter("nobody", now))
+	assert.Equal(t, time.Duration(0), throttle.UnknownRetryAfter("somebody", now))
+	for i := 1; i < MaxFailedLogins; i++ {
+		throttle.FailUnknown("nobody", now)
+	}
+	assert.Equal(t, LockoutDuration, throttle.UnknownRetryAfter("nobody", now), "Locked like an account")
+	assert.Equal(t, time.Duration(0), throttle.UnknownRetryAfter("nobody", now.Add(LockoutDuration+time.Second)), "Failures are forgotten")
+}
+
+// Test that an IP address failing on many accounts is slowed down
+func TestLoginThrottle(t *testing.T) {
+	throttle := NewLoginThrottle()
+	now := time.Now()
+
+	for i := 0; i < ipFreeFailures; i++ {
+		throttle.Fail("192.0.2.1", now)
+	}
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.1", now))
+
+	throttle.Fail("192.0.2.1", now)
+	assert.Equal(t, time.Second, throttle.RetryAfter("192.0.2.1", now))
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.2", now), "Other addresses are not affected")
+	assert.Equal(t, time.Duration(0), throttle.RetryAfter("192.0.2.1", now.Add(LockoutDuration+time.Second)), "Failures are forgotten")
+
+	// Through the handler: after the free failures unknown usernames are throttled too
+	app := newTestApp(t)
+	router := app.routes()
+	for i := 0; i < ipFreeFailures; i++ {
+		postJSON(router, "/login", "", fmt.Sprintf(`{"username":"nobody%d","password":"Wrong123"}`, i))
+	}
+	rec := postJSON(router, "/login", "", `{"username":"somebody","password":"Wrong123"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = postJSON(router, "/login", "", `{"username":"anybody","password":"Wrong123"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+// Test that only Admins can review security events
+func TestSecurityEventsHandler(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	postJSON(router, "/login", "", `{"username":"rep","password":"Wrong123"}`)
+	postJSON(router, "/login", "", `{"username":"nobody","password":"Wrong123"}`)
+
+	get := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/security-events"+query, nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, get(bearer(t, app, rep), "").Code)
+
+	rec := get(bearer(t, app, admin), "?username=rep")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var events []SecurityEvent
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&events))
+	require.Len(t, events, 1)
+	assert.Equal(t, EventLoginFailed, events[0].Type)
+	assert.NotEmpty(t, events[0].IPAddress)
+
+	assert.Equal(t, http.StatusBadRequest, get(bearer(t, app, admin), "?limit=0").Code)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Reasons of a LoginEvent. Successful logins name the last step the user passed.
+const (
+	LoginReasonPassword         = "password"              // Logged in with the password alone
+	LoginReasonTwoFactor        = "two_factor"            // Logged in with a TOTP or recovery code
+	LoginReasonTwoFactorEnroll  = "two_factor_enrollment" // Logged in by confirming the mandatory 2FA enrollment
+	LoginReasonUnknownUsername  = "unknown_username"
+	LoginReasonWrongPassword    = "wrong_password"
+	LoginReasonWrongCode        = "wrong_two_factor_code"
+	LoginReasonIPThrottled      = "ip_throttled"   // The client IP address had to wait after earlier failures
+	LoginReasonAccountThrottled = "account_locked" // The account had to wait or was locked out
+	LoginReasonNotVerified      = "email_not_verified"
+	LoginReasonDeactivated      = "account_deactivated"
+)
+
+const (
+	loginHistoryPruneInterval = time.Hour // How often login events older than LoginHistoryRetention are deleted
+	maxUserAgentLength        = 512       // Longer User-Agent headers are cut off
+)
+
+// recordLoginEvent stores a login attempt in the login history. userID is 0 when the username does not
+// belong to a user. A failure to store it is only logged, it must not break the login.
+func (app *Config) recordLoginEvent(r *http.Request, userID uint, username string, success bool, reason string) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	event := LoginEvent{Username: username, IPAddress: clientIP(r), UserAgent: userAgent, Success: success, Reason: reason}
+	if userID != 0 {
+		event.UserID = &userID
+	}
+	if err := app.DB.Create(&event).Error; err != nil {
+		log.Printf("⚠️ Failed to record login event for %s: %v", username, err)
+	}
+}
+
+// pruneLoginEvents deletes the login events recorded before the retention window and returns how many
+func pruneLoginEvents(db *gorm.DB, now time.Time, retention time.Duration) (int64, error) {
+	result := db.Where("created_at < ?", now.Add(-retention)).Delete(&LoginEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// StartLoginHistoryPruning deletes login events older than retention at startup and then once an hour
+func (app *Config) StartLoginHistoryPruning(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(loginHistoryPruneInterval)
+		defer ticker.Stop()
+		for {
+			deleted, err := pruneLoginEvents(app.DB, time.Now(), retention)
+			if err != nil {
+				log.Printf("⚠️ Failed to prune the login history: %v", err)
+			} else if deleted > 0 {
+				fmt.Printf("🧹 Deleted %d login events older than %s\n", deleted, retention)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// UserLoginsHandler lists the login history of a user
+// @Summary Login history of a user
+// @Description Lists the successful and failed logins of the user identified by id, newest first. Results are paginated with an opaque cursor; the next page is advertised in the Link header.
+// @Description Attempts with a username that belongs to no user are only listed by GET /security-events.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param id path int true "User ID"
+// @Param success query bool false "Only successful (true) or failed (false) logins"
+// @Param created_after query string false "Only logins after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page's Link header"
+// @Success 200 {array} loginEventResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Database error"
+// @Router /users/{id}/logins [get]
+func (app *Config) UserLoginsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrUserNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.listLoginEvents(w, r, user.ID)
+}
+
+// MyLoginsHandler lists the login history of the caller
+// @Summary Own login history
+// @Description Lists the successful and failed logins of the caller, newest first. Accepts the same query parameters as GET /users/{id}/logins.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Success 200 {array} loginEventResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 500 {string} string "Database error"
+// @Router /me/logins [get]
+func (app *Config) MyLoginsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+	app.listLoginEvents(w, r, caller.ID)
+}
+
+// listLoginEvents writes one page of the login history of a user, newest first
+func (app *Config) listLoginEvents(w http.ResponseWriter, r *http.Request, userID uint) {
+	params := r.URL.Query()
+	sort, _ := parseSort("", "-created_at", loginEventSortFields)
+	limit, err := parsePageSize(params.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Apply filters
+	query := app.DB.Model(&LoginEvent{}).Where("user_id = ?", userID)
+	if success := params.Get("success"); success != "" {
+		flag, err := strconv.ParseBool(success)
+		if err != nil {
+			http.Error(w, "Invalid success", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("success = ?", flag)
+	}
+	if createdAfter := params.Get("created_after"); createdAfter != "" {
+		t, err := parseDateParam(createdAfter)
+		if err != nil {
+			http.Error(w, "Invalid created_after", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at > ?", t)
+	}
+
+	// Continue after the cursor, if one was given
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw, sort)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clause, args, err := sort.AfterCursor(cursor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(clause, args...)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var events []LoginEvent
+	if err := query.Order(sort.OrderClause()).Limit(limit + 1).Find(&events).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[len(events)-1]
+		next = encodeCursor(pageCursor{Sort: sort.Key, Value: last.CreatedAt.Format(time.RFC3339Nano), ID: last.ID})
+	}
+
+	response := make([]loginEventResponse, 0, len(events))
+	for _, event := range events {
+		response = append(response, newLoginEventResponse(event))
+	}
+
+	// Send JSON response with pagination links
+	setPaginationLinks(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
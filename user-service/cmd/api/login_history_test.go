@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginHistory sends GET to path and returns the decoded login events and the next page link
+func loginHistory(t *testing.T, router http.Handler, token, path string) ([]loginEventResponse, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var events []loginEventResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&events))
+	next := ""
+	if match := nextLinkPattern.FindStringSubmatch(rec.Header().Get("Link")); match != nil {
+		next = match[1]
+	}
+	return events, next
+}
+
+// Test that successful and failed logins are recorded and listed to the user and to Admins
+func TestLoginHistory(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set("User-Agent", "history-test/1.0")
+	pair := login(t, router, "rep", "Password123")
+	rec := postJSON(router, "/login", "", `{"username":"rep","password":"wrong"}`)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	postJSON(router, "/login", "", `{"username":"nobody","password":"wrong"}`)
+	app.recordLoginEvent(req, rep.ID, rep.Username, false, LoginReasonDeactivated)
+
+	events, next := loginHistory(t, router, "Bearer "+pair.AccessToken, "/me/logins")
+	require.Len(t, events, 3, "Unknown usernames are not in anybody's history")
+	assert.Empty(t, next)
+	assert.Equal(t, LoginReasonDeactivated, events[0].Reason)
+	assert.Equal(t, "history-test/1.0", events[0].UserAgent)
+	assert.False(t, events[1].Success)
+	assert.Equal(t, LoginReasonWrongPassword, events[1].Reason)
+	assert.NotEmpty(t, events[1].IPAddress)
+	assert.True(t, events[2].Success)
+	assert.Equal(t, LoginReasonPassword, events[2].Reason)
+
+	// Admins page through the history of any user
+	token := bearer(t, app, admin)
+	path := fmt.Sprintf("/users/%d/logins", rep.ID)
+	events, next = loginHistory(t, router, token, path+"?limit=2")
+	require.Len(t, events, 2)
+	require.NotEmpty(t, next)
+	older, next := loginHistory(t, router, token, next)
+	require.Len(t, older, 1)
+	assert.Empty(t, next)
+	assert.Equal(t, LoginReasonPassword, older[0].Reason)
+
+	events, _ = loginHistory(t, router, token, path+"?success=true")
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Success)
+
+	assert.Equal(t, http.StatusNotFound, getStatus(router, "/users/999/logins", token))
+	assert.Equal(t, http.StatusBadRequest, getStatus(router, path+"?success=maybe", token))
+	assert.Equal(t, http.StatusForbidden, getStatus(router, fmt.Sprintf("/users/%d/logins", admin.ID), "Bearer "+pair.AccessToken))
+}
+
+// Test that events older than the retention window are deleted
+func TestPruneLoginEvents(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	require.NoError(t, app.DB.Create(&LoginEvent{Username: "old", Reason: LoginReasonPassword, CreatedAt: now.Add(-100 * 24 * time.Hour)}).Error)
+	require.NoError(t, app.DB.Create(&LoginEvent{Username: "new", Reason: LoginReasonPassword, CreatedAt: now.Add(-time.Hour)}).Error)
+
+	deleted, err := pruneLoginEvents(app.DB, now, 90*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	var left []LoginEvent
+	require.NoError(t, app.DB.Find(&left).Error)
+	require.Len(t, left, 1)
+	assert.Equal(t, "new", left[0].Username)
+}
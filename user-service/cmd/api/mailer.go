@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of Mailer selected by USER_SERVICE_MAILER
+const (
+	MailerSMTP = "smtp"
+	MailerFile = "file"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails such as password reset links
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer returns the Mailer configured in the environment
+func NewMailer(kind string) (Mailer, error) {
+	switch kind {
+	case MailerSMTP:
+		if SMTPHost == "" {
+			return nil, fmt.Errorf("USER_SERVICE_SMTP_HOST is required for the %s mailer", MailerSMTP)
+		}
+		return &SMTPMailer{Host: SMTPHost, Port: SMTPPort, Username: SMTPUsername, Password: SMTPPassword, From: MailFrom}, nil
+	case MailerFile:
+		return &FileMailer{Path: MailFile, From: MailFrom}, nil
+	}
+	return nil, fmt.Errorf("unknown mailer %q, use %s or %s", kind, MailerSMTP, MailerFile)
+}
+
+// formatMessage renders the message with its headers. Line breaks are removed from the
+// header values so a crafted address or subject cannot add headers.
+func formatMessage(from string, msg Message) string {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.String()
+}
+
+// SMTPMailer sends emails through an SMTP server. It uses STARTTLS when the server offers it
+// and authenticates only when Username is set.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, []byte(formatMessage(m.From, msg)))
+}
+
+// FileMailer appends emails to a file, or writes them to stdout when Path is empty, instead of
+// sending them. It is meant for local development and tests.
+type FileMailer struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+// Send writes the message followed by a separator line
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var w io.Writer = os.Stdout
+	if m.Path != "" {
+		f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := io.WriteString(w, formatMessage(m.From, msg)+"\r\n-----\r\n")
+	return err
+}
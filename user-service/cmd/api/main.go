@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -11,7 +12,17 @@ import (
 )
 
 type Config struct {
-	DB *gorm.DB
+	DB              *gorm.DB
+	Keys            *KeyManager    // Signs access tokens and publishes their public keys
+	LoginThrottle   *LoginThrottle // Failed logins per IP address
+	Mailer          Mailer         // Sends password reset and verification links
+	VerificationKey []byte         // Signs email verification links
+	Hasher          PasswordHasher // Hashes new passwords, see hasher.go
+
+	BreachedPasswords *BreachedPasswords // Refused as new passwords, nil without USER_SERVICE_BREACHED_PASSWORDS_FILE
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 // connectToDB retries connecting to PostgreSQL until it succeeds or fails after retries
@@ -38,7 +49,7 @@ func connectToDB() (*gorm.DB, error) {
 	}
 
 	// AutoMigrate to create tables
-	err = db.AutoMigrate(&User{})
+	err = db.AutoMigrate(&User{}, &Session{}, &RefreshToken{}, &RecoveryCode{}, &PasswordResetToken{}, &Invitation{}, &LoginEvent{}, &SecurityEvent{}, &PasswordHistory{})
 	if err != nil {
 		log.Fatalf("❌ Failed to migrate database: %v", err)
 	}
@@ -55,10 +66,61 @@ func main() {
 		log.Fatal("❌ Database connection failed:", err)
 	}
 
+	// Replaced keys keep verifying tokens until the last access token they signed has expired
+	keys, err := NewKeyManager(JWTKeyDir, JWTAlgorithm, AccessTokenTTL)
+	if err != nil {
+		log.Fatal("❌ Failed to load JWT signing keys:", err)
+	}
+	if JWTKeyDir == "" {
+		fmt.Println("⚠️ USER_SERVICE_JWT_KEY_DIR is not set, signing keys are lost on restart")
+	}
+	keys.StartRotation(JWTKeyRotation)
+
+	mailer, err := NewMailer(MailerKind)
+	if err != nil {
+		log.Fatal("❌ Failed to set up the mailer:", err)
+	}
+
+	verificationKey, err := LoadVerificationKey(EmailVerificationSecret)
+	if err != nil {
+		log.Fatal("❌ Failed to load the email verification key:", err)
+	}
+	if EmailVerificationSecret == "" {
+		fmt.Println("⚠️ USER_SERVICE_EMAIL_VERIFICATION_SECRET is not set, verification links stop working on restart")
+	}
+
+	hasher, err := NewPasswordHasher(PasswordHasherKind)
+	if err != nil {
+		log.Fatal("❌ Failed to set up the password hasher:", err)
+	}
+
+	if err := checkPasswordPolicy(); err != nil {
+		log.Fatal("❌ Invalid password policy:", err)
+	}
+	breached, err := LoadBreachedPasswords(BreachedPasswordsFile)
+	if err != nil {
+		log.Fatal("❌ Failed to load the breached password list:", err)
+	}
+	if breached != nil {
+		fmt.Printf("🔒 Loaded %d breached password hashes\n", breached.Len())
+	}
+
+	app := &Config{DB: db, Keys: keys, LoginThrottle: NewLoginThrottle(), Mailer: mailer, VerificationKey: verificationKey, Hasher: hasher, BreachedPasswords: breached}
+	created, err := app.BootstrapAdmin(BootstrapAdminUsername, BootstrapAdminMail, BootstrapAdminPassword)
+	if err != nil {
+		log.Fatal("❌ Failed to create the bootstrap Admin:", err)
+	}
+	if created {
+		fmt.Printf("👤 Created the bootstrap Admin %s\n", BootstrapAdminUsername)
+	}
+
+	app.StartLoginHistoryPruning(LoginHistoryRetention)
+	app.StartTrashPurging(TrashRetention)
+
 	fmt.Printf("🚀 %s is running on port: %s\n", ServiceName, ServicePort)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", ServicePort),
-		Handler: (&Config{DB: db}).routes(),
+		Handler: app.routes(),
 	}
 
 	err = srv.ListenAndServe()
@@ -1,16 +1,131 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User model for GORM
 type User struct {
-	ID          uint      `gorm:"primaryKey"`
-	Username    string    `gorm:"unique;not null"`
-	MailAddress string    `gorm:"unique;not null"`
-	Password    string    `gorm:"not null"`
-	Role        string    `gorm:"not null"` // Admin or Sales Representative
-	Activated   bool      `gorm:"default:false"`
-	LoginStatus bool      `gorm:"default:false"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	ID           uint   `gorm:"primaryKey"`
+	Username     string `gorm:"unique;not null"`
+	MailAddress  string `gorm:"unique;not null"`
+	Password     string `gorm:"not null"`
+	Role         string `gorm:"not null"` // Admin or Sales Representative, see permissions.go
+	Activated    bool   `gorm:"default:false"`
+	LoginStatus  bool   `gorm:"default:false"`
+	TokenVersion int    `gorm:"not null;default:0"` // Bumped to invalidate every token issued to the user
+
+	// Email verification, see email_verification.go
+	VerificationPending bool       `gorm:"not null;default:false"` // Set at registration, cleared by the emailed link which also activates the account
+	VerificationSentAt  *time.Time // Last verification email, limits resending
+	PendingMailAddress  string     // New address requested by /update-email, applied once the link emailed to it is opened
+
+	// Login throttling, see lockout.go
+	FailedLoginAttempts int        `gorm:"not null;default:0"` // Consecutive failed logins
+	LastFailedLoginAt   *time.Time // Start of the backoff after the last failure
+	LockedUntil         *time.Time // Set once MaxFailedLogins is reached, cleared by /unlock-user
+
+	// Two-factor authentication, see totp.go. The secret is never returned by the API.
+	TOTPSecret   string `gorm:"type:varchar(64)" json:"-"`   // Base32 secret, set by enrollment
+	TOTPEnabled  bool   `gorm:"not null;default:false"`      // Set once the first code of the secret is confirmed
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"` // Time step of the last accepted code, codes cannot be replayed
+
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"` // Set by /delete-user, deleted users are hidden until restored or purged, see trash.go
+}
+
+// Session is a login on one device, see sessions.go. Its refresh tokens share its SessionID.
+type Session struct {
+	ID          uint       `gorm:"primaryKey"`
+	SessionID   string     `gorm:"type:varchar(64);uniqueIndex;not null"` // Named by the "sid" claim of its access tokens
+	UserID      uint       `gorm:"index;not null"`
+	DeviceLabel string     `gorm:"type:varchar(128)"` // From the X-Device-Label header or guessed from the User-Agent
+	IPAddress   string     `gorm:"type:varchar(64)"`  // Client address of the login
+	UserAgent   string     `gorm:"type:varchar(512)"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+	LastSeenAt  time.Time  `gorm:"not null"` // Last authenticated request or refresh, updated at most once a minute
+	RevokedAt   *time.Time // Set on logout or revocation
+}
+
+// RefreshToken is a server-side refresh token. Rotating a token revokes it and stores its
+// successor under the same SessionID; access tokens name their session in the "sid" claim.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	SessionID string     `gorm:"type:varchar(64);index;not null"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null"` // SHA-256 of the token, the token itself is never stored
+	ExpiresAt time.Time  `gorm:"not null"`
+	RevokedAt *time.Time `gorm:"index"` // Set on rotation, logout, password change or deactivation
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the authenticator is lost.
+// Confirming an enrollment or regenerating the codes replaces all codes of the user.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	CodeHash  string     `gorm:"type:char(64);not null"` // SHA-256 of the normalized code, the code itself is never stored
+	UsedAt    *time.Time // Set when the code is used, it cannot be used again
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// PasswordResetToken is a single-use token emailed by /password/forgot. Requesting a new
+// token or resetting the password uses up the user's other tokens.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null"` // SHA-256 of the token, the token itself is never stored
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set when the token is used or replaced
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+// Invitation lets the invitee create an account with the invited mail address and role, see invitations.go.
+// Inviting the same address again revokes the earlier invitation.
+type Invitation struct {
+	ID          uint       `gorm:"primaryKey"`
+	MailAddress string     `gorm:"index;not null"`
+	Role        string     `gorm:"not null"`
+	TokenHash   string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"` // SHA-256 of the token, the token itself is never stored
+	InvitedBy   string     `gorm:"not null"`                                    // Username of the Admin who sent it
+	ExpiresAt   time.Time  `gorm:"not null"`
+	AcceptedAt  *time.Time // Set when the account is created, the invitation cannot be used again
+	RevokedAt   *time.Time // Set by DELETE /invitations or a newer invitation to the same address
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+}
+
+// PasswordHistory keeps a replaced password hash of a user, so the last PasswordHistoryLength
+// passwords cannot be used again, see passwords.go. Older hashes are deleted.
+type PasswordHistory struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	Password  string    `gorm:"not null"` // Hash of the replaced password
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// LoginEvent records a successful or failed login of a user, see login_history.go.
+// Events older than LoginHistoryRetention are deleted.
+type LoginEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    *uint     `gorm:"index"` // Nil when the username belongs to no user
+	Username  string    `gorm:"index"` // The name that was tried
+	IPAddress string    `gorm:"type:varchar(64)"`
+	UserAgent string    `gorm:"type:varchar(512)"`
+	Success   bool      `gorm:"not null"`
+	Reason    string    `gorm:"type:varchar(32);not null"` // One of the LoginReason* constants
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
+// SecurityEvent records a failed, blocked or locked out login, an unlock, a 2FA change, a password reset, an email verification, an invitation, a session revocation or a deleted or restored user for later review.
+// Username is the name that was tried, it does not have to belong to an existing user.
+type SecurityEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	Type      string    `gorm:"type:varchar(32);index;not null"` // One of the Event* constants
+	Username  string    `gorm:"index"`
+	IPAddress string    `gorm:"type:varchar(64)"`
+	Detail    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
 }
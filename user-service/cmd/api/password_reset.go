@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for password resets
+const (
+	EventPasswordResetRequested = "password_reset_requested" // Reset link emailed
+	EventPasswordReset          = "password_reset"           // Password replaced with a reset token
+)
+
+const (
+	passwordResetInterval = time.Minute // Minimum time between two reset emails to the same user
+
+	PasswordResetRequested = "If the address belongs to an account, a password reset link has been sent to it"
+	ErrInvalidResetToken   = "Invalid or expired reset token"
+)
+
+// errResetTokenInvalid is returned by usePasswordResetToken for unknown, used or expired tokens
+var errResetTokenInvalid = errors.New("invalid password reset token")
+
+// tokenLink returns base with the token in its "token" query parameter, as emailed to the user
+func tokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// createPasswordResetToken uses up the user's earlier reset tokens and stores a new one.
+// The plain token is returned once and never stored.
+func createPasswordResetToken(tx *gorm.DB, userID uint, now time.Time) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", userID).Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PasswordResetToken{
+			UserID:    userID,
+			TokenHash: hashToken(token),
+			ExpiresAt: now.Add(PasswordResetTTL),
+		}).Error
+	})
+	return token, err
+}
+
+// usePasswordResetToken marks a valid reset token as used and returns its user.
+// Every token works only once, even when two requests race with it.
+func usePasswordResetToken(tx *gorm.DB, token string, now time.Time) (User, error) {
+	var record PasswordResetToken
+	if err := tx.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, errResetTokenInvalid
+		}
+		return User{}, err
+	}
+	if record.UsedAt != nil || now.After(record.ExpiresAt) {
+		return User{}, errResetTokenInvalid
+	}
+
+	var user User
+	if err := tx.First(&user, record.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, errResetTokenInvalid
+		}
+		return User{}, err
+	}
+	if !user.Activated {
+		return User{}, errResetTokenInvalid
+	}
+
+	result := tx.Model(&PasswordResetToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", now)
+	if result.Error != nil {
+		return User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return User{}, errResetTokenInvalid
+	}
+	return user, nil
+}
+
+// ForgotPasswordHandler emails a password reset link
+// @Summary Request a password reset
+// @Description Emails a single-use link to reset the password to the given address if it belongs to an activated user.
+// @Description The answer is the same whether or not the address is known, so it does not reveal which addresses have accounts.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{MailAddress string `json:"mailAddress"`} true "Mail address of the account"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body or empty mail address"
+// @Failure 500 {string} string "Database error"
+// @Router /password/forgot [post]
+func (app *Config) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MailAddress string `json:"mailAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestData.MailAddress == "" {
+		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	respond := func() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": PasswordResetRequested})
+	}
+
+	var user User
+	if err := app.DB.Where("mail_address = ?", requestData.MailAddress).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respond()
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !user.Activated {
+		respond()
+		return
+	}
+
+	// Do not flood the mailbox when the form is sent repeatedly
+	now := time.Now()
+	var recent int64
+	err := app.DB.Model(&PasswordResetToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, now.Add(-passwordResetInterval)).
+		Count(&recent).Error
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if recent > 0 {
+		respond()
+		return
+	}
+
+	token, err := createPasswordResetToken(app.DB, user.ID, now)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	link, err := tokenLink(PasswordResetURL, token)
+	if err != nil {
+		http.Error(w, "Invalid password reset URL", http.StatusInternalServerError)
+		return
+	}
+
+	msg := Message{
+		To:      user.MailAddress,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nA password reset was requested for your account. Open the link below within %s to choose a new password:\n\n%s\n\n"+
+			"If you did not ask for it, ignore this email. Your password stays unchanged.\n", user.Username, PasswordResetTTL, link),
+	}
+	// A failed delivery is not reported to the client, that would reveal the address has an account
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to send password reset email to %s: %v", user.Username, err)
+	}
+	app.recordSecurityEvent(EventPasswordResetRequested, user.Username, clientIP(r), "")
+	respond()
+}
+
+// ResetPasswordHandler sets a new password with an emailed reset token
+// @Summary Reset password
+// @Description Replaces the password of the user the reset token was emailed to. The token works once.
+// @Description Every session of the user is ended, the failed login counter and lockout are cleared and 2FA stays enabled.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{Token string `json:"token"`; NewPassword string `json:"new_password"`} true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body, invalid or expired reset token or password rejected by the policy"
+// @Failure 500 {string} string "Database error or hashing error"
+// @Router /password/reset [post]
+func (app *Config) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.Token == "" {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	// Check the token and the password before using the token up, so a rejected password can be retried
+	var record PasswordResetToken
+	err := app.DB.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(requestData.Token), time.Now()).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrInvalidResetToken, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var owner User
+	if err := app.DB.First(&owner, record.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrInvalidResetToken, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := app.validateNewPassword(requestData.NewPassword, owner.ID, owner.Password, owner.Username, owner.MailAddress); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+	hashedPassword, err := app.HashPassword(requestData.NewPassword)
+	if err != nil {
+		http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password loses every session, the lockout is lifted as the mailbox owner asked for the reset
+	var user User
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = usePasswordResetToken(tx, requestData.Token, time.Now())
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"password":      hashedPassword,
+			"token_version": gorm.Expr("token_version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := rememberPassword(tx, user.ID, user.Password); err != nil {
+			return err
+		}
+		if err := resetLoginFailures(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", user.ID).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "")
+	})
+	if errors.Is(err, errResetTokenInvalid) {
+		http.Error(w, ErrInvalidResetToken, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventPasswordReset, user.Username, clientIP(r), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully, log in with the new password"})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linkTokenPattern finds the token in an emailed reset or verification link
+var linkTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_.-]+)`)
+
+// sentMail returns everything the test app's FileMailer has written, empty if nothing was sent
+func sentMail(t *testing.T, app *Config) string {
+	b, err := os.ReadFile(app.Mailer.(*FileMailer).Path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	require.NoError(t, err)
+	return string(b)
+}
+
+// Test the whole flow: the emailed token resets the password once and ends the old sessions
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	old := login(t, router, "rep", "Password123")
+
+	// Unknown addresses get the same answer and no email
+	unknown := postJSON(router, "/password/forgot", "", `{"mailAddress":"nobody@example.com"}`)
+	require.Equal(t, http.StatusOK, unknown.Code)
+	assert.Empty(t, sentMail(t, app))
+
+	known := postJSON(router, "/password/forgot", "", `{"mailAddress":"rep@example.com"}`)
+	require.Equal(t, http.StatusOK, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+	mail := sentMail(t, app)
+	assert.Contains(t, mail, "To: rep@example.com")
+	match := linkTokenPattern.FindStringSubmatch(mail)
+	require.Len(t, match, 2)
+	token := match[1]
+
+	var stored PasswordResetToken
+	require.NoError(t, app.DB.First(&stored).Error)
+	assert.Equal(t, hashToken(token), stored.TokenHash, "Only the hash is stored")
+
+	// Asking again at once sends no second email
+	postJSON(router, "/password/forgot", "", `{"mailAddress":"rep@example.com"}`)
+	assert.Equal(t, 1, strings.Count(sentMail(t, app), "Subject:"))
+
+	// A rejected password does not use the token up
+	rec := postJSON(router, "/password/reset", "", `{"token":"`+token+`","new_password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = postJSON(router, "/password/reset", "", `{"token":"`+token+`","new_password":"Reset12345"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = postJSON(router, "/password/reset", "", `{"token":"`+token+`","new_password":"Another12345"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "Tokens work once")
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+old.AccessToken, rep))
+	rec = postJSON(router, "/token/refresh", "", `{"refresh_token":"`+old.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	login(t, router, "rep", "Reset12345")
+}
+
+// Test that expired tokens and tokens replaced by a newer request are refused
+func TestPasswordResetTokenExpires(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+
+	first, err := createPasswordResetToken(app.DB, rep.ID, time.Now())
+	require.NoError(t, err)
+	second, err := createPasswordResetToken(app.DB, rep.ID, time.Now())
+	require.NoError(t, err)
+	rec := postJSON(router, "/password/reset", "", `{"token":"`+first+`","new_password":"Reset12345"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	require.NoError(t, app.DB.Model(&PasswordResetToken{}).Where("token_hash = ?", hashToken(second)).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	rec = postJSON(router, "/password/reset", "", `{"token":"`+second+`","new_password":"Reset12345"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	login(t, router, "rep", "Password123")
+}
+
+// Test that header values cannot inject further headers
+func TestFormatMessage(t *testing.T) {
+	msg := formatMessage("no-reply@example.com", Message{
+		To:      "rep@example.com\r\nBcc: attacker@example.com",
+		Subject: "Reset your password",
+		Body:    "line one\nline two",
+	})
+	assert.NotContains(t, msg, "\r\nBcc:")
+	assert.Contains(t, msg, "Subject: Reset your password\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two"))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// MaxPasswordLength limits new passwords, bcrypt ignores everything after 72 bytes
+const MaxPasswordLength = 72
+
+// identifiers shorter than this are not searched for in passwords, they would rule out too much
+const minIdentifierLength = 3
+
+// Character classes for USER_SERVICE_PASSWORD_REQUIRED_CLASSES
+const (
+	ClassLetter = "letter"
+	ClassUpper  = "upper"
+	ClassLower  = "lower"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol" // Anything but letters, digits and spaces
+)
+
+// Rules of the password policy, reported in PasswordViolation
+const (
+	RuleMinLength      = "min_length"
+	RuleMaxLength      = "max_length"
+	RuleCharacterClass = "character_class"
+	RuleIdentity       = "contains_identity"
+	RuleReused         = "reused"
+	RuleBreached       = "breached"
+)
+
+// ErrPasswordRejected is the error of the structured response for passwords rejected by the policy
+const ErrPasswordRejected = "Password rejected by the policy"
+
+// classCheckers tell whether a character belongs to a character class
+var classCheckers = map[string]func(rune) bool{
+	ClassLetter: unicode.IsLetter,
+	ClassUpper:  unicode.IsUpper,
+	ClassLower:  unicode.IsLower,
+	ClassDigit:  unicode.IsDigit,
+	ClassSymbol: func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c) },
+}
+
+// PasswordViolation is a rule of the password policy that a password breaks
+type PasswordViolation struct {
+	Rule    string `json:"rule"` // One of the Rule* constants
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a rejected password breaks
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *PasswordPolicyError) add(rule, format string, args ...interface{}) {
+	e.Violations = append(e.Violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkPasswordPolicy reports a password policy configured in the environment that cannot work
+func checkPasswordPolicy() error {
+	if PasswordMinLength > MaxPasswordLength {
+		return fmt.Errorf("USER_SERVICE_PASSWORD_MIN_LENGTH must be at most %d", MaxPasswordLength)
+	}
+	for _, class := range PasswordRequiredClasses {
+		if _, ok := classCheckers[class]; !ok {
+			return fmt.Errorf("unknown password character class %q", class)
+		}
+	}
+	return nil
+}
+
+// validatePassword checks a new password against the rules of the password policy that need no
+// database: its length, its character classes and the identifiers of the account, such as the
+// username and mail address. Rejected passwords get a *PasswordPolicyError with every broken rule.
+func validatePassword(password string, identifiers ...string) error {
+	policyErr := &PasswordPolicyError{}
+	if length := len(password); length < PasswordMinLength {
+		policyErr.add(RuleMinLength, "password must be at least %d characters long", PasswordMinLength)
+	} else if length > MaxPasswordLength {
+		policyErr.add(RuleMaxLength, "password must be at most %d characters long", MaxPasswordLength)
+	}
+
+	for _, class := range PasswordRequiredClasses {
+		if !strings.ContainsFunc(password, classCheckers[class]) {
+			policyErr.add(RuleCharacterClass, "password must contain at least one %s character", class)
+		}
+	}
+
+	lower := strings.ToLower(password)
+	for _, identifier := range passwordIdentifiers(identifiers) {
+		if strings.Contains(lower, identifier) {
+			policyErr.add(RuleIdentity, "password must not contain the username or mail address")
+			break
+		}
+	}
+
+	if len(policyErr.Violations) > 0 {
+		return policyErr
+	}
+	return nil
+}
+
+// passwordIdentifiers returns the lower-cased identifiers to search for in passwords. Mail addresses
+// are searched for by their local part as well.
+func passwordIdentifiers(identifiers []string) []string {
+	var result []string
+	for _, identifier := range identifiers {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+		candidates := []string{identifier}
+		if local, _, ok := strings.Cut(identifier, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, candidate := range candidates {
+			if len(candidate) >= minIdentifierLength {
+				result = append(result, candidate)
+			}
+		}
+	}
+	return result
+}
+
+// validateNewPassword checks the new password of a user against the whole password policy: the rules
+// of validatePassword, the breached password list and, for existing users, their password history.
+// userID is 0 for accounts that are being created.
+func (app *Config) validateNewPassword(password string, userID uint, currentHash string, identifiers ...string) error {
+	policyErr := &PasswordPolicyError{}
+	if err := validatePassword(password, identifiers...); err != nil {
+		policyErr = err.(*PasswordPolicyError)
+	}
+	if app.BreachedPasswords.Contains(password) {
+		policyErr.add(RuleBreached, "password appears in a list of breached passwords")
+	}
+
+	// Comparing with old hashes is expensive, it is left out when the password is rejected anyway
+	if len(policyErr.Violations) == 0 && userID != 0 {
+		reused, err := app.passwordReused(userID, currentHash, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			policyErr.add(RuleReused, "password must differ from the last %d passwords", PasswordHistoryLength)
+		}
+	}
+
+	if len(policyErr.Violations) > 0 {
+		return policyErr
+	}
+	return nil
+}
+
+// writePasswordError answers a password rejected by validateNewPassword with 400 and the broken
+// rules, other errors are database errors
+func writePasswordError(w http.ResponseWriter, err error) {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      ErrPasswordRejected,
+		"violations": policyErr.Violations,
+	})
+}
+
+// passwordReused reports whether the password is the current password of the user or one of the
+// previous passwords kept in the history. It is always false without PasswordHistoryLength.
+func (app *Config) passwordReused(userID uint, currentHash, password string) (bool, error) {
+	if PasswordHistoryLength == 0 {
+		return false, nil
+	}
+	if comparePassword(currentHash, password) {
+		return true, nil
+	}
+	if PasswordHistoryLength == 1 {
+		return false, nil
+	}
+
+	var previous []PasswordHistory
+	err := app.DB.Where("user_id = ?", userID).Order("id DESC").Limit(PasswordHistoryLength - 1).Find(&previous).Error
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range previous {
+		if comparePassword(entry.Password, password) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rememberPassword keeps the replaced password hash of a user in the history and forgets the
+// hashes that fell out of it. The current hash is not part of the history, it is in the user row.
+func rememberPassword(tx *gorm.DB, userID uint, replacedHash string) error {
+	if PasswordHistoryLength <= 1 {
+		return nil
+	}
+	if err := tx.Create(&PasswordHistory{UserID: userID, Password: replacedHash}).Error; err != nil {
+		return err
+	}
+
+	var kept []uint
+	err := tx.Model(&PasswordHistory{}).Where("user_id = ?", userID).Order("id DESC").Limit(PasswordHistoryLength-1).Pluck("id", &kept).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("user_id = ? AND id NOT IN ?", userID, kept).Delete(&PasswordHistory{}).Error
+}
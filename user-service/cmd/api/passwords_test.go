@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test the password policy
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, validatePassword("Password123", "alice"))
+	assert.Error(t, validatePassword("Pass1", "alice"), "Too short")
+	assert.Error(t, validatePassword(strings.Repeat("a1", 40), "alice"), "Too long")
+	assert.Error(t, validatePassword("onlyletters", "alice"), "No digit")
+	assert.Error(t, validatePassword("1234567890", "alice"), "No letter")
+	assert.Error(t, validatePassword("Alice12345", "alice"), "Contains the username")
+	assert.Error(t, validatePassword("Wonder12345", "alice", "wonder@example.com"), "Contains the mail address")
+	assert.NoError(t, validatePassword("Password123", "al"), "Short identifiers are ignored")
+}
+
+// Test that every broken rule is reported and that the required classes are configurable
+func TestPasswordPolicyViolations(t *testing.T) {
+	classes := PasswordRequiredClasses
+	t.Cleanup(func() { PasswordRequiredClasses = classes })
+	PasswordRequiredClasses = []string{ClassUpper, ClassLower, ClassDigit, ClassSymbol}
+
+	err := validatePassword("alice", "alice")
+	var policyErr *PasswordPolicyError
+	require.ErrorAs(t, err, &policyErr)
+	var rules []string
+	for _, violation := range policyErr.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	assert.Equal(t, []string{RuleMinLength, RuleCharacterClass, RuleCharacterClass, RuleCharacterClass, RuleIdentity}, rules)
+	assert.NoError(t, validatePassword("Pass word1!", "alice"))
+
+	PasswordRequiredClasses = []string{"emoji"}
+	assert.Error(t, checkPasswordPolicy())
+}
+
+// changePassword calls /update-password with the given token and body
+func changePassword(router http.Handler, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/update-password", strings.NewReader(body))
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test that users must know their current password and that older tokens stop working
+func TestUpdatePasswordSelf(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	token := bearer(t, app, rep)
+
+	rec := changePassword(router, token, `{"new_password":"NewPassword456"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "The current password is required")
+
+	rec = changePassword(router, token, `{"current_password":"Wrong123","new_password":"NewPassword456"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = changePassword(router, token, `{"current_password":"Password123","new_password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "The policy applies")
+
+	rec = changePassword(router, token, `{"current_password":"Password123","new_password":"NewPassword456"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var response map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.NotEmpty(t, response["token"], "The caller gets a fresh token")
+
+	var stored User
+	require.NoError(t, app.DB.First(&stored, rep.ID).Error)
+	assert.True(t, app.CheckPassword(stored.Password, "NewPassword456"))
+
+	// The old token was issued before the change
+	rec = changePassword(router, token, `{"current_password":"NewPassword456","new_password":"OtherPassword789"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = changePassword(router, "Bearer "+response["token"], `{"current_password":"NewPassword456","new_password":"OtherPassword789"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// Test that admins reset other passwords without the current one and reps cannot
+func TestUpdatePasswordAdminReset(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	createTestUser(t, app, "other", "Password123", RoleSalesRep)
+
+	rec := changePassword(router, bearer(t, app, rep), `{"username":"other","new_password":"NewPassword456"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = changePassword(router, bearer(t, app, admin), `{"username":"other","new_password":"NewPassword456"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var response map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Empty(t, response["token"], "No token is handed out for somebody else's account")
+
+	var stored User
+	require.NoError(t, app.DB.Where("username = ?", "other").First(&stored).Error)
+	assert.True(t, app.CheckPassword(stored.Password, "NewPassword456"))
+	assert.Equal(t, 1, stored.TokenVersion)
+}
+
+// Test that rejected passwords get the violated rules as JSON
+func TestPasswordPolicyResponse(t *testing.T) {
+	enableSelfRegistration(t)
+	app := newTestApp(t)
+	router := app.routes()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(sha1Hex("Password123")+":42\n"), 0o600))
+	breached, err := LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	app.BreachedPasswords = breached
+
+	rec := postJSON(router, "/register", "", `{"username":"rep","mailaddress":"rep@example.com","password":"Password123"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var response struct {
+		Error      string
+		Violations []PasswordViolation
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, ErrPasswordRejected, response.Error)
+	require.Len(t, response.Violations, 1)
+	assert.Equal(t, RuleBreached, response.Violations[0].Rule)
+}
+
+// Test that the last PasswordHistoryLength passwords cannot be used again
+func TestPasswordHistory(t *testing.T) {
+	history := PasswordHistoryLength
+	t.Cleanup(func() { PasswordHistoryLength = history })
+	PasswordHistoryLength = 3
+
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password1", RoleSalesRep)
+	token := bearer(t, app, admin)
+	reset := func(password string) int {
+		return changePassword(router, token, `{"username":"rep","new_password":"`+password+`"}`).Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, reset("Password1"), "The current password")
+	for _, password := range []string{"Password2", "Password3", "Password4"} {
+		require.Equal(t, http.StatusOK, reset(password))
+	}
+	assert.Equal(t, http.StatusBadRequest, reset("Password3"))
+	assert.Equal(t, http.StatusBadRequest, reset("Password2"))
+	assert.Equal(t, http.StatusOK, reset("Password1"), "Fell out of the history")
+
+	var kept int64
+	require.NoError(t, app.DB.Model(&PasswordHistory{}).Where("user_id = ?", rep.ID).Count(&kept).Error)
+	assert.Equal(t, int64(2), kept)
+}
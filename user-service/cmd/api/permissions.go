@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Roles a user can have
+const (
+	RoleAdmin    = "Admin"
+	RoleSalesRep = "Sales Representative"
+)
+
+// Permission is an action a role may be allowed to perform
+type Permission string
+
+// Permissions checked by the routes of this service
+const (
+	PermReadOwnProfile   Permission = "profile:read"   // Read the caller's own user record
+	PermUpdateOwnProfile Permission = "profile:update" // Change the caller's own email and password
+	PermReadUsers        Permission = "users:read"     // Read any user record
+	PermManageUsers      Permission = "users:manage"   // Update, (de)activate and delete any user
+	PermManageRoles      Permission = "users:roles"    // Change the role of a user
+	PermReviewSecurity   Permission = "security:read"  // Review failed logins and lockouts
+)
+
+// rolePermissions maps every role to the actions it is allowed to perform.
+// A role missing from the map has no permissions at all.
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermReadOwnProfile,
+		PermUpdateOwnProfile,
+		PermReadUsers,
+		PermManageUsers,
+		PermManageRoles,
+		PermReviewSecurity,
+	},
+	RoleSalesRep: {
+		PermReadOwnProfile,
+		PermUpdateOwnProfile,
+	},
+}
+
+// isValidRole reports whether role is one of the known roles
+func isValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// hasPermission reports whether the role is allowed to perform the action
+func hasPermission(role string, perm Permission) bool {
+	for _, allowed := range rolePermissions[role] {
+		if allowed == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// contextKey keeps values stored by this package from colliding with other context keys
+type contextKey string
+
+// authUserKey is the context key under which AuthMiddleware stores the caller
+const authUserKey contextKey = "authUser"
+
+// AuthUser is the caller identified by a verified token
+type AuthUser struct {
+	ID           uint // Filled in by AuthMiddleware from the database
+	Username     string
+	Role         string
+	TokenVersion int
+	SessionID    string // Session the access token belongs to, see RefreshToken
+}
+
+// authUserFromContext returns the caller stored by AuthMiddleware
+func authUserFromContext(ctx context.Context) (AuthUser, bool) {
+	user, ok := ctx.Value(authUserKey).(AuthUser)
+	return user, ok
+}
+
+// RequirePermission only lets callers whose role grants perm through.
+// It must run after AuthMiddleware.
+func RequirePermission(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := authUserFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Missing token", http.StatusUnauthorized)
+				return
+			}
+			if !hasPermission(caller.Role, perm) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// canAccessUser reports whether the caller may act on the target user: everyone may act on
+// their own profile, acting on somebody else needs the given permission
+func canAccessUser(caller AuthUser, targetUsername string, perm Permission) bool {
+	return caller.Username == targetUsername || hasPermission(caller.Role, perm)
+}
+
+// canAccessUserID is canAccessUser for targets given by ID. It needs no lookup of the target, so
+// callers without the permission learn nothing about IDs other than their own.
+func canAccessUserID(caller AuthUser, targetID uint, perm Permission) bool {
+	return caller.ID == targetID || hasPermission(caller.Role, perm)
+}
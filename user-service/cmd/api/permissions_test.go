@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Test the role to permission map
+func TestHasPermission(t *testing.T) {
+	assert.True(t, hasPermission(RoleAdmin, PermManageUsers))
+	assert.True(t, hasPermission(RoleAdmin, PermManageRoles))
+	assert.True(t, hasPermission(RoleSalesRep, PermReadOwnProfile))
+	assert.True(t, hasPermission(RoleSalesRep, PermUpdateOwnProfile))
+	assert.False(t, hasPermission(RoleSalesRep, PermReadUsers))
+	assert.False(t, hasPermission(RoleSalesRep, PermManageUsers))
+	assert.False(t, hasPermission(RoleSalesRep, PermManageRoles))
+	assert.False(t, hasPermission("MANAGER", PermReadOwnProfile), "Unknown roles have no permissions")
+
+	assert.True(t, isValidRole(RoleSalesRep))
+	assert.False(t, isValidRole("MANAGER"))
+}
+
+// Test that reps can act on themselves only, unless they hold the permission
+func TestCanAccessUser(t *testing.T) {
+	rep := AuthUser{Username: "rep", Role: RoleSalesRep}
+	admin := AuthUser{Username: "admin", Role: RoleAdmin}
+
+	assert.True(t, canAccessUser(rep, "rep", PermManageUsers))
+	assert.False(t, canAccessUser(rep, "someone", PermManageUsers))
+	assert.True(t, canAccessUser(admin, "someone", PermManageUsers))
+
+	rep.ID, admin.ID = 2, 1
+	assert.True(t, canAccessUserID(rep, 2, PermReadUsers))
+	assert.False(t, canAccessUserID(rep, 3, PermReadUsers))
+	assert.True(t, canAccessUserID(admin, 3, PermReadUsers))
+}
+
+// Test that Admin-only routes reject sales representatives before reaching the handlers
+func TestRoutesRequirePermission(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	token := bearer(t, app, createTestUser(t, app, "rep", "Password123", RoleSalesRep))
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPut, "/deactivate-user"},
+		{http.MethodPut, "/activate-user"},
+		{http.MethodPut, "/update-role"},
+		{http.MethodDelete, "/delete-user"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{"username":"someone"}`))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s should be Admin only", route.method, route.path)
+
+		req = httptest.NewRequest(route.method, route.path, nil)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "%s %s should require a token", route.method, route.path)
+	}
+
+	// Reps may call the profile routes but not for somebody else
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodPost, "/update-password", `{"username":"someone","new_password":"NewPassword123"}`},
+		{http.MethodPut, "/update-user", `{"username":"someone","email":"someone@example.com"}`},
+		{http.MethodPut, "/update-email", `{"username":"someone","new_email":"someone@example.com"}`},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s should reject other users", route.method, route.path)
+	}
+}
+
+// Test that only valid Admin tokens may register Admins, revoked ones are checked like in AuthMiddleware
+func TestRegisterAdminChecksCallerToken(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	register := func(token, name string) int {
+		body := `{"username":"` + name + `","mailAddress":"` + name + `@example.com","password":"Password123","role":"Admin"}`
+		return postJSON(router, "/register", token, body).Code
+	}
+
+	token := bearer(t, app, admin)
+	assert.Equal(t, http.StatusOK, register(token, "second"))
+
+	// A token of an older token version
+	require.NoError(t, app.DB.Model(&admin).Update("token_version", gorm.Expr("token_version + 1")).Error)
+	assert.Equal(t, http.StatusForbidden, register(token, "third"))
+
+	// A token of a session that was logged out
+	require.NoError(t, app.DB.First(&admin, admin.ID).Error)
+	token = bearer(t, app, admin)
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", token)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusForbidden, register(token, "third"))
+
+	// A token of a deactivated Admin
+	token = bearer(t, app, admin)
+	require.NoError(t, app.DB.Model(&admin).Update("activated", false).Error)
+	assert.Equal(t, http.StatusForbidden, register(token, "third"))
+}
@@ -18,11 +18,11 @@ func (app *Config) routes() http.Handler {
 		AllowedOrigins: []string{
 			"http://localhost:3000", // Allow requests from localhost, where our React frontend web app is running
 		},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},                 // Allowed HTTP methods for cross-origin requests
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"}, // Allowed request headers
-		ExposedHeaders:   []string{"Link"},                                                    // Expose headers to the client
-		AllowCredentials: true,                                                                // Allow credentials (cookies) to be sent with cross-origin requests
-		MaxAge:           300,                                                                 // Cache pre-flight requests for 5 minutes
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},                                    // Allowed HTTP methods for cross-origin requests
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", DeviceLabelHeader}, // Allowed request headers
+		ExposedHeaders:   []string{"Link"},                                                                       // Expose headers to the client
+		AllowCredentials: true,                                                                                   // Allow credentials (cookies) to be sent with cross-origin requests
+		MaxAge:           300,                                                                                    // Cache pre-flight requests for 5 minutes
 	}))
 
 	// Middleware
@@ -35,22 +35,57 @@ func (app *Config) routes() http.Handler {
 	mux.Get("/swagger/*", httpSwagger.WrapHandler) // Route to serve Swagger documentation (API documentation)
 
 	// Public Routes (No authentication required)
-	mux.Post("/register", app.CreateUserHandler) // Route to register a new user (no authentication needed)
-	mux.Post("/login", app.LoginUserHandler)     // Route to log in an existing user (no authentication needed)
+	mux.Post("/register", app.CreateUserHandler)                     // Route to register a new user (no authentication needed)
+	mux.Post("/login", app.LoginUserHandler)                         // Route to log in an existing user (no authentication needed)
+	mux.Post("/login/2fa", app.TwoFactorLoginHandler)                // Route to complete a login with a TOTP or recovery code
+	mux.Post("/login/2fa/enroll", app.TwoFactorLoginEnrollHandler)   // Route to set up mandatory 2FA during login
+	mux.Post("/login/2fa/confirm", app.TwoFactorLoginConfirmHandler) // Route to confirm mandatory 2FA and complete the login
+	mux.Post("/token/refresh", app.RefreshTokenHandler)              // Route to exchange a refresh token for a new token pair
+	mux.Post("/password/forgot", app.ForgotPasswordHandler)          // Route to email a password reset link
+	mux.Post("/password/reset", app.ResetPasswordHandler)            // Route to set a new password with an emailed reset token
+	mux.Get("/verify-email", app.VerifyEmailHandler)                 // Route to activate a new account with the emailed verification link
+	mux.Post("/verify-email/resend", app.ResendVerificationHandler)  // Route to email a new verification link
+	mux.Post("/invitations/accept", app.AcceptInvitationHandler)     // Route to create an account with an emailed invitation
+	mux.Get("/.well-known/jwks.json", app.JWKSHandler)               // Route to publish the public keys access tokens are verified with
 
 	// Protected Routes (Require JWT authentication)
 	mux.Group(func(mux chi.Router) {
-		mux.Use(AuthMiddleware) // Apply JWT authentication middleware to all routes within this group
-
-		// User management routes (authentication required)
-		mux.Get("/user", app.GetUserHandler)                    // Route to retrieve a user by their ID
-		mux.Post("/update-password", app.UpdatePasswordHandler) // Route to update the user's password (authentication required)
-		mux.Put("/update-user", app.UpdateUserHandler)          // Route to update user information (authentication required)
-		mux.Put("/deactivate-user", app.DeactivateUserHandler)  // Route to deactivate a user by username (authentication required)
-		mux.Put("/activate-user", app.ActivateUserHandler)      // Route to activate a deactivated user (authentication required)
-		mux.Put("/update-email", app.UpdateEmailHandler)        // Route to update the user's email address (authentication required)
-		mux.Put("/update-role", app.UpdateRoleHandler)          // Route to update the user's role (authentication required)
-		mux.Delete("/delete-user", app.DeleteUserHandler)       // Route to delete a user (authentication required)
+		mux.Use(app.AuthMiddleware) // Apply JWT authentication middleware to all routes within this group
+
+		mux.Post("/logout", app.LogoutHandler)                      // Route to end the caller's session
+		mux.Get("/token/introspect", app.TokenIntrospectHandler)    // Route for the other services to check that a token is not revoked
+		mux.Get("/me/logins", app.MyLoginsHandler)                  // Route to list the caller's login history
+		mux.Get("/me/sessions", app.MySessionsHandler)              // Route to list the caller's sessions
+		mux.Delete("/me/sessions/{id}", app.RevokeMySessionHandler) // Route to end one of the caller's sessions
+
+		// Two-factor authentication of the caller
+		mux.Post("/2fa/enroll", app.TwoFactorEnrollHandler)       // Route to generate a TOTP secret and its otpauth URI
+		mux.Post("/2fa/confirm", app.TwoFactorConfirmHandler)     // Route to enable 2FA with the first code
+		mux.Post("/2fa/disable", app.TwoFactorDisableHandler)     // Route to turn 2FA off, unless the role requires it
+		mux.Post("/2fa/recovery-codes", app.RecoveryCodesHandler) // Route to replace the recovery codes
+
+		// Own profile routes, reps are limited to their own user inside the handlers
+		mux.With(RequirePermission(PermReadOwnProfile)).Get("/user", app.GetUserHandler)                      // Route to retrieve a user by their ID
+		mux.With(RequirePermission(PermUpdateOwnProfile)).Post("/update-password", app.UpdatePasswordHandler) // Route to update the user's password
+		mux.With(RequirePermission(PermUpdateOwnProfile)).Put("/update-user", app.UpdateUserHandler)          // Route to update user information
+		mux.With(RequirePermission(PermUpdateOwnProfile)).Put("/update-email", app.UpdateEmailHandler)        // Route to update the user's email address
+
+		// User management routes (Admin only)
+		mux.With(RequirePermission(PermReadUsers)).Get("/users", app.ListUsersHandler)                             // Route to list, search and filter users
+		mux.With(RequirePermission(PermReadUsers)).Get("/users/{id}/logins", app.UserLoginsHandler)                // Route to list the login history of a user
+		mux.With(RequirePermission(PermManageUsers)).Delete("/users/{id}/sessions", app.RevokeUserSessionsHandler) // Route to end every session of a user
+		mux.With(RequirePermission(PermManageUsers)).Put("/deactivate-user", app.DeactivateUserHandler)            // Route to deactivate a user by username
+		mux.With(RequirePermission(PermManageUsers)).Put("/activate-user", app.ActivateUserHandler)                // Route to activate a deactivated user
+		mux.With(RequirePermission(PermManageRoles)).Put("/update-role", app.UpdateRoleHandler)                    // Route to update the user's role
+		mux.With(RequirePermission(PermManageUsers)).Put("/unlock-user", app.UnlockUserHandler)                    // Route to lift the login lockout of a user
+		mux.With(RequirePermission(PermManageUsers)).Put("/reset-2fa", app.ResetTwoFactorHandler)                  // Route to clear the 2FA of a user who lost their authenticator
+		mux.With(RequirePermission(PermManageUsers)).Post("/invitations", app.CreateInvitationHandler)             // Route to invite a new user by email
+		mux.With(RequirePermission(PermManageUsers)).Get("/invitations", app.ListInvitationsHandler)               // Route to list sent invitations
+		mux.With(RequirePermission(PermManageUsers)).Delete("/invitations", app.RevokeInvitationHandler)           // Route to revoke an open invitation
+		mux.With(RequirePermission(PermReviewSecurity)).Get("/security-events", app.SecurityEventsHandler)         // Route to review failed logins and lockouts
+		mux.With(RequirePermission(PermManageUsers)).Delete("/delete-user", app.DeleteUserHandler)                 // Route to move a user to the trash
+		mux.With(RequirePermission(PermManageUsers)).Get("/users/trash", app.DeletedUsersHandler)                  // Route to list deleted users
+		mux.With(RequirePermission(PermManageUsers)).Post("/users/{id}/restore", app.RestoreUserHandler)           // Route to take a user back out of the trash
 	})
 
 	// Return the configured router to be used by the server
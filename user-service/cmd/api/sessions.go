@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for sessions
+const (
+	EventSessionRevoked  = "session_revoked"  // One session ended from the session list
+	EventSessionsRevoked = "sessions_revoked" // Every session of a user ended by an Admin
+)
+
+const (
+	sessionTouchInterval = time.Minute // LastSeenAt is updated at most this often per session
+	maxDeviceLabelLength = 128         // Longer device labels are cut off
+
+	// DeviceLabelHeader lets a client name the device it logs in from, e.g. "Work laptop"
+	DeviceLabelHeader = "X-Device-Label"
+
+	ErrSessionNotFound = "Session not found"
+)
+
+// sessionClient describes the device a session is opened from
+type sessionClient struct {
+	DeviceLabel string
+	IPAddress   string
+	UserAgent   string
+}
+
+// newSessionClient describes the device of a login request. Without an X-Device-Label header
+// the label is guessed from the User-Agent.
+func newSessionClient(r *http.Request) sessionClient {
+	label := strings.TrimSpace(r.Header.Get(DeviceLabelHeader))
+	if label == "" {
+		label = deviceLabel(r.UserAgent())
+	}
+	if len(label) > maxDeviceLabelLength {
+		label = label[:maxDeviceLabelLength]
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return sessionClient{DeviceLabel: label, IPAddress: clientIP(r), UserAgent: userAgent}
+}
+
+// deviceLabel guesses a readable label such as "Firefox on Windows" from a User-Agent header.
+// Unknown agents are named by their first product token, e.g. "curl/8.5.0".
+func deviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	// Order matters: Edge and Chrome also claim to be Safari, Edge also claims to be Chrome
+	browser := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	system := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return strings.Fields(userAgent)[0]
+}
+
+// touchSession moves the last seen time of a session forward, at most once per sessionTouchInterval
+func touchSession(db *gorm.DB, sessionID string, now time.Time) error {
+	return db.Model(&Session{}).
+		Where("session_id = ? AND last_seen_at < ?", sessionID, now.Add(-sessionTouchInterval)).
+		Update("last_seen_at", now).Error
+}
+
+// activeSessions returns the sessions of the user that still have a usable refresh token, most recently seen first
+func activeSessions(db *gorm.DB, userID uint, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Where("EXISTS (SELECT 1 FROM refresh_tokens WHERE refresh_tokens.session_id = sessions.session_id AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > ?)", now).
+		Order("last_seen_at DESC, id DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// updateLoginStatus marks the user as logged out once no session is left
+func updateLoginStatus(tx *gorm.DB, userID uint) error {
+	var active int64
+	err := tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Count(&active).Error
+	if err != nil || active > 0 {
+		return err
+	}
+	return tx.Model(&User{}).Where("id = ?", userID).Update("login_status", false).Error
+}
+
+// MySessionsHandler lists the caller's sessions
+// @Summary List own sessions
+// @Description Lists the caller's active sessions, one per login on a device, most recently seen first. The session of the calling token is marked as current.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Success 200 {array} sessionResponse
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 500 {string} string "Database error"
+// @Router /me/sessions [get]
+func (app *Config) MySessionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+
+	sessions, err := activeSessions(app.DB, caller.ID, time.Now())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, newSessionResponse(session, caller.SessionID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeMySessionHandler ends one of the caller's sessions
+// @Summary Revoke own session
+// @Description Ends one of the caller's sessions, for example on a lost device. Its refresh and access tokens stop working immediately. Revoking the current session logs the caller out.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param id path int true "Session ID from GET /me/sessions"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid session ID"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 404 {string} string "Session not found"
+// @Failure 500 {string} string "Database error"
+// @Router /me/sessions/{id} [delete]
+func (app *Config) RevokeMySessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	caller, _ := authUserFromContext(r.Context())
+
+	// Sessions of other users answer the same as unknown ones
+	var session Session
+	if err := app.DB.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, caller.ID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrSessionNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeSession(tx, session.SessionID); err != nil {
+			return err
+		}
+		return updateLoginStatus(tx, caller.ID)
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventSessionRevoked, caller.Username, clientIP(r), "session on "+session.DeviceLabel)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Session revoked successfully",
+	})
+}
+
+// RevokeUserSessionsHandler ends every session of a user
+// @Summary Revoke all sessions of a user
+// @Description Ends every session of the user identified by id, on all devices. Their refresh and access tokens stop working immediately; the account stays activated and can log in again.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid user ID"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Database error"
+// @Router /users/{id}/sessions [delete]
+func (app *Config) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrUserNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	sessions, err := activeSessions(app.DB, user.ID, now)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeUserSessions(tx, user.ID, ""); err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", user.ID).Update("login_status", false).Error
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := authUserFromContext(r.Context())
+	app.recordSecurityEvent(EventSessionsRevoked, user.Username, clientIP(r), "revoked by "+caller.Username)
+	log.Printf("AUDIT: %d sessions of user %s revoked by admin %s", len(sessions), user.Username, caller.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Sessions revoked successfully",
+		"username": user.Username,
+		"revoked":  len(sessions),
+	})
+}
+
+// TokenIntrospectHandler tells the other services whether an access token is still accepted
+// @Summary Check an access token
+// @Description Answers 200 for access tokens AuthMiddleware accepts and 401 for tokens of logged out or revoked sessions, older token versions and deactivated users. customer-service and salestracking-service call it to learn about revocations before the token expires.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {string} string "Missing, invalid or revoked token"
+// @Failure 500 {string} string "Database error"
+// @Router /token/introspect [get]
+func (app *Config) TokenIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := authUserFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active":   true,
+		"uid":      caller.ID,
+		"username": caller.Username,
+		"role":     caller.Role,
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const firefoxOnWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// loginFrom logs the user in from a device with the given User-Agent and optional device label
+func loginFrom(t *testing.T, router http.Handler, username, password, userAgent, label string) tokenPair {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+	req.Header.Set("User-Agent", userAgent)
+	if label != "" {
+		req.Header.Set(DeviceLabelHeader, label)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var pair tokenPair
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&pair))
+	return pair
+}
+
+// listSessions reads the sessions of the token's user
+func listSessions(t *testing.T, router http.Handler, token string) []sessionResponse {
+	req := httptest.NewRequest(http.MethodGet, "/me/sessions", nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var sessions []sessionResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+	return sessions
+}
+
+// deleteStatus sends DELETE to path and returns the status code
+func deleteStatus(router http.Handler, path, token string) int {
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Test the labels guessed from User-Agent headers
+func TestDeviceLabel(t *testing.T) {
+	assert.Equal(t, "Firefox on Windows", deviceLabel(firefoxOnWindows))
+	assert.Equal(t, "Chrome on Android", deviceLabel("Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36"))
+	assert.Equal(t, "Safari on iOS", deviceLabel("Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"))
+	assert.Equal(t, "Edge on Windows", deviceLabel("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0"))
+	assert.Equal(t, "curl/8.5.0", deviceLabel("curl/8.5.0"))
+	assert.Equal(t, "Unknown device", deviceLabel(""))
+}
+
+// Test that a user sees the sessions of every device and can end one of them
+func TestMySessions(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	other := createTestUser(t, app, "other", "Password123", RoleSalesRep)
+
+	laptop := loginFrom(t, router, "rep", "Password123", firefoxOnWindows, "")
+	phone := loginFrom(t, router, "rep", "Password123", "curl/8.5.0", "Work phone")
+	otherToken := bearer(t, app, other)
+
+	sessions := listSessions(t, router, "Bearer "+laptop.AccessToken)
+	require.Len(t, sessions, 2)
+	labels := map[string]sessionResponse{}
+	for _, session := range sessions {
+		labels[session.DeviceLabel] = session
+	}
+	require.Contains(t, labels, "Firefox on Windows")
+	require.Contains(t, labels, "Work phone")
+	assert.True(t, labels["Firefox on Windows"].Current)
+	assert.False(t, labels["Work phone"].Current)
+	assert.Equal(t, "curl/8.5.0", labels["Work phone"].UserAgent)
+
+	// Sessions of other users cannot be revoked
+	phoneSession := fmt.Sprintf("/me/sessions/%d", labels["Work phone"].ID)
+	assert.Equal(t, http.StatusNotFound, deleteStatus(router, phoneSession, otherToken))
+	assert.Equal(t, http.StatusBadRequest, deleteStatus(router, "/me/sessions/abc", otherToken))
+
+	// Revoking the phone ends its tokens, the laptop stays logged in
+	require.Equal(t, http.StatusOK, deleteStatus(router, phoneSession, "Bearer "+laptop.AccessToken))
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+phone.AccessToken, rep))
+	rec := postJSON(router, "/token/refresh", "", `{"refresh_token":"`+phone.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, http.StatusNotFound, deleteStatus(router, phoneSession, "Bearer "+laptop.AccessToken), "Revoked sessions are gone")
+	assert.Len(t, listSessions(t, router, "Bearer "+laptop.AccessToken), 1)
+
+	var stored User
+	require.NoError(t, app.DB.First(&stored, rep.ID).Error)
+	assert.True(t, stored.LoginStatus, "The laptop session is still open")
+}
+
+// Test that an Admin ends every session of a user at once
+func TestRevokeUserSessions(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	first := login(t, router, "rep", "Password123")
+	second := login(t, router, "rep", "Password123")
+
+	path := fmt.Sprintf("/users/%d/sessions", rep.ID)
+	assert.Equal(t, http.StatusForbidden, deleteStatus(router, path, "Bearer "+first.AccessToken))
+	assert.Equal(t, http.StatusNotFound, deleteStatus(router, "/users/999/sessions", bearer(t, app, admin)))
+
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set("Authorization", bearer(t, app, admin))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, float64(2), decodeBody(t, rec)["revoked"])
+
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+first.AccessToken, rep))
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+second.AccessToken, rep))
+	var stored User
+	require.NoError(t, app.DB.First(&stored, rep.ID).Error)
+	assert.False(t, stored.LoginStatus)
+	assert.True(t, stored.Activated, "The account can log in again")
+	login(t, router, "rep", "Password123")
+
+	var events []SecurityEvent
+	require.NoError(t, app.DB.Where("type = ?", EventSessionsRevoked).Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, "revoked by admin", events[0].Detail)
+}
+
+// Test that the token check the other services use follows logouts
+func TestTokenIntrospect(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	tokens := login(t, router, "rep", "Password123")
+
+	introspect := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/token/introspect", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := introspect()
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := decodeBody(t, rec)
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, float64(rep.ID), body["uid"])
+	assert.Equal(t, RoleSalesRep, body["role"])
+
+	require.Equal(t, http.StatusOK, postJSON(router, "/logout", "Bearer "+tokens.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, introspect().Code)
+}
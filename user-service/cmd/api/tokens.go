@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned when a refresh token cannot be used
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrAccountDeactivated  = errors.New("account is deactivated")
+)
+
+// tokenPair is what a successful login, registration or refresh hands out
+type tokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+// randomToken returns n random bytes encoded for use in URLs and JSON
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the form a refresh token is stored in, the plain token is never saved
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens stores a new refresh token for the session and signs a matching access token
+func (app *Config) issueTokens(tx *gorm.DB, user User, sessionID string) (tokenPair, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	record := RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return tokenPair{}, err
+	}
+
+	accessToken, err := app.GenerateJWT(user, sessionID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: int(AccessTokenTTL.Seconds())}, nil
+}
+
+// startSession opens a new session for the user on the client's device and returns its first token pair
+func (app *Config) startSession(user User, client sessionClient) (tokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	var pair tokenPair
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		session := Session{
+			SessionID:   sessionID,
+			UserID:      user.ID,
+			DeviceLabel: client.DeviceLabel,
+			IPAddress:   client.IPAddress,
+			UserAgent:   client.UserAgent,
+			LastSeenAt:  time.Now(),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		pair, err = app.issueTokens(tx, user, sessionID)
+		return err
+	})
+	return pair, err
+}
+
+// rotateRefreshToken exchanges a refresh token for a new pair in the same session.
+// Presenting a token that was already rotated revokes the whole session, as it may have been stolen.
+func (app *Config) rotateRefreshToken(refreshToken string) (tokenPair, error) {
+	var pair tokenPair
+	var reusedSessionID string
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var record RefreshToken
+		if err := tx.Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRefreshTokenInvalid
+			}
+			return err
+		}
+
+		if record.RevokedAt != nil {
+			reusedSessionID = record.SessionID
+			return ErrRefreshTokenReused
+		}
+		if time.Now().After(record.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+
+		var user User
+		if err := tx.First(&user, record.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRefreshTokenInvalid
+			}
+			return err
+		}
+		if !user.Activated {
+			return ErrAccountDeactivated
+		}
+		if requiresTwoFactor(user.Role) && !user.TOTPEnabled {
+			return ErrTwoFactorRequired // The role started requiring 2FA or an Admin reset it
+		}
+
+		// Retire the presented token before handing out its successor
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND revoked_at IS NULL", record.ID).Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 { // Rotated concurrently by another request
+			reusedSessionID = record.SessionID
+			return ErrRefreshTokenReused
+		}
+
+		if err := tx.Model(&Session{}).Where("session_id = ?", record.SessionID).Update("last_seen_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = app.issueTokens(tx, user, record.SessionID)
+		return err
+	})
+
+	// Revoke outside the transaction, which was rolled back by the error
+	if reusedSessionID != "" {
+		if revokeErr := revokeSession(app.DB, reusedSessionID); revokeErr != nil {
+			return pair, revokeErr
+		}
+	}
+	return pair, err
+}
+
+// revokeSession revokes a session and every refresh token of it, which also ends its access tokens
+func revokeSession(tx *gorm.DB, sessionID string) error {
+	now := time.Now()
+	err := tx.Model(&RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Session{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error
+}
+
+// revokeUserSessions revokes every session of the user except keepSessionID (empty to revoke all)
+func revokeUserSessions(tx *gorm.DB, userID uint, keepSessionID string) error {
+	now := time.Now()
+	for _, model := range []interface{}{&RefreshToken{}, &Session{}} {
+		query := tx.Model(model).Where("user_id = ? AND revoked_at IS NULL", userID)
+		if keepSessionID != "" {
+			query = query.Where("session_id <> ?", keepSessionID)
+		}
+		if err := query.Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionActive reports whether the session still has a usable refresh token
+func sessionActive(tx *gorm.DB, sessionID string) (bool, error) {
+	var count int64
+	err := tx.Model(&RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
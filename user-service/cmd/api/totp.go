@@ -0,0 +1,714 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for two-factor authentication
+const (
+	EventTwoFactorEnabled  = "2fa_enabled"  // Enrollment confirmed
+	EventTwoFactorDisabled = "2fa_disabled" // Turned off by the user
+	EventTwoFactorReset    = "2fa_reset"    // Cleared by an Admin
+)
+
+// TOTP parameters of RFC 6238, the defaults every authenticator app understands
+const (
+	totpDigits     = 6
+	totpModulus    = 1000000 // 10^totpDigits
+	totpPeriod     = 30      // Seconds per time step
+	totpSkew       = 1       // Steps accepted before and after the current one, allows for clock drift
+	totpSecretSize = 20      // Bytes, the size of an HMAC-SHA1 key
+)
+
+const (
+	recoveryCodeCount = 10
+	mfaTokenTTL       = 5 * time.Minute // Time between the password and the second factor
+
+	// Purposes of a partial login token
+	mfaPurposeVerify = "verify" // The user has 2FA and has to send a code
+	mfaPurposeEnroll = "enroll" // The role requires 2FA and the user has to set it up first
+
+	ErrInvalidCode = "Invalid code"
+)
+
+// ErrTwoFactorRequired is returned when a session of a role with mandatory 2FA is renewed without 2FA
+var ErrTwoFactorRequired = errors.New("two-factor authentication required")
+
+// base32NoPadding encodes secrets and recovery codes the way authenticator apps expect them
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// requiresTwoFactor reports whether users of the role must use 2FA
+func requiresTwoFactor(role string) bool {
+	for _, required := range TwoFactorRequiredRoles {
+		if role == required {
+			return true
+		}
+	}
+	return false
+}
+
+// generateTOTPSecret returns a new random secret in base32
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// totpCode computes the code of a time step as described in RFC 4226 and RFC 6238
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// verifyTOTP checks a code against the secret around now. Steps up to lastStep were already
+// used and are refused. It returns the step the code belongs to.
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(key) == 0 || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI returns the Key URI authenticator apps import, usually shown as a QR code
+func otpauthURI(secret, username string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(TOTPIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes so codes can be typed as the user likes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores recoveryCodeCount new ones.
+// The plain codes are returned once and never stored.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b)) // 8 characters
+		record := RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return codes, nil
+}
+
+// useRecoveryCode marks an unused recovery code of the user as used and reports whether there was one
+func useRecoveryCode(tx *gorm.DB, userID uint, code string, now time.Time) (bool, error) {
+	result := tx.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// checkSecondFactor accepts a TOTP code of the user's secret or, when allowRecovery is set, an unused recovery code.
+// Every code works only once.
+func (app *Config) checkSecondFactor(user User, code string, now time.Time, allowRecovery bool) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := verifyTOTP(user.TOTPSecret, code, now, user.TOTPLastStep); ok {
+		// Conditional update, so two requests racing with the same code cannot both succeed
+		result := app.DB.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		return result.RowsAffected > 0, result.Error
+	}
+	if !allowRecovery || code == "" {
+		return false, nil
+	}
+	return useRecoveryCode(app.DB, user.ID, code, now)
+}
+
+// verifyCode checks a code sent by the user and writes the error response if it is not accepted.
+// Wrong codes count as failed logins, so guessing codes locks the account like guessing passwords.
+func (app *Config) verifyCode(w http.ResponseWriter, r *http.Request, user User, code string, allowRecovery bool, failStatus int) bool {
+	ip := clientIP(r)
+	now := time.Now()
+	if wait := app.LoginThrottle.RetryAfter(ip, now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, user.Username, ip, "IP address backoff")
+		app.recordLoginEvent(r, user.ID, user.Username, false, LoginReasonIPThrottled)
+		writeTooManyAttempts(w, wait)
+		return false
+	}
+	if wait := user.loginRetryAfter(now); wait > 0 {
+		app.recordSecurityEvent(EventLoginBlocked, user.Username, ip, "account backoff or lockout")
+		app.recordLoginEvent(r, user.ID, user.Username, false, LoginReasonAccountThrottled)
+		writeTooManyAttempts(w, wait)
+		return false
+	}
+
+	ok, err := app.checkSecondFactor(user, code, now, allowRecovery)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	locked, err := app.recordLoginFailure(user, now)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	app.LoginThrottle.Fail(ip, now)
+	app.recordSecurityEvent(EventLoginFailed, user.Username, ip, "wrong two-factor code")
+	app.recordLoginEvent(r, user.ID, user.Username, false, LoginReasonWrongCode)
+	if locked {
+		app.recordSecurityEvent(EventAccountLocked, user.Username, ip, fmt.Sprintf("locked for %s", LockoutDuration))
+	}
+	http.Error(w, ErrInvalidCode, failStatus)
+	return false
+}
+
+// generateMFAToken signs a partial login token. It proves the password was right and is only
+// accepted by the /login/2fa routes, never as an access token.
+func (app *Config) generateMFAToken(user User, purpose string) (string, error) {
+	claims := jwt.MapClaims{
+		"username":  user.Username,
+		"ver":       user.TokenVersion,
+		"token_use": "mfa",
+		"purpose":   purpose,
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(mfaTokenTTL).Unix(),
+	}
+	return app.Keys.Sign(claims)
+}
+
+// mfaUser verifies a partial login token of the given purpose and loads its user.
+// It writes the error response if the token cannot be used.
+func (app *Config) mfaUser(w http.ResponseWriter, tokenString, purpose string) (User, bool) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, app.Keys.Keyfunc)
+	if err != nil || !token.Valid || claims["token_use"] != "mfa" || claims["purpose"] != purpose {
+		http.Error(w, "Invalid or expired login token", http.StatusUnauthorized)
+		return User{}, false
+	}
+	username, _ := claims["username"].(string)
+	version, _ := claims["ver"].(float64)
+
+	var user User
+	if err := app.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Invalid or expired login token", http.StatusUnauthorized)
+			return User{}, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return User{}, false
+	}
+	if int(version) != user.TokenVersion {
+		http.Error(w, "Invalid or expired login token", http.StatusUnauthorized)
+		return User{}, false
+	}
+	if !user.Activated {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return User{}, false
+	}
+	return user, true
+}
+
+// writeMFAChallenge answers a correct password of a user who still has to pass the second factor
+func (app *Config) writeMFAChallenge(w http.ResponseWriter, user User) {
+	purpose := mfaPurposeVerify
+	message := "Two-factor authentication required, send a code to /login/2fa"
+	if !user.TOTPEnabled {
+		purpose = mfaPurposeEnroll
+		message = "Two-factor authentication is mandatory for your role, set it up with /login/2fa/enroll"
+	}
+
+	mfaToken, err := app.generateMFAToken(user, purpose)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                 message,
+		"mfa_required":            true,
+		"mfa_enrollment_required": purpose == mfaPurposeEnroll,
+		"mfa_token":               mfaToken,
+		"expires_in":              int(mfaTokenTTL.Seconds()),
+	})
+}
+
+// startEnrollment stores a new, not yet enabled secret for the user and returns it
+func (app *Config) startEnrollment(w http.ResponseWriter, user User) {
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := app.DB.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":     "Add the secret to your authenticator app and confirm it with a code",
+		"secret":      secret,
+		"otpauth_uri": otpauthURI(secret, user.Username),
+	})
+}
+
+// confirmEnrollment enables 2FA once a code of the pending secret is right and returns the recovery codes.
+// It writes the error response if the code is not accepted.
+func (app *Config) confirmEnrollment(w http.ResponseWriter, r *http.Request, user User, code string, failStatus int) ([]string, bool) {
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return nil, false
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, "Start the enrollment first", http.StatusConflict)
+		return nil, false
+	}
+	if !app.verifyCode(w, r, user, code, false, failStatus) {
+		return nil, false
+	}
+
+	var codes []string
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	app.recordSecurityEvent(EventTwoFactorEnabled, user.Username, clientIP(r), "")
+	return codes, true
+}
+
+// clearTwoFactor turns 2FA off for the user and deletes their recovery codes
+func clearTwoFactor(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// callerUser loads the full record of the authenticated caller
+func (app *Config) callerUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	caller, _ := authUserFromContext(r.Context())
+	var user User
+	if err := app.DB.First(&user, caller.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrUserNotFound, http.StatusNotFound)
+			return User{}, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return User{}, false
+	}
+	return user, true
+}
+
+// TwoFactorLoginHandler completes a login with a TOTP or recovery code
+// @Summary Complete login with a second factor
+// @Description Exchanges the mfa_token returned by /login and a TOTP code or unused recovery code for an access and a refresh token
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{MFAToken string `json:"mfa_token"`; Code string `json:"code"`} true "Partial login token and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Invalid code or invalid or expired login token"
+// @Failure 403 {string} string "Account is deactivated"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
+// @Router /login/2fa [post]
+func (app *Config) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.mfaUser(w, requestData.MFAToken, mfaPurposeVerify)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled { // Disabled since the password was checked
+		http.Error(w, "Invalid or expired login token", http.StatusUnauthorized)
+		return
+	}
+	if !app.verifyCode(w, r, user, requestData.Code, true, http.StatusUnauthorized) {
+		return
+	}
+
+	var remaining int64
+	if err := app.DB.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.completeLogin(w, r, user, LoginReasonTwoFactor, map[string]interface{}{"recovery_codes_left": remaining})
+}
+
+// TwoFactorLoginEnrollHandler starts the mandatory enrollment during login
+// @Summary Enroll in 2FA during login
+// @Description For roles with mandatory 2FA: exchanges the mfa_token returned by /login for a new TOTP secret and its otpauth URI
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{MFAToken string `json:"mfa_token"`} true "Partial login token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Invalid or expired login token"
+// @Failure 403 {string} string "Account is deactivated"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Failure 500 {string} string "Database error"
+// @Router /login/2fa/enroll [post]
+func (app *Config) TwoFactorLoginEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.mfaUser(w, requestData.MFAToken, mfaPurposeEnroll)
+	if !ok {
+		return
+	}
+	app.startEnrollment(w, user)
+}
+
+// TwoFactorLoginConfirmHandler finishes the mandatory enrollment and the login
+// @Summary Confirm 2FA enrollment during login
+// @Description Enables 2FA with the first code of the new secret and returns the tokens together with the one-time recovery codes
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body struct{MFAToken string `json:"mfa_token"`; Code string `json:"code"`} true "Partial login token and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Invalid code or invalid or expired login token"
+// @Failure 403 {string} string "Account is deactivated"
+// @Failure 409 {string} string "Enrollment not started or already confirmed"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
+// @Router /login/2fa/confirm [post]
+func (app *Config) TwoFactorLoginConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.mfaUser(w, requestData.MFAToken, mfaPurposeEnroll)
+	if !ok {
+		return
+	}
+	codes, ok := app.confirmEnrollment(w, r, user, requestData.Code, http.StatusUnauthorized)
+	if !ok {
+		return
+	}
+	app.completeLogin(w, r, user, LoginReasonTwoFactorEnroll, map[string]interface{}{"recovery_codes": codes})
+}
+
+// TwoFactorEnrollHandler starts the enrollment of the caller
+// @Summary Enroll in 2FA
+// @Description Generates a new TOTP secret for the caller and returns it with its otpauth URI. 2FA is enabled once /2fa/confirm receives a code of it.
+// @Tags users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Success 200 {object} map[string]string
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Failure 500 {string} string "Database error"
+// @Router /2fa/enroll [post]
+func (app *Config) TwoFactorEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.callerUser(w, r)
+	if !ok {
+		return
+	}
+	app.startEnrollment(w, user)
+}
+
+// TwoFactorConfirmHandler enables 2FA for the caller
+// @Summary Confirm 2FA enrollment
+// @Description Enables 2FA with the first code of the secret from /2fa/enroll and returns the one-time recovery codes
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{Code string `json:"code"`} true "TOTP code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Invalid code"
+// @Failure 409 {string} string "Enrollment not started or already confirmed"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
+// @Router /2fa/confirm [post]
+func (app *Config) TwoFactorConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.callerUser(w, r)
+	if !ok {
+		return
+	}
+	codes, ok := app.confirmEnrollment(w, r, user, requestData.Code, http.StatusForbidden)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled, store the recovery codes in a safe place",
+		"recovery_codes": codes,
+	})
+}
+
+// TwoFactorDisableHandler turns 2FA off for the caller
+// @Summary Disable 2FA
+// @Description Turns 2FA off after checking the current password and a TOTP or recovery code. Not possible for roles with mandatory 2FA.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{CurrentPassword string `json:"current_password"`; Code string `json:"code"`} true "Current password and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Mandatory for the role, current password is incorrect or invalid code"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
+// @Router /2fa/disable [post]
+func (app *Config) TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.callerUser(w, r)
+	if !ok {
+		return
+	}
+	if requiresTwoFactor(user.Role) {
+		http.Error(w, "Two-factor authentication is mandatory for your role", http.StatusForbidden)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	if !app.CheckPassword(user.Password, requestData.CurrentPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if !app.verifyCode(w, r, user, requestData.Code, true, http.StatusForbidden) {
+		return
+	}
+
+	if err := clearTwoFactor(app.DB, user.ID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	app.recordSecurityEvent(EventTwoFactorDisabled, user.Username, clientIP(r), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// RecoveryCodesHandler replaces the caller's recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replaces all recovery codes of the caller after checking a TOTP code. The old codes stop working.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param request body struct{Code string `json:"code"`} true "TOTP code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Invalid code"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed login attempts, try again later"
+// @Failure 500 {string} string "Database error"
+// @Router /2fa/recovery-codes [post]
+func (app *Config) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.callerUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	if !app.verifyCode(w, r, user, requestData.Code, false, http.StatusForbidden) {
+		return
+	}
+
+	var codes []string
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Recovery codes replaced, store them in a safe place",
+		"recovery_codes": codes,
+	})
+}
+
+// ResetTwoFactorHandler clears the 2FA of a user who lost their authenticator and recovery codes
+// @Summary Reset 2FA of a user
+// @Description Turns 2FA off for a user and ends their sessions. Users of roles with mandatory 2FA enroll again at their next login.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param requestBody body struct{Username string `json:"username"`} true "Username of the user"
+// @Success 200 {object} map[string]string
+// @Failure 400 {string} string "Invalid request body or missing username"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Database error"
+// @Router /reset-2fa [put]
+func (app *Config) ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+		return
+	}
+	if requestBody.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.Where("username = ?", requestBody.Username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrUserNotFound, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever holds the lost authenticator must not keep a session either
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := clearTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Update("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "")
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	caller, _ := authUserFromContext(r.Context())
+	app.recordSecurityEvent(EventTwoFactorReset, user.Username, clientIP(r), "reset by "+caller.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Two-factor authentication reset successfully",
+		"username": user.Username,
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// codeAt returns the TOTP code of the secret for the time step offset steps away from now
+func codeAt(t *testing.T, secret string, offset int64) string {
+	key, err := base32NoPadding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, time.Now().Unix()/totpPeriod+offset)
+}
+
+// decodeBody decodes a JSON response into a map
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	return body
+}
+
+// Test the codes against the SHA-1 test vectors of RFC 6238, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range vectors {
+		assert.Equal(t, code, totpCode(key, unix/totpPeriod))
+	}
+
+	secret := base32NoPadding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	step, ok := verifyTOTP(secret, "081804", now, 0)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second), 0)
+	assert.True(t, ok, "Codes of the previous step are accepted")
+	_, ok = verifyTOTP(secret, "081804", now, step)
+	assert.False(t, ok, "Used steps are refused")
+	_, ok = verifyTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second), 0)
+	assert.False(t, ok)
+
+	uri := otpauthURI(secret, "alice")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/"))
+	assert.Contains(t, uri, "secret="+secret)
+}
+
+// Test optional 2FA: enrollment, the partial login token, codes and recovery codes that work only once
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	token := bearer(t, app, rep)
+
+	rec := postJSON(router, "/2fa/enroll", token, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	secret := decodeBody(t, rec)["secret"].(string)
+
+	rec = postJSON(router, "/2fa/confirm", token, `{"code":"000000"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	endBackoff(t, app, "rep")
+	rec = postJSON(router, "/2fa/confirm", token, `{"code":"`+codeAt(t, secret, 0)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	codes := decodeBody(t, rec)["recovery_codes"].([]interface{})
+	require.Len(t, codes, recoveryCodeCount)
+
+	// The password alone only gives a partial token, which is no access token
+	rec = postJSON(router, "/login", "", `{"username":"rep","password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := decodeBody(t, rec)
+	assert.Equal(t, true, body["mfa_required"])
+	assert.Nil(t, body["token"])
+	mfaToken := body["mfa_token"].(string)
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+mfaToken, rep))
+
+	// The code used for the enrollment cannot be replayed, the next one works
+	rec = postJSON(router, "/login/2fa", "", `{"mfa_token":"`+mfaToken+`","code":"`+codeAt(t, secret, 0)+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	endBackoff(t, app, "rep")
+	rec = postJSON(router, "/login/2fa", "", `{"mfa_token":"`+mfaToken+`","code":"`+codeAt(t, secret, 1)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body = decodeBody(t, rec)
+	assert.Equal(t, http.StatusOK, getUser(router, "Bearer "+body["token"].(string), rep))
+
+	// Recovery codes work once, typed in upper case or without the dash
+	recovery := strings.ToUpper(strings.ReplaceAll(codes[0].(string), "-", ""))
+	rec = postJSON(router, "/login/2fa", "", `{"mfa_token":"`+mfaToken+`","code":"`+recovery+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, float64(recoveryCodeCount-1), decodeBody(t, rec)["recovery_codes_left"])
+	rec = postJSON(router, "/login/2fa", "", `{"mfa_token":"`+mfaToken+`","code":"`+recovery+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Turning 2FA off takes the password and a code
+	endBackoff(t, app, "rep")
+	rec = postJSON(router, "/2fa/disable", token, `{"current_password":"Password123","code":"`+codes[1].(string)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	login(t, router, "rep", "Password123")
+}
+
+// Test that roles with mandatory 2FA enroll during login and that an Admin can reset 2FA
+func TestMandatoryTwoFactor(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	other := createTestUser(t, app, "other", "Password123", RoleAdmin)
+
+	// Sessions opened before the enrollment cannot be renewed
+	pair, err := app.startSession(admin, sessionClient{})
+	require.NoError(t, err)
+	rec := postJSON(router, "/token/refresh", "", `{"refresh_token":"`+pair.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postJSON(router, "/login", "", `{"username":"admin","password":"Password123"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := decodeBody(t, rec)
+	assert.Equal(t, true, body["mfa_enrollment_required"])
+	mfaToken := body["mfa_token"].(string)
+
+	rec = postJSON(router, "/login/2fa", "", `{"mfa_token":"`+mfaToken+`","code":"000000"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "An enrollment token cannot complete a login")
+
+	rec = postJSON(router, "/login/2fa/enroll", "", `{"mfa_token":"`+mfaToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	secret := decodeBody(t, rec)["secret"].(string)
+	rec = postJSON(router, "/login/2fa/confirm", "", `{"mfa_token":"`+mfaToken+`","code":"`+codeAt(t, secret, 0)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body = decodeBody(t, rec)
+	assert.NotEmpty(t, body["token"])
+	assert.Len(t, body["recovery_codes"], recoveryCodeCount)
+	token := "Bearer " + body["token"].(string)
+
+	rec = postJSON(router, "/2fa/disable", token, `{"current_password":"Password123","code":"`+codeAt(t, secret, 1)+`"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Another Admin resets the 2FA, which also ends the sessions
+	req := httptest.NewRequest(http.MethodPut, "/reset-2fa", strings.NewReader(`{"username":"admin"}`))
+	req.Header.Set("Authorization", bearer(t, app, other))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, token, admin))
+
+	var stored User
+	require.NoError(t, app.DB.First(&stored, admin.ID).Error)
+	assert.False(t, stored.TOTPEnabled)
+	assert.Empty(t, stored.TOTPSecret)
+	var remaining int64
+	app.DB.Model(&RecoveryCode{}).Where("user_id = ?", admin.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Types of SecurityEvent recorded for deleted users
+const (
+	EventUserDeleted  = "user_deleted"  // Moved to the trash by an Admin
+	EventUserRestored = "user_restored" // Taken back out of the trash by an Admin
+)
+
+const (
+	trashPurgeInterval = time.Hour // How often users deleted longer than TrashRetention ago are purged
+
+	ErrUserNotInTrash = "User not found in trash"
+)
+
+// purgeDeletedUsers hard-deletes the users deleted before the retention window together with their
+// tokens, sessions, recovery codes, login history and password history, and returns how many users were purged.
+// Security events are kept, they name users by username only.
+func purgeDeletedUsers(db *gorm.DB, now time.Time, retention time.Duration) (int64, error) {
+	var ids []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&User{}).Where("deleted_at < ?", now.Add(-retention)).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, model := range []interface{}{&RefreshToken{}, &Session{}, &RecoveryCode{}, &PasswordResetToken{}, &LoginEvent{}, &PasswordHistory{}} {
+			if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&User{}, ids).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// StartTrashPurging purges users deleted longer than retention ago at startup and then once an hour
+func (app *Config) StartTrashPurging(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purged, err := purgeDeletedUsers(app.DB, time.Now(), retention)
+			if err != nil {
+				log.Printf("⚠️ Failed to purge deleted users: %v", err)
+			} else if purged > 0 {
+				fmt.Printf("🧹 Purged %d users deleted more than %s ago\n", purged, retention)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// DeletedUsersHandler lists the users in the trash
+// @Summary List deleted users
+// @Description Lists the users moved to the trash by /delete-user, most recently deleted first, with the time they were deleted. Results are paginated with an opaque cursor; the next page is advertised in the Link header.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page's Link header"
+// @Success 200 {array} userResponse
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Database error"
+// @Router /users/trash [get]
+func (app *Config) DeletedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	sort, _ := parseSort("", "-deleted_at", deletedSortFields)
+	limit, err := parsePageSize(params.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := app.DB.Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL")
+
+	// Continue after the cursor, if one was given
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw, sort)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clause, args, err := sort.AfterCursor(cursor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(clause, args...)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var users []User
+	if err := query.Order(sort.OrderClause()).Limit(limit + 1).Find(&users).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[len(users)-1]
+		next = encodeCursor(pageCursor{Sort: sort.Key, Value: last.DeletedAt.Time.Format(time.RFC3339Nano), ID: last.ID})
+	}
+
+	response := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newUserResponse(user))
+	}
+
+	// Send JSON response with pagination links
+	setPaginationLinks(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreUserHandler takes a user back out of the trash
+// @Summary Restore a deleted user
+// @Description Takes a user deleted by /delete-user back out of the trash. The user keeps their role and activation and has to log in again, as deleting ended all of their sessions.
+// @Tags Users
+// @Produce json
+// @Param Authorization header string true "Bearer JWT token"
+// @Param id path int true "User ID"
+// @Success 200 {object} userResponse
+// @Failure 400 {string} string "Invalid user ID"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found in trash"
+// @Failure 500 {string} string "Database error"
+// @Router /users/{id}/restore [post]
+func (app *Config) RestoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := app.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, ErrUserNotInTrash, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.DB.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+
+	caller, _ := authUserFromContext(r.Context())
+	app.recordSecurityEvent(EventUserRestored, user.Username, clientIP(r), "restored by "+caller.Username)
+	log.Printf("AUDIT: user %s restored from the trash by admin %s", user.Username, caller.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteUser moves the user to the trash with /delete-user and returns the status code
+func deleteUser(router http.Handler, token, username string) int {
+	req := httptest.NewRequest(http.MethodDelete, "/delete-user", strings.NewReader(`{"username":"`+username+`"}`))
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Test that a deleted user is hidden, listed in the trash and can be restored
+func TestDeleteAndRestoreUser(t *testing.T) {
+	enableSelfRegistration(t)
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	token := bearer(t, app, admin)
+	pair := login(t, router, "rep", "Password123")
+
+	require.Equal(t, http.StatusOK, deleteUser(router, token, "rep"))
+	assert.Equal(t, http.StatusUnauthorized, getUser(router, "Bearer "+pair.AccessToken, rep), "Sessions end on delete")
+	rec := postJSON(router, "/login", "", `{"username":"rep","password":"Password123"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	names, _ := listUsers(t, router, token, "/users")
+	assert.Equal(t, []string{"admin"}, names)
+	assert.Equal(t, http.StatusNotFound, deleteUser(router, token, "rep"), "Deleted users cannot be deleted again")
+
+	// The name stays taken while the user is in the trash
+	rec = postJSON(router, "/register", "", `{"username":"rep","mailaddress":"new@example.com","password":"Password123"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/trash", nil)
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var trash []userResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&trash))
+	require.Len(t, trash, 1)
+	assert.Equal(t, "rep", trash[0].Username)
+	assert.NotNil(t, trash[0].DeletedAt)
+
+	// Only Admins restore, and only users in the trash
+	restore := fmt.Sprintf("/users/%d/restore", rep.ID)
+	other := createTestUser(t, app, "other", "Password123", RoleSalesRep)
+	assert.Equal(t, http.StatusForbidden, postJSON(router, restore, bearer(t, app, other), "").Code)
+	assert.Equal(t, http.StatusNotFound, postJSON(router, fmt.Sprintf("/users/%d/restore", admin.ID), token, "").Code)
+
+	rec = postJSON(router, restore, token, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Nil(t, decodeBody(t, rec)["DeletedAt"])
+	names, _ = listUsers(t, router, token, "/users")
+	assert.Equal(t, []string{"admin", "other", "rep"}, names)
+	login(t, router, "rep", "Password123")
+	assert.Equal(t, http.StatusNotFound, postJSON(router, restore, token, "").Code)
+
+	var events []SecurityEvent
+	require.NoError(t, app.DB.Where("username = ?", "rep").Order("id").Find(&events).Error)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Subset(t, types, []string{EventUserDeleted, EventUserRestored})
+}
+
+// Test that users deleted before the retention window are purged with their tokens
+func TestPurgeDeletedUsers(t *testing.T) {
+	app := newTestApp(t)
+	old := createTestUser(t, app, "old", "Password123", RoleSalesRep)
+	recent := createTestUser(t, app, "recent", "Password123", RoleSalesRep)
+	kept := createTestUser(t, app, "kept", "Password123", RoleSalesRep)
+	bearer(t, app, old)
+	bearer(t, app, kept)
+
+	now := time.Now()
+	require.NoError(t, app.DB.Model(&User{}).Where("id = ?", old.ID).Update("deleted_at", now.Add(-31*24*time.Hour)).Error)
+	require.NoError(t, app.DB.Delete(&recent).Error)
+
+	purged, err := purgeDeletedUsers(app.DB, now, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var users []User
+	require.NoError(t, app.DB.Unscoped().Order("id").Find(&users).Error)
+	require.Len(t, users, 2)
+	assert.Equal(t, "recent", users[0].Username, "Recently deleted users stay in the trash")
+	assert.Equal(t, "kept", users[1].Username)
+
+	var tokens []RefreshToken
+	require.NoError(t, app.DB.Find(&tokens).Error)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, kept.ID, tokens[0].UserID)
+	var sessions int64
+	require.NoError(t, app.DB.Model(&Session{}).Where("user_id = ?", old.ID).Count(&sessions).Error)
+	assert.Zero(t, sessions)
+}
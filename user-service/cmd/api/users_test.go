@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextLinkPattern extracts the next page from a Link header
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>; rel="next"`)
+
+// listUsers sends GET to path and returns the usernames of the page and the next page link
+func listUsers(t *testing.T, router http.Handler, token, path string) ([]string, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), "Password", "Password hashes are never returned")
+
+	var users []map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&users))
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user["Username"].(string))
+	}
+
+	next := ""
+	if match := nextLinkPattern.FindStringSubmatch(rec.Header().Get("Link")); match != nil {
+		next = match[1]
+	}
+	return names, next
+}
+
+// getStatus sends GET to path and returns the status code
+func getStatus(router http.Handler, path, token string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Test filtering, searching and paging through the user list
+func TestListUsers(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	token := bearer(t, app, admin)
+	for _, name := range []string{"carol", "bob", "alice", "dave_x"} {
+		createTestUser(t, app, name, "Password123", RoleSalesRep)
+	}
+	require.NoError(t, app.DB.Model(&User{}).Where("username = ?", "bob").Update("activated", false).Error)
+	require.NoError(t, app.DB.Model(&User{}).Where("username = ?", "carol").Update("login_status", true).Error)
+
+	// Follow the Link header through all pages
+	names, next := listUsers(t, router, token, "/users?limit=2")
+	assert.Equal(t, []string{"admin", "alice"}, names)
+	require.NotEmpty(t, next)
+	names, next = listUsers(t, router, token, next)
+	assert.Equal(t, []string{"bob", "carol"}, names)
+	names, next = listUsers(t, router, token, next)
+	assert.Equal(t, []string{"dave_x"}, names)
+	assert.Empty(t, next)
+
+	names, _ = listUsers(t, router, token, "/users?role="+url.QueryEscape(RoleSalesRep)+"&activated=true&sort=-username")
+	assert.Equal(t, []string{"dave_x", "carol", "alice"}, names)
+	names, _ = listUsers(t, router, token, "/users?login_status=true")
+	assert.Equal(t, []string{"carol"}, names)
+
+	// The search ignores case and treats wildcards literally
+	names, _ = listUsers(t, router, token, "/users?q=ALI")
+	assert.Equal(t, []string{"alice"}, names)
+	names, _ = listUsers(t, router, token, "/users?q=_")
+	assert.Equal(t, []string{"dave_x"}, names)
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	names, _ = listUsers(t, router, token, "/users?created_after="+tomorrow)
+	assert.Empty(t, names)
+	names, _ = listUsers(t, router, token, "/users?created_before="+tomorrow+"&sort=-created_at&limit=1")
+	assert.Equal(t, []string{"dave_x"}, names)
+
+	for _, path := range []string{"/users?role=Manager", "/users?activated=maybe", "/users?sort=password", "/users?created_after=yesterday", "/users?cursor=nope"} {
+		assert.Equal(t, http.StatusBadRequest, getStatus(router, path, token), path)
+	}
+
+	// Sales representatives cannot list users
+	rep := User{}
+	require.NoError(t, app.DB.Where("username = ?", "alice").First(&rep).Error)
+	assert.Equal(t, http.StatusForbidden, getStatus(router, "/users", bearer(t, app, rep)))
+}
+
+// Test that reading a single user leaves out the password hash and the 2FA secret
+func TestGetUserHidesSecrets(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	require.NoError(t, app.DB.Model(&admin).Update("totp_secret", "JBSWY3DPEHPK3PXP").Error)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user?id=%d", admin.ID), nil)
+	req.Header.Set("Authorization", bearer(t, app, admin))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	raw := rec.Body.String()
+	assert.NotContains(t, raw, admin.Password)
+	assert.NotContains(t, raw, "JBSWY3DPEHPK3PXP")
+	body := decodeBody(t, rec)
+	assert.Equal(t, "admin", body["Username"])
+	assert.NotContains(t, body, "Password")
+}
+
+// Test that reps get 403 for every other ID, existing or not, so they cannot probe which users exist
+func TestGetUserAccess(t *testing.T) {
+	app := newTestApp(t)
+	router := app.routes()
+	admin := createTestUser(t, app, "admin", "Password123", RoleAdmin)
+	rep := createTestUser(t, app, "rep", "Password123", RoleSalesRep)
+	get := func(token, id string) int {
+		req := httptest.NewRequest(http.MethodGet, "/user?id="+id, nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	repToken := bearer(t, app, rep)
+	assert.Equal(t, http.StatusOK, get(repToken, fmt.Sprint(rep.ID)))
+	assert.Equal(t, http.StatusForbidden, get(repToken, fmt.Sprint(admin.ID)))
+	assert.Equal(t, http.StatusForbidden, get(repToken, "999"))
+	assert.Equal(t, http.StatusBadRequest, get(repToken, "1%20OR%201=1"))
+
+	adminToken := bearer(t, app, admin)
+	assert.Equal(t, http.StatusOK, get(adminToken, fmt.Sprint(rep.ID)))
+	assert.Equal(t, http.StatusNotFound, get(adminToken, "999"))
+	assert.Equal(t, http.StatusBadRequest, get(adminToken, ""))
+}